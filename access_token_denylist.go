@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/fernando8franco/http-server-golang/internal/database"
+	"github.com/google/uuid"
+)
+
+// Access tokens are stateless, so revoking a session doesn't stop the access
+// tokens it already handed out. Each refresh token row records the jti of the
+// access token issued with it, and revoking the session denies those jtis
+// until they would have expired anyway. Only rows newer than the access token
+// lifetime can still have a valid access token. Both are measured on the
+// database clock, which set created_at.
+
+func denyFamilyAccessTokens(ctx context.Context, db database.Querier, familyID uuid.UUID, lifetime time.Duration) error {
+	_, err := db.DenyFamilyAccessTokens(ctx, database.DenyFamilyAccessTokensParams{
+		ExpiresInSeconds:    lifetime.Seconds(),
+		FamilyID:            familyID,
+		IssuedWithinSeconds: lifetime.Seconds(),
+	})
+	return err
+}
+
+// denyUserAccessTokens denies every access token of userID except exceptJti,
+// so the request that triggered it can keep its own token.
+func denyUserAccessTokens(ctx context.Context, db database.Querier, userID uuid.UUID, exceptJti string, lifetime time.Duration) error {
+	_, err := db.DenyUserAccessTokens(ctx, database.DenyUserAccessTokensParams{
+		ExpiresInSeconds:    lifetime.Seconds(),
+		UserID:              userID,
+		IssuedWithinSeconds: lifetime.Seconds(),
+		ExceptJti:           exceptJti,
+	})
+	return err
+}
+
+// purgeDeniedAccessTokens removes the denylist entries whose access tokens
+// have expired, once right away and then every interval, until ctx is done.
+func (ac *apiConfig) purgeDeniedAccessTokens(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := ac.db.PurgeDeniedAccessTokens(ctx)
+		if err != nil {
+			log.Printf("Couldn't purge the denied access tokens: %s", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d denied access tokens", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/fernando8franco/http-server-golang/internal/database"
+)
+
+func runAdmin(db database.Querier, args []string) error {
+	if len(args) > 0 && args[0] == "keys" {
+		return runKeys(os.Getenv("JWT_KEYRING"), os.Getenv("SECRET"), args[1:])
+	}
+
+	if len(args) == 2 && (args[0] == "suspend" || args[0] == "unsuspend") {
+		return runSuspend(db, args[1], args[0] == "suspend")
+	}
+
+	if len(args) != 2 || (args[0] != "grant" && args[0] != "revoke") {
+		return fmt.Errorf("usage: %s admin grant|revoke|suspend|unsuspend <email> | keys ...", os.Args[0])
+	}
+
+	user, err := db.SetUserAdmin(
+		context.Background(),
+		database.SetUserAdminParams{
+			Email:   args[1],
+			IsAdmin: args[0] == "grant",
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("couldn't update %s: %w", args[1], err)
+	}
+
+	log.Printf("User %s is_admin = %t", user.Email, user.IsAdmin)
+	return nil
+}
+
+// runSuspend suspends or reinstates the user with email. Suspending also ends
+// every session and denies the access tokens that are still valid.
+func runSuspend(db database.Querier, email string, suspended bool) error {
+	ctx := context.Background()
+
+	user, err := db.SetUserSuspended(ctx, database.SetUserSuspendedParams{
+		Suspended: suspended,
+		Email:     email,
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't update %s: %w", email, err)
+	}
+
+	if suspended {
+		_, err = db.RevokeAllSessions(ctx, user.ID)
+		if err != nil {
+			return fmt.Errorf("couldn't revoke the sessions of %s: %w", email, err)
+		}
+		err = denyUserAccessTokens(ctx, db, user.ID, "", accessTokenExpiration)
+		if err != nil {
+			return fmt.Errorf("couldn't revoke the access tokens of %s: %w", email, err)
+		}
+	}
+
+	log.Printf("User %s suspended = %t", user.Email, user.SuspendedAt.Valid)
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fernando8franco/http-server-golang/internal/auth"
+	"github.com/fernando8franco/http-server-golang/internal/authz"
+	"github.com/fernando8franco/http-server-golang/internal/database"
+	"github.com/google/uuid"
+)
+
+var errUserSuspended = fmt.Errorf("%w: the user is suspended", auth.ErrPrincipalNotFound)
+
+// loadPrincipal looks up the user behind a validated access token. Suspended
+// users are rejected like deleted ones.
+func (ac *apiConfig) loadPrincipal(ctx context.Context, userId uuid.UUID) (authz.Principal, error) {
+	user, err := ac.db.GetUserById(ctx, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return authz.Principal{}, fmt.Errorf("%w: %w", auth.ErrPrincipalNotFound, err)
+	}
+	if err != nil {
+		return authz.Principal{}, err
+	}
+	if user.SuspendedAt.Valid {
+		return authz.Principal{}, errUserSuspended
+	}
+
+	return authz.Principal{
+		UserID:        user.ID,
+		IsAdmin:       user.IsAdmin,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+	}, nil
+}
+
+// loadPersonalAccessToken looks up the user behind a personal access token
+// and limits the principal to the scopes of the token. The expiry was given in
+// UTC, so it is checked against the time here rather than the database clock.
+func (ac *apiConfig) loadPersonalAccessToken(ctx context.Context, tokenHash string) (authz.Principal, error) {
+	accessToken, err := ac.db.UsePersonalAccessToken(ctx, database.UsePersonalAccessTokenParams{
+		Now:       time.Now().UTC(),
+		TokenHash: tokenHash,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return authz.Principal{}, fmt.Errorf("%w: %w", auth.ErrPrincipalNotFound, err)
+	}
+	if err != nil {
+		return authz.Principal{}, err
+	}
+
+	principal, err := ac.loadPrincipal(ctx, accessToken.UserID)
+	if err != nil {
+		return authz.Principal{}, err
+	}
+
+	principal.Scopes = parseScopes(accessToken.Scopes)
+	return principal, nil
+}
+
+// authorize responds with 403 and returns false when principal may not
+// perform action on resource.
+func authorize(w http.ResponseWriter, principal authz.Principal, action authz.Action, resource authz.Resource) bool {
+	err := authz.Authorize(principal, action, resource)
+	if err != nil {
+		respondWithError(w, http.StatusForbidden, "You are not allowed to perform this action", err)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// purgeDeletedChirps removes the chirps that were soft deleted longer than
+// chirpRetention ago, once right away and then every interval, until ctx is
+// done.
+func (ac *apiConfig) purgeDeletedChirps(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := ac.db.PurgeDeletedChirps(ctx, ac.chirpRetention.Seconds())
+		if err != nil {
+			log.Printf("Couldn't purge the deleted chirps: %s", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d deleted chirps", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,26 +1,81 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/fernando8franco/http-server-golang/internal/auth"
+	"github.com/fernando8franco/http-server-golang/internal/authz"
 	"github.com/fernando8franco/http-server-golang/internal/database"
 	"github.com/google/uuid"
 )
 
+const maxChirpLength = 140
+
 type Chirp struct {
+	Id        uuid.UUID   `json:"id"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+	Body      string      `json:"body"`
+	UserId    uuid.UUID   `json:"user_id"`
+	Edited    bool        `json:"edited"`
+	Author    ChirpAuthor `json:"author"`
+}
+
+// ChirpAuthor is the part of the author's profile shown with each chirp.
+type ChirpAuthor struct {
+	Id          uuid.UUID `json:"id"`
+	Handle      string    `json:"handle,omitempty"`
+	DisplayName string    `json:"display_name"`
+	AvatarUrl   string    `json:"avatar_url"`
+}
+
+type ChirpRevision struct {
 	Id        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ChirpId   uuid.UUID `json:"chirp_id"`
 	Body      string    `json:"body"`
-	UserId    uuid.UUID `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func chirpFromDatabase(chirp database.Chirp, authors map[uuid.UUID]ChirpAuthor) Chirp {
+	return Chirp{
+		Id:        chirp.ID,
+		CreatedAt: chirp.CreatedAt,
+		UpdatedAt: chirp.UpdatedAt,
+		Body:      chirp.Body,
+		UserId:    chirp.UserID,
+		Edited:    chirp.EditedAt.Valid,
+		Author:    authors[chirp.UserID],
+	}
+}
+
+// chirpAuthors looks up the authors with userIDs in a single query, so a page
+// of chirps doesn't cost a query per chirp.
+func (ac *apiConfig) chirpAuthors(ctx context.Context, userIDs ...uuid.UUID) (map[uuid.UUID]ChirpAuthor, error) {
+	rows, err := ac.db.ListChirpAuthors(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	authors := make(map[uuid.UUID]ChirpAuthor, len(rows))
+	for _, row := range rows {
+		authors[row.ID] = ChirpAuthor{
+			Id:          row.ID,
+			Handle:      row.Handle.String,
+			DisplayName: row.DisplayName,
+			AvatarUrl:   row.AvatarUrl,
+		}
+	}
+
+	return authors, nil
 }
 
 func (ac *apiConfig) createChirp(w http.ResponseWriter, r *http.Request) {
-	const maxChirpLength = 140
 	type parameters struct {
 		Body string `json:"body"`
 	}
@@ -28,21 +83,20 @@ func (ac *apiConfig) createChirp(w http.ResponseWriter, r *http.Request) {
 		Chirp
 	}
 
-	accessToken, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find the refresh token", err)
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	if !authorize(w, principal, authz.CreateChirp, authz.Resource{OwnerID: principal.UserID}) {
 		return
 	}
 
-	userId, err := auth.ValidateJWT(accessToken, ac.secret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate the token", err)
+	if ac.requireVerifiedEmail && !principal.EmailVerified {
+		respondWithError(w, http.StatusForbidden, "Verify your email address before chirping", nil)
 		return
 	}
 
 	params := parameters{}
 	decoder := json.NewDecoder(r.Body)
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode the parameters", err)
 		return
@@ -58,7 +112,7 @@ func (ac *apiConfig) createChirp(w http.ResponseWriter, r *http.Request) {
 		r.Context(),
 		database.CreateChirpParams{
 			Body:   cleanBody,
-			UserID: userId,
+			UserID: principal.UserID,
 		},
 	)
 	if err != nil {
@@ -66,39 +120,143 @@ func (ac *apiConfig) createChirp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	authors, err := ac.chirpAuthors(r.Context(), chirp.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve the author", err)
+		return
+	}
+
 	resp := response{
-		Chirp: Chirp{
-			Id:        chirp.ID,
-			CreatedAt: chirp.CreatedAt,
-			UpdatedAt: chirp.UpdatedAt,
-			Body:      chirp.Body,
-			UserId:    chirp.UserID,
-		},
+		Chirp: chirpFromDatabase(chirp, authors),
 	}
 
 	respondWithJSON(w, http.StatusCreated, resp)
 }
 
+type chirpListQuery struct {
+	params    database.ListChirpsParams
+	afterRank sql.NullFloat64
+	pageSize  int
+	desc      bool
+}
+
+// parseChirpListQuery reads the pagination and filter parameters shared by
+// the chirp listings, whose cursors are of kind. It responds with 400 and
+// returns false when one of them is invalid.
+func parseChirpListQuery(w http.ResponseWriter, r *http.Request, kind string) (chirpListQuery, bool) {
+	query := r.URL.Query()
+
+	pageSize, err := parsePageSize(query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid limit", err)
+		return chirpListQuery{}, false
+	}
+
+	list := chirpListQuery{
+		params: database.ListChirpsParams{
+			// One extra row tells whether there is a next page.
+			PageSize: int32(pageSize + 1),
+		},
+		pageSize: pageSize,
+	}
+
+	switch query.Get("sort") {
+	case "", "asc":
+	case "desc":
+		list.desc = true
+	default:
+		respondWithError(w, http.StatusBadRequest, "Invalid sort, use asc or desc", nil)
+		return chirpListQuery{}, false
+	}
+
+	if cursorStr := query.Get("cursor"); cursorStr != "" {
+		after, err := decodeCursor(cursorStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+			return chirpListQuery{}, false
+		}
+		if after.Kind != kind {
+			respondWithError(w, http.StatusBadRequest, "The cursor belongs to another listing", nil)
+			return chirpListQuery{}, false
+		}
+		list.afterRank = sql.NullFloat64{Float64: after.Rank, Valid: true}
+		list.params.AfterCreatedAt = sql.NullTime{Time: after.CreatedAt, Valid: true}
+		list.params.AfterID = uuid.NullUUID{UUID: after.Id, Valid: true}
+	}
+
+	if authorIdStr := query.Get("author_id"); authorIdStr != "" {
+		authorId, err := uuid.Parse(authorIdStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid author Id", err)
+			return chirpListQuery{}, false
+		}
+		list.params.AuthorID = uuid.NullUUID{UUID: authorId, Valid: true}
+	}
+
+	if sinceStr := query.Get("since"); sinceStr != "" {
+		since, err := time.Parse(time.RFC3339, sinceStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid since, use RFC 3339", err)
+			return chirpListQuery{}, false
+		}
+		list.params.Since = sql.NullTime{Time: since.UTC(), Valid: true}
+	}
+
+	if untilStr := query.Get("until"); untilStr != "" {
+		until, err := time.Parse(time.RFC3339, untilStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid until, use RFC 3339", err)
+			return chirpListQuery{}, false
+		}
+		list.params.Until = sql.NullTime{Time: until.UTC(), Valid: true}
+	}
+
+	if list.params.Since.Valid && list.params.Until.Valid && !list.params.Since.Time.Before(list.params.Until.Time) {
+		respondWithError(w, http.StatusBadRequest, "since must be before until", nil)
+		return chirpListQuery{}, false
+	}
+
+	return list, true
+}
+
 func (ac *apiConfig) getAllChirps(w http.ResponseWriter, r *http.Request) {
-	chirps, err := ac.db.GetAllChirps(r.Context())
+	list, ok := parseChirpListQuery(w, r, cursorKindList)
+	if !ok {
+		return
+	}
+
+	var chirps []database.Chirp
+	var err error
+	if list.desc {
+		chirps, err = ac.db.ListChirpsDesc(r.Context(), database.ListChirpsDescParams(list.params))
+	} else {
+		chirps, err = ac.db.ListChirps(r.Context(), list.params)
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve the chirps", err)
 		return
 	}
 
+	if len(chirps) > list.pageSize {
+		chirps = chirps[:list.pageSize]
+		last := chirps[len(chirps)-1]
+		setNextLink(w, r, cursor{Kind: cursorKindList, CreatedAt: last.CreatedAt, Id: last.ID})
+	}
+
+	userIDs := make([]uuid.UUID, len(chirps))
+	for i, chirp := range chirps {
+		userIDs[i] = chirp.UserID
+	}
+	authors, err := ac.chirpAuthors(r.Context(), userIDs...)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve the authors", err)
+		return
+	}
+
 	resp := []Chirp{}
 
 	for _, chirp := range chirps {
-		resp = append(
-			resp,
-			Chirp{
-				Id:        chirp.ID,
-				CreatedAt: chirp.CreatedAt,
-				UpdatedAt: chirp.UpdatedAt,
-				Body:      chirp.Body,
-				UserId:    chirp.UserID,
-			},
-		)
+		resp = append(resp, chirpFromDatabase(chirp, authors))
 	}
 
 	respondWithJSON(w, http.StatusOK, resp)
@@ -122,14 +280,14 @@ func (ac *apiConfig) getOneChirp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	authors, err := ac.chirpAuthors(r.Context(), chirp.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve the author", err)
+		return
+	}
+
 	resp := response{
-		Chirp: Chirp{
-			Id:        chirp.ID,
-			CreatedAt: chirp.CreatedAt,
-			UpdatedAt: chirp.UpdatedAt,
-			Body:      chirp.Body,
-			UserId:    chirp.UserID,
-		},
+		Chirp: chirpFromDatabase(chirp, authors),
 	}
 
 	respondWithJSON(w, http.StatusOK, resp)
@@ -143,31 +301,192 @@ func (ac *apiConfig) deleteChirp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	accessToken, err := auth.GetBearerToken(r.Header)
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	chirp, err := ac.db.GetOneChirp(r.Context(), chirpId)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find the refresh token", err)
+		respondWithError(w, http.StatusNotFound, "Couldn't retrieve the chirp", err)
+		return
+	}
+
+	if !authorize(w, principal, authz.DeleteChirp, authz.Resource{OwnerID: chirp.UserID}) {
 		return
 	}
 
-	userId, err := auth.ValidateJWT(accessToken, ac.secret)
+	deleted, err := ac.db.SoftDeleteChirpById(r.Context(), chirpId)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate the token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete the chirp", err)
 		return
 	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "Couldn't delete the chirp", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (ac *apiConfig) restoreChirp(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Chirp
+	}
 
-	hasChirp, err := ac.db.UserOwnsChirp(r.Context(), userId)
-	if err != nil || !hasChirp {
-		respondWithError(w, http.StatusForbidden, "Couldn't validate the user", err)
+	chirpIdStr := r.PathValue("chirpId")
+	chirpId, err := uuid.Parse(chirpIdStr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp Id", err)
 		return
 	}
 
-	err = ac.db.DeleteChirpById(r.Context(), chirpId)
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	chirp, err := ac.db.GetChirpIncludingDeleted(r.Context(), chirpId)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't delete the chirp", err)
+		respondWithError(w, http.StatusNotFound, "Couldn't retrieve the chirp", err)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	if !authorize(w, principal, authz.RestoreChirp, authz.Resource{OwnerID: chirp.UserID}) {
+		return
+	}
+
+	if !chirp.DeletedAt.Valid {
+		respondWithError(w, http.StatusConflict, "The chirp is not deleted", nil)
+		return
+	}
+
+	// The window is checked against the database clock, which set deleted_at.
+	restored, err := ac.db.RestoreChirp(r.Context(), database.RestoreChirpParams{
+		ID:            chirpId,
+		WindowSeconds: ac.restoreWindow.Seconds(),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusGone, "The chirp can no longer be restored", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore the chirp", err)
+		return
+	}
+
+	authors, err := ac.chirpAuthors(r.Context(), restored.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve the author", err)
+		return
+	}
+
+	resp := response{
+		Chirp: chirpFromDatabase(restored, authors),
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (ac *apiConfig) updateChirp(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body string `json:"body"`
+	}
+	type response struct {
+		Chirp
+	}
+
+	chirpIdStr := r.PathValue("chirpId")
+	chirpId, err := uuid.Parse(chirpIdStr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp Id", err)
+		return
+	}
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode the parameters", err)
+		return
+	}
+
+	if len(params.Body) > maxChirpLength {
+		respondWithError(w, http.StatusBadRequest, "Chirp is too long", nil)
+		return
+	}
+	cleanBody := replaceWords(params.Body)
+
+	chirp, err := ac.db.GetOneChirp(r.Context(), chirpId)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't retrieve the chirp", err)
+		return
+	}
+
+	if !authorize(w, principal, authz.EditChirp, authz.Resource{OwnerID: chirp.UserID}) {
+		return
+	}
+
+	updated, err := ac.db.UpdateChirpBody(
+		r.Context(),
+		database.UpdateChirpBodyParams{
+			ID:   chirpId,
+			Body: cleanBody,
+		},
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Couldn't retrieve the chirp", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update the chirp", err)
+		return
+	}
+
+	authors, err := ac.chirpAuthors(r.Context(), updated.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve the author", err)
+		return
+	}
+
+	resp := response{
+		Chirp: chirpFromDatabase(updated, authors),
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (ac *apiConfig) getChirpRevisions(w http.ResponseWriter, r *http.Request) {
+	chirpIdStr := r.PathValue("chirpId")
+	chirpId, err := uuid.Parse(chirpIdStr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp Id", err)
+		return
+	}
+
+	_, err = ac.db.GetOneChirp(r.Context(), chirpId)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't retrieve the chirp", err)
+		return
+	}
+
+	revisions, err := ac.db.ListChirpRevisions(r.Context(), chirpId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve the revisions", err)
+		return
+	}
+
+	resp := []ChirpRevision{}
+
+	for _, revision := range revisions {
+		resp = append(
+			resp,
+			ChirpRevision{
+				Id:        revision.ID,
+				ChirpId:   revision.ChirpID,
+				Body:      revision.Body,
+				CreatedAt: revision.CreatedAt,
+			},
+		)
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
 }
 
 func replaceWords(msg string) (newMsg string) {
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/fernando8franco/http-server-golang/internal/database"
+	"github.com/fernando8franco/http-server-golang/internal/search"
+	"github.com/google/uuid"
+)
+
+type ChirpSearchResult struct {
+	Chirp
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+func (ac *apiConfig) searchChirps(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if _, err := search.Parse(q); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid search query", err)
+		return
+	}
+
+	list, ok := parseChirpListQuery(w, r, cursorKindSearch)
+	if !ok {
+		return
+	}
+	if list.desc {
+		respondWithError(w, http.StatusBadRequest, "Search results are sorted by relevance", nil)
+		return
+	}
+
+	rows, err := ac.db.SearchChirps(r.Context(), database.SearchChirpsParams{
+		Query:          q,
+		AuthorID:       list.params.AuthorID,
+		Since:          list.params.Since,
+		Until:          list.params.Until,
+		AfterRank:      list.afterRank,
+		AfterCreatedAt: list.params.AfterCreatedAt,
+		AfterID:        list.params.AfterID,
+		PageSize:       list.params.PageSize,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search the chirps", err)
+		return
+	}
+
+	if len(rows) > list.pageSize {
+		rows = rows[:list.pageSize]
+		last := rows[len(rows)-1]
+		setNextLink(w, r, cursor{Kind: cursorKindSearch, Rank: last.Rank, CreatedAt: last.CreatedAt, Id: last.ID})
+	}
+
+	userIDs := make([]uuid.UUID, len(rows))
+	for i, row := range rows {
+		userIDs[i] = row.UserID
+	}
+	authors, err := ac.chirpAuthors(r.Context(), userIDs...)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve the authors", err)
+		return
+	}
+
+	resp := []ChirpSearchResult{}
+
+	for _, row := range rows {
+		resp = append(
+			resp,
+			ChirpSearchResult{
+				Chirp: chirpFromDatabase(database.Chirp{
+					ID:        row.ID,
+					CreatedAt: row.CreatedAt,
+					UpdatedAt: row.UpdatedAt,
+					Body:      row.Body,
+					UserID:    row.UserID,
+					EditedAt:  row.EditedAt,
+				}, authors),
+				Rank:    row.Rank,
+				Snippet: row.Snippet,
+			},
+		)
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
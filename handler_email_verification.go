@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fernando8franco/http-server-golang/internal/auth"
+	"github.com/fernando8franco/http-server-golang/internal/database"
+	"github.com/fernando8franco/http-server-golang/internal/mail"
+)
+
+// emailVerificationExpiration is the longest lifetime of the tokens the
+// keyring signs, so it sets how long a rotated key stays valid.
+const emailVerificationExpiration = 24 * time.Hour
+
+// mailSendTimeout bounds how long a request waits for the mailer.
+const mailSendTimeout = 10 * time.Second
+
+// sendVerificationEmail mails user a link that marks their current email
+// address as verified.
+func (ac *apiConfig) sendVerificationEmail(ctx context.Context, user database.User) error {
+	token, err := ac.keyring.MakeEmailVerificationJWT(user.ID, user.Email, emailVerificationExpiration)
+	if err != nil {
+		return err
+	}
+
+	link := ac.baseURL + "/api/users/verify?token=" + url.QueryEscape(token)
+
+	ctx, cancel := context.WithTimeout(ctx, mailSendTimeout)
+	defer cancel()
+
+	return ac.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf(
+			"Open the link below to verify your email address:\n\n%s\n\nThe link expires in %s.\n",
+			link,
+			emailVerificationExpiration,
+		),
+	})
+}
+
+func (ac *apiConfig) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		User
+	}
+
+	userID, email, err := ac.keyring.ValidateEmailVerificationJWT(r.URL.Query().Get("token"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired verification token", err)
+		return
+	}
+
+	// The token names the address it was sent to, so a link mailed before the
+	// email changed can't verify the new one.
+	user, err := ac.db.VerifyUserEmail(r.Context(), database.VerifyUserEmailParams{
+		ID:    userID,
+		Email: email,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired verification token", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify the email address", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{User: userFromDatabase(user)})
+}
+
+func (ac *apiConfig) resendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	user, err := ac.db.GetUserById(r.Context(), principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve the user", err)
+		return
+	}
+	if user.EmailVerifiedAt.Valid {
+		respondWithError(w, http.StatusConflict, "The email address is already verified", nil)
+		return
+	}
+
+	err = ac.sendVerificationEmail(r.Context(), user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send the verification email", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,12 @@
+package main
+
+import (
+	"net/http"
+)
+
+// getJWKS publishes the public signing keys, so other services can validate
+// our access tokens without sharing a secret.
+func (ac *apiConfig) getJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, ac.keyring.JWKS())
+}
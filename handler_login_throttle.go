@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fernando8franco/http-server-golang/internal/auth"
+	"github.com/fernando8franco/http-server-golang/internal/authz"
+	"github.com/fernando8franco/http-server-golang/internal/throttle"
+)
+
+// Failed logins are tracked both per account, against guessing one user's
+// password, and per IP address, against trying a few passwords on many
+// accounts. Accounts are keyed by the email that was typed, whether or not it
+// belongs to a user, so unknown emails are throttled exactly like known ones.
+
+// accountLoginPolicy locks an email out for a while after ten failures.
+var accountLoginPolicy = throttle.Policy{
+	FreeFailures:    3,
+	BaseDelay:       time.Second,
+	MaxDelay:        time.Minute,
+	LockoutFailures: 10,
+	LockoutDuration: 15 * time.Minute,
+	ResetAfter:      time.Hour,
+}
+
+// ipLoginPolicy is looser, since many users can share an address.
+var ipLoginPolicy = throttle.Policy{
+	FreeFailures:    20,
+	BaseDelay:       time.Second,
+	MaxDelay:        time.Minute,
+	LockoutFailures: 100,
+	LockoutDuration: 15 * time.Minute,
+	ResetAfter:      time.Hour,
+}
+
+func loginThrottleKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// respondTooManyRequests responds with 429 and asks the client to wait,
+// rounded up to whole seconds.
+func respondTooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	respondWithError(w, http.StatusTooManyRequests, "Too many failed attempts, try again later", nil)
+}
+
+// loginAttempt is an attempt reserved in both login throttles.
+type loginAttempt struct {
+	account *throttle.Reservation
+	ip      *throttle.Reservation
+}
+
+// reserveLogin reserves an attempt for account and ip. When either of them
+// has to wait it responds with 429 and returns false. The attempt must be
+// settled with fail or release.
+func (ac *apiConfig) reserveLogin(w http.ResponseWriter, account, ip string) (loginAttempt, bool) {
+	accountAttempt, wait := ac.accountThrottle.Reserve(account)
+	if accountAttempt == nil {
+		respondTooManyRequests(w, wait)
+		return loginAttempt{}, false
+	}
+
+	ipAttempt, wait := ac.ipThrottle.Reserve(ip)
+	if ipAttempt == nil {
+		accountAttempt.Release()
+		respondTooManyRequests(w, wait)
+		return loginAttempt{}, false
+	}
+
+	return loginAttempt{account: accountAttempt, ip: ipAttempt}, true
+}
+
+func (a loginAttempt) fail() {
+	a.account.Fail()
+	a.ip.Fail()
+}
+
+// release gives the attempt back without a failure. Releasing one that has
+// already failed does nothing, so it can be deferred.
+func (a loginAttempt) release() {
+	a.account.Release()
+	a.ip.Release()
+}
+
+// pruneLoginThrottles forgets old failed logins, once right away and then
+// every interval, until ctx is done.
+func (ac *apiConfig) pruneLoginThrottles(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ac.accountThrottle.Prune()
+		ac.ipThrottle.Prune()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// unlockUser lifts the lockout of an account after too many failed logins.
+func (ac *apiConfig) unlockUser(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	if !authorize(w, principal, authz.UnlockUser, authz.Resource{}) {
+		return
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode the parameters", err)
+		return
+	}
+
+	user, err := ac.db.GetUserByEmail(r.Context(), params.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find the user", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve the user", err)
+		return
+	}
+
+	ac.accountThrottle.Reset(loginThrottleKey(user.Email))
+	log.Printf("User %s unlocked by %s", user.Email, principal.UserID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/fernando8franco/http-server-golang/internal/auth"
+	"github.com/fernando8franco/http-server-golang/internal/database"
+	"github.com/fernando8franco/http-server-golang/internal/mail"
+)
+
+const (
+	passwordResetExpiration = time.Hour
+	// At most passwordResetLimit reset emails are sent to an address within
+	// passwordResetWindow.
+	passwordResetLimit  = 3
+	passwordResetWindow = time.Hour
+	// At most passwordResetWorkers reset emails are in flight at a time.
+	passwordResetWorkers = 16
+)
+
+// forgotPassword mails a password reset token to the owner of an email
+// address. It answers 202 whether or not the address has an account, so the
+// endpoint can't be used to find out who signed up. The lookup and the email
+// happen after responding, otherwise the time they take would give the
+// answer away. When too many are already in flight the request is dropped,
+// with the same answer.
+func (ac *apiConfig) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode the parameters", err)
+		return
+	}
+
+	select {
+	case ac.passwordResetSlots <- struct{}{}:
+	default:
+		log.Printf("Dropped a password reset for %s: too many in flight", params.Email)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+	ac.passwordResets.Go(func() {
+		defer func() { <-ac.passwordResetSlots }()
+		ac.mailPasswordReset(ctx, params.Email)
+	})
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// mailPasswordReset creates a reset token for the user with email and mails
+// it, unless they already got passwordResetLimit of them recently. Nobody is
+// waiting for the outcome, so failures are only logged.
+func (ac *apiConfig) mailPasswordReset(ctx context.Context, email string) {
+	user, err := ac.db.GetUserByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	if err != nil {
+		log.Printf("Couldn't retrieve the user %s for a password reset: %s", email, err)
+		return
+	}
+
+	token := auth.MakePasswordResetToken()
+
+	_, err = ac.db.CreatePasswordResetToken(ctx, database.CreatePasswordResetTokenParams{
+		TokenHash:        auth.HashPasswordResetToken(token),
+		UserID:           user.ID,
+		ExpiresInSeconds: passwordResetExpiration.Seconds(),
+		WindowSeconds:    passwordResetWindow.Seconds(),
+		MaxTokens:        passwordResetLimit,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Password reset rate limit reached for %s", user.Email)
+		return
+	}
+	if err != nil {
+		log.Printf("Couldn't create the reset token for %s: %s", user.Email, err)
+		return
+	}
+
+	err = ac.sendPasswordResetEmail(ctx, user, token)
+	if err != nil {
+		log.Printf("Couldn't send the password reset email to %s: %s", user.Email, err)
+	}
+}
+
+func (ac *apiConfig) sendPasswordResetEmail(ctx context.Context, user database.User, token string) error {
+	ctx, cancel := context.WithTimeout(ctx, mailSendTimeout)
+	defer cancel()
+
+	return ac.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf(
+			"Send this token to POST %s/api/password/reset with your new password:\n\n%s\n\nThe token expires in %s. If you didn't ask for a reset, ignore this email.\n",
+			ac.baseURL,
+			token,
+			passwordResetExpiration,
+		),
+	})
+}
+
+// resetPassword trades a reset token for a new password. Every session of the
+// user is revoked, since whoever knew the old password may still be logged in.
+func (ac *apiConfig) resetPassword(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode the parameters", err)
+		return
+	}
+
+	// The token is only looked up here, so a rejected password doesn't use
+	// it up.
+	tokenHash := auth.HashPasswordResetToken(params.Token)
+	email, err := ac.db.GetPasswordResetTokenEmail(r.Context(), tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired reset token", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve the reset token", err)
+		return
+	}
+
+	if !ac.checkPassword(w, params.Password, email) {
+		return
+	}
+
+	// Hashing first means a busy server doesn't use up the token.
+	hashedPassword, err := ac.hashPool.HashPassword(r.Context(), params.Password, ac.passwordParams)
+	if err != nil {
+		respondHashError(w, "Couldn't hash the password", err)
+		return
+	}
+
+	userID, err := ac.db.UsePasswordResetToken(r.Context(), tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired reset token", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't use the reset token", err)
+		return
+	}
+
+	err = ac.db.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+		ID:             userID,
+		HashedPassword: hashedPassword,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update the password", err)
+		return
+	}
+
+	// Other tokens mailed before this one must not undo the reset.
+	err = ac.db.DeletePasswordResetTokens(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete the reset tokens", err)
+		return
+	}
+
+	_, err = ac.db.RevokeAllSessions(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke the sessions", err)
+		return
+	}
+
+	err = denyUserAccessTokens(r.Context(), ac.db, userID, "", ac.expirationTime)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke the access tokens", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fernando8franco/http-server-golang/internal/auth"
+	"github.com/fernando8franco/http-server-golang/internal/authz"
+	"github.com/fernando8franco/http-server-golang/internal/database"
+	"github.com/google/uuid"
+)
+
+// PersonalAccessToken is a long lived token for scripts and bots. The token
+// itself is only returned when it is created.
+type PersonalAccessToken struct {
+	Id         uuid.UUID     `json:"id"`
+	Name       string        `json:"name"`
+	Scopes     []authz.Scope `json:"scopes"`
+	CreatedAt  time.Time     `json:"created_at"`
+	ExpiresAt  *time.Time    `json:"expires_at"`
+	LastUsedAt *time.Time    `json:"last_used_at"`
+}
+
+func personalAccessTokenFromDatabase(accessToken database.PersonalAccessToken) PersonalAccessToken {
+	return PersonalAccessToken{
+		Id:         accessToken.ID,
+		Name:       accessToken.Name,
+		Scopes:     parseScopes(accessToken.Scopes),
+		CreatedAt:  accessToken.CreatedAt,
+		ExpiresAt:  nullTimePtr(accessToken.ExpiresAt),
+		LastUsedAt: nullTimePtr(accessToken.LastUsedAt),
+	}
+}
+
+func (ac *apiConfig) createPersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name      string        `json:"name"`
+		Scopes    []authz.Scope `json:"scopes"`
+		ExpiresAt *time.Time    `json:"expires_at"`
+	}
+	type response struct {
+		PersonalAccessToken
+		Token string `json:"token"`
+	}
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode the parameters", err)
+		return
+	}
+
+	if strings.TrimSpace(params.Name) == "" {
+		respondWithError(w, http.StatusBadRequest, "The token needs a name", nil)
+		return
+	}
+	if len(params.Scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "The token needs at least one scope", nil)
+		return
+	}
+	for _, scope := range params.Scopes {
+		if !slices.Contains(authz.Scopes, scope) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown scope %q", scope), nil)
+			return
+		}
+	}
+
+	expiresAt := sql.NullTime{}
+	if params.ExpiresAt != nil {
+		if !params.ExpiresAt.After(time.Now()) {
+			respondWithError(w, http.StatusBadRequest, "The expiry must be in the future", nil)
+			return
+		}
+		expiresAt = sql.NullTime{Time: params.ExpiresAt.UTC(), Valid: true}
+	}
+
+	token := auth.MakePersonalAccessToken()
+
+	accessToken, err := ac.db.CreatePersonalAccessToken(
+		r.Context(),
+		database.CreatePersonalAccessTokenParams{
+			UserID:    principal.UserID,
+			Name:      params.Name,
+			TokenHash: auth.HashPersonalAccessToken(token),
+			Scopes:    formatScopes(params.Scopes),
+			ExpiresAt: expiresAt,
+		},
+	)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create the token", err)
+		return
+	}
+
+	resp := response{
+		PersonalAccessToken: personalAccessTokenFromDatabase(accessToken),
+		Token:               token,
+	}
+
+	respondWithJSON(w, http.StatusCreated, resp)
+}
+
+func (ac *apiConfig) getPersonalAccessTokens(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	accessTokens, err := ac.db.ListPersonalAccessTokens(r.Context(), principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve the tokens", err)
+		return
+	}
+
+	resp := []PersonalAccessToken{}
+
+	for _, accessToken := range accessTokens {
+		resp = append(resp, personalAccessTokenFromDatabase(accessToken))
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (ac *apiConfig) deletePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	tokenIdStr := r.PathValue("tokenId")
+	tokenId, err := uuid.Parse(tokenIdStr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid token Id", err)
+		return
+	}
+
+	// Like sessions, the tokens of other users are reported as missing.
+	deleted, err := ac.db.DeletePersonalAccessToken(r.Context(), database.DeletePersonalAccessTokenParams{
+		ID:     tokenId,
+		UserID: principal.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete the token", err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "Couldn't find the token", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Scopes are stored space separated, the way OAuth writes them.
+func formatScopes(scopes []authz.Scope) string {
+	names := make([]string, len(scopes))
+	for i, scope := range scopes {
+		names[i] = string(scope)
+	}
+	return strings.Join(names, " ")
+}
+
+func parseScopes(scopes string) []authz.Scope {
+	parsed := []authz.Scope{}
+	for _, name := range strings.Fields(scopes) {
+		parsed = append(parsed, authz.Scope(name))
+	}
+	return parsed
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
@@ -1,14 +1,25 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/fernando8franco/http-server-golang/internal/auth"
+	"github.com/fernando8franco/http-server-golang/internal/database"
+	"github.com/google/uuid"
 )
 
+// refreshToken trades a refresh token for a new access token and a new
+// refresh token from the same family. The old refresh token is revoked, so
+// presenting it again means it was stolen or replayed, and every token in the
+// family is revoked.
 func (ac *apiConfig) refreshToken(w http.ResponseWriter, r *http.Request) {
 	type response struct {
-		AccessToken string `json:"token"`
+		AccessToken  string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	refreshToken, err := auth.GetBearerToken(r.Header)
@@ -17,24 +28,80 @@ func (ac *apiConfig) refreshToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userId, err := ac.db.GetUserIdFromRefreshToken(r.Context(), refreshToken)
+	tokenHash := auth.HashRefreshToken(refreshToken)
+
+	storedToken, err := ac.db.GetRefreshToken(r.Context(), tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", err)
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't retrieve the user id from the refresh token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve the refresh token", err)
+		return
+	}
+
+	if storedToken.RevokedAt.Valid {
+		ac.revokeRefreshTokenFamily(w, r, storedToken)
+		return
+	}
+
+	if !storedToken.ExpiresAt.After(time.Now()) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", nil)
 		return
 	}
 
-	accessToken, err := auth.MakeJWT(userId, ac.secret, ac.expirationTime)
+	jti := uuid.NewString()
+	accessToken, err := ac.keyring.MakeJWT(storedToken.UserID, jti, ac.expirationTime)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create the access token", err)
 		return
 	}
 
+	newRefreshToken := auth.MakeRefreshToken()
+
+	// The old token is revoked in the same statement that inserts the new
+	// one, so a failure can't leave the session without a refresh token.
+	rotateParams := database.RotateRefreshTokenParams{
+		TokenHash:      tokenHash,
+		NewTokenHash:   auth.HashRefreshToken(newRefreshToken),
+		AccessTokenJti: sql.NullString{String: jti, Valid: true},
+	}
+
+	_, err = ac.db.RotateRefreshToken(r.Context(), rotateParams)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Another request rotated the token between the lookup and now.
+		ac.revokeRefreshTokenFamily(w, r, storedToken)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rotate the refresh token", err)
+		return
+	}
+
 	resp := response{
-		AccessToken: accessToken,
+		AccessToken:  accessToken,
+		RefreshToken: newRefreshToken,
 	}
 	respondWithJSON(w, http.StatusOK, resp)
 }
 
+func (ac *apiConfig) revokeRefreshTokenFamily(w http.ResponseWriter, r *http.Request, reused database.RefreshToken) {
+	revoked, err := ac.db.RevokeRefreshTokenFamily(r.Context(), reused.FamilyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke the session", err)
+		return
+	}
+
+	err = denyFamilyAccessTokens(r.Context(), ac.db, reused.FamilyID, ac.expirationTime)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke the access tokens", err)
+		return
+	}
+
+	log.Printf("Refresh token reuse detected: user %s, family %s, %d tokens revoked", reused.UserID, reused.FamilyID, revoked)
+	respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", nil)
+}
+
 func (ac *apiConfig) revokeToken(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
@@ -42,11 +109,24 @@ func (ac *apiConfig) revokeToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = ac.db.SetRevokedAt(r.Context(), refreshToken)
+	tokenHash := auth.HashRefreshToken(refreshToken)
+
+	err = ac.db.SetRevokedAt(r.Context(), tokenHash)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Couldn't revoke the session", err)
 		return
 	}
 
+	// Logging out also ends the access tokens of the session. An unknown
+	// refresh token has nothing to revoke.
+	storedToken, err := ac.db.GetRefreshToken(r.Context(), tokenHash)
+	if err == nil {
+		err = denyFamilyAccessTokens(r.Context(), ac.db, storedToken.FamilyID, ac.expirationTime)
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke the access tokens", err)
+		return
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
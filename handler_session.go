@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fernando8franco/http-server-golang/internal/auth"
+	"github.com/fernando8franco/http-server-golang/internal/database"
+	"github.com/google/uuid"
+)
+
+// Session is a login on one device. Its refresh token changes on every
+// refresh, so it is identified by the token family instead.
+type Session struct {
+	Id         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IpAddress  string    `json:"ip_address"`
+}
+
+func (ac *apiConfig) getSessions(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	refreshTokens, err := ac.db.ListSessions(r.Context(), principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve the sessions", err)
+		return
+	}
+
+	resp := []Session{}
+
+	for _, refreshToken := range refreshTokens {
+		lastUsedAt := refreshToken.SessionStartedAt
+		if refreshToken.LastUsedAt.Valid {
+			lastUsedAt = refreshToken.LastUsedAt.Time
+		}
+
+		resp = append(
+			resp,
+			Session{
+				Id:         refreshToken.FamilyID,
+				CreatedAt:  refreshToken.SessionStartedAt,
+				LastUsedAt: lastUsedAt,
+				ExpiresAt:  refreshToken.ExpiresAt,
+				UserAgent:  refreshToken.UserAgent,
+				IpAddress:  refreshToken.IpAddress,
+			},
+		)
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (ac *apiConfig) revokeSession(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	sessionIdStr := r.PathValue("sessionId")
+	sessionId, err := uuid.Parse(sessionIdStr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid session Id", err)
+		return
+	}
+
+	// Sessions of other users are reported as missing, so their ids can't be
+	// probed.
+	revoked, err := ac.db.RevokeSession(r.Context(), database.RevokeSessionParams{
+		FamilyID: sessionId,
+		UserID:   principal.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke the session", err)
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, http.StatusNotFound, "Couldn't find the session", nil)
+		return
+	}
+
+	err = denyFamilyAccessTokens(r.Context(), ac.db, sessionId, ac.expirationTime)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke the access tokens", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (ac *apiConfig) revokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	_, err := ac.db.RevokeAllSessions(r.Context(), principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke the sessions", err)
+		return
+	}
+
+	err = denyUserAccessTokens(r.Context(), ac.db, principal.UserID, "", ac.expirationTime)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke the access tokens", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// clientIP returns the address the request came from, without the port.
+// Behind a reverse proxy every request comes from the proxy, so when
+// trustedProxyHeader is set the address is the last one in that header, the
+// one the proxy added. It must only be set when every request goes through
+// such a proxy, otherwise clients can send the header and pick their address.
+func (ac *apiConfig) clientIP(r *http.Request) string {
+	if ac.trustedProxyHeader != "" {
+		values := r.Header.Values(ac.trustedProxyHeader)
+		if len(values) > 0 {
+			hops := strings.Split(values[len(values)-1], ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/fernando8franco/http-server-golang/internal/auth"
+	"github.com/fernando8franco/http-server-golang/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	totpIssuer                   = "Chirpy"
+	recoveryCodeCount            = 10
+	twoFactorChallengeExpiration = 5 * time.Minute
+	// twoFactorChallengeAttempts is how many codes can be tried with one
+	// challenge before the password has to be typed again.
+	twoFactorChallengeAttempts = 5
+)
+
+// enrollTwoFactor starts setting up TOTP for the user. The secret isn't used
+// at login until verifyTwoFactor confirms the authenticator app has it, so
+// enrolling again before then just replaces it.
+func (ac *apiConfig) enrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		OtpauthURI    string   `json:"otpauth_uri"`
+		Secret        string   `json:"secret"`
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	user, err := ac.db.GetUserById(r.Context(), principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve the user", err)
+		return
+	}
+	if user.TotpEnabledAt.Valid {
+		respondWithError(w, http.StatusConflict, "Two factor authentication is already enabled", nil)
+		return
+	}
+
+	secret := auth.GenerateTOTPSecret()
+
+	_, err = ac.db.SetUserTOTPSecret(r.Context(), database.SetUserTOTPSecretParams{
+		ID:         user.ID,
+		TotpSecret: sql.NullString{String: secret, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save the secret", err)
+		return
+	}
+
+	err = ac.db.DeleteRecoveryCodes(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create the recovery codes", err)
+		return
+	}
+
+	recoveryCodes := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	for _, code := range recoveryCodes {
+		err = ac.db.CreateRecoveryCode(r.Context(), database.CreateRecoveryCodeParams{
+			UserID:   user.ID,
+			CodeHash: auth.HashRecoveryCode(code),
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create the recovery codes", err)
+			return
+		}
+	}
+
+	resp := response{
+		OtpauthURI:    auth.TOTPURI(secret, totpIssuer, user.Email),
+		Secret:        secret,
+		RecoveryCodes: recoveryCodes,
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// verifyTwoFactor turns two factor authentication on once the user proves
+// their authenticator app generates the right codes.
+func (ac *apiConfig) verifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode the parameters", err)
+		return
+	}
+
+	user, err := ac.db.GetUserById(r.Context(), principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve the user", err)
+		return
+	}
+	if user.TotpEnabledAt.Valid {
+		respondWithError(w, http.StatusConflict, "Two factor authentication is already enabled", nil)
+		return
+	}
+	if !user.TotpSecret.Valid {
+		respondWithError(w, http.StatusBadRequest, "Two factor authentication hasn't been enrolled", nil)
+		return
+	}
+
+	ok, err := ac.useTOTPCode(r, user, params.Code)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check the code", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Invalid code", nil)
+		return
+	}
+
+	_, err = ac.db.EnableUserTOTP(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two factor authentication", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loginTwoFactor is the second step of the login for users with two factor
+// authentication. It trades the challenge token from loginUser and either a
+// TOTP code or an unused recovery code for a new session.
+func (ac *apiConfig) loginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode the parameters", err)
+		return
+	}
+
+	challenge, err := ac.keyring.ParseChallengeJWT(params.ChallengeToken)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired challenge token", err)
+		return
+	}
+
+	// Codes are short, so guessing them is throttled like guessing
+	// passwords, per user and per address.
+	account := twoFactorThrottleKey(challenge.UserID)
+	ip := ac.clientIP(r)
+	attempt, ok := ac.reserveLogin(w, account, ip)
+	if !ok {
+		return
+	}
+	defer attempt.release()
+
+	// The attempt is counted before the code is checked, so requests sent
+	// in parallel can't try more codes than the challenge allows.
+	attempted, err := ac.db.AttemptTwoFactorChallenge(r.Context(), database.AttemptTwoFactorChallengeParams{
+		ID:          challenge.ID,
+		UserID:      challenge.UserID,
+		MaxAttempts: twoFactorChallengeAttempts,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check the challenge token", err)
+		return
+	}
+	if attempted == 0 {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired challenge token", nil)
+		return
+	}
+
+	user, err := ac.db.GetUserById(r.Context(), challenge.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired challenge token", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve the user", err)
+		return
+	}
+	if !user.TotpEnabledAt.Valid {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired challenge token", nil)
+		return
+	}
+
+	ok = false
+	switch {
+	case params.Code != "":
+		ok, err = ac.useTOTPCode(r, user, params.Code)
+	case params.RecoveryCode != "":
+		var used int64
+		used, err = ac.db.UseRecoveryCode(r.Context(), database.UseRecoveryCodeParams{
+			UserID:   user.ID,
+			CodeHash: auth.HashRecoveryCode(params.RecoveryCode),
+		})
+		ok = used == 1
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check the code", err)
+		return
+	}
+	if !ok {
+		attempt.fail()
+		respondWithError(w, http.StatusUnauthorized, "Invalid code", nil)
+		return
+	}
+
+	// Using up the challenge is what makes it single use. Only one of two
+	// requests with a right code gets here first.
+	deleted, err := ac.db.DeleteTwoFactorChallenge(r.Context(), challenge.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't use the challenge token", err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired challenge token", nil)
+		return
+	}
+	ac.accountThrottle.Reset(account)
+
+	if user.SuspendedAt.Valid {
+		respondWithError(w, http.StatusForbidden, "The account is suspended", nil)
+		return
+	}
+
+	ac.startSession(w, r, user)
+}
+
+// createTwoFactorChallenge stores a challenge for userID and returns its
+// token, which loginTwoFactor accepts once.
+func (ac *apiConfig) createTwoFactorChallenge(ctx context.Context, userID uuid.UUID) (string, error) {
+	challengeID := uuid.NewString()
+	token, err := ac.keyring.MakeChallengeJWT(userID, challengeID, twoFactorChallengeExpiration)
+	if err != nil {
+		return "", err
+	}
+
+	err = ac.db.CreateTwoFactorChallenge(ctx, database.CreateTwoFactorChallengeParams{
+		ID:               challengeID,
+		UserID:           userID,
+		ExpiresInSeconds: twoFactorChallengeExpiration.Seconds(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// twoFactorThrottleKey is the key of a user's failed codes in the account
+// throttle, kept apart from the failed passwords of their email.
+func twoFactorThrottleKey(userID uuid.UUID) string {
+	return "2fa:" + userID.String()
+}
+
+// purgeTwoFactorChallenges removes the challenges that expired unused, once
+// right away and then every interval, until ctx is done.
+func (ac *apiConfig) purgeTwoFactorChallenges(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := ac.db.PurgeTwoFactorChallenges(ctx)
+		if err != nil {
+			log.Printf("Couldn't purge the two factor challenges: %s", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d two factor challenges", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// useTOTPCode checks code against the secret of user. Each code is accepted
+// only once, so one seen over someone's shoulder can't be replayed.
+func (ac *apiConfig) useTOTPCode(r *http.Request, user database.User, code string) (bool, error) {
+	step, ok := auth.ValidateTOTP(user.TotpSecret.String, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	used, err := ac.db.UseTOTPStep(r.Context(), database.UseTOTPStepParams{
+		Step: step,
+		ID:   user.ID,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return used == 1, nil
+}
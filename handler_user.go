@@ -1,21 +1,49 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"net/mail"
+	"strconv"
 	"time"
 
 	"github.com/fernando8franco/http-server-golang/internal/auth"
+	"github.com/fernando8franco/http-server-golang/internal/authz"
 	"github.com/fernando8franco/http-server-golang/internal/database"
+	"github.com/fernando8franco/http-server-golang/internal/store"
 	"github.com/google/uuid"
 )
 
 type User struct {
-	ID          uuid.UUID `json:"id"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	Email       string    `json:"email"`
-	IsChirpyRed bool      `json:"is_chirpy_red"`
+	ID            uuid.UUID `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	IsChirpyRed   bool      `json:"is_chirpy_red"`
+	Handle        string    `json:"handle,omitempty"`
+	DisplayName   string    `json:"display_name"`
+	Bio           string    `json:"bio"`
+	AvatarUrl     string    `json:"avatar_url"`
+}
+
+func userFromDatabase(user database.User) User {
+	return User{
+		ID:            user.ID,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+		IsChirpyRed:   user.IsChirpyRed,
+		Handle:        user.Handle.String,
+		DisplayName:   user.DisplayName,
+		Bio:           user.Bio,
+		AvatarUrl:     user.AvatarUrl,
+	}
 }
 
 func (ac *apiConfig) createUser(w http.ResponseWriter, r *http.Request) {
@@ -35,9 +63,18 @@ func (ac *apiConfig) createUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(params.Password)
+	if !validEmail(params.Email) {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address", nil)
+		return
+	}
+
+	if !ac.checkPassword(w, params.Password, params.Email) {
+		return
+	}
+
+	hashedPassword, err := ac.hashPool.HashPassword(r.Context(), params.Password, ac.passwordParams)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't hash the password", err)
+		respondHashError(w, "Couldn't hash the password", err)
 		return
 	}
 
@@ -48,19 +85,24 @@ func (ac *apiConfig) createUser(w http.ResponseWriter, r *http.Request) {
 			HashedPassword: hashedPassword,
 		},
 	)
+	if errors.Is(err, store.ErrUniqueViolation) {
+		respondWithError(w, http.StatusConflict, "The email address is already in use", nil)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create the user", err)
 		return
 	}
 
+	// The account works without a verified address, so a mail server outage
+	// doesn't block signups. The link can be sent again later.
+	err = ac.sendVerificationEmail(r.Context(), user)
+	if err != nil {
+		log.Printf("Couldn't send the verification email to %s: %s", user.Email, err)
+	}
+
 	resp := response{
-		User: User{
-			ID:          user.ID,
-			CreatedAt:   user.CreatedAt,
-			UpdatedAt:   user.UpdatedAt,
-			Email:       user.Email,
-			IsChirpyRed: user.IsChirpyRed,
-		},
+		User: userFromDatabase(user),
 	}
 
 	respondWithJSON(w, http.StatusCreated, resp)
@@ -71,10 +113,9 @@ func (ac *apiConfig) loginUser(w http.ResponseWriter, r *http.Request) {
 		Email    string `json:"email"`
 		Password string `json:"password"`
 	}
-	type response struct {
-		User
-		AccessToken  string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
+	type challengeResponse struct {
+		TwoFactorRequired bool   `json:"two_factor_required"`
+		ChallengeToken    string `json:"challenge_token"`
 	}
 
 	params := parameters{}
@@ -85,19 +126,84 @@ func (ac *apiConfig) loginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	account := loginThrottleKey(params.Email)
+	ip := ac.clientIP(r)
+	attempt, ok := ac.reserveLogin(w, account, ip)
+	if !ok {
+		return
+	}
+	defer attempt.release()
+
 	user, err := ac.db.GetUserByEmail(r.Context(), params.Email)
 	if err != nil {
+		// Hashing the password anyway makes unknown emails as slow to fail
+		// as wrong passwords.
+		_, hashErr := ac.hashPool.HashPassword(r.Context(), params.Password, ac.passwordParams)
+		if errors.Is(hashErr, auth.ErrHashPoolFull) {
+			respondHashError(w, "Couldn't check the password", hashErr)
+			return
+		}
+		attempt.fail()
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
 
-	match, err := auth.CheckPasswordHash(params.Password, user.HashedPassword)
+	match, rehash, err := ac.hashPool.CheckPasswordHash(r.Context(), params.Password, user.HashedPassword, ac.passwordParams)
+	if errors.Is(err, auth.ErrHashPoolFull) {
+		respondHashError(w, "Couldn't check the password", err)
+		return
+	}
 	if err != nil || !match {
+		attempt.fail()
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
 
-	accessToken, err := auth.MakeJWT(user.ID, ac.secret, ac.expirationTime)
+	// The address keeps its failures, otherwise logging into an account of
+	// their own would let an attacker keep guessing on others.
+	ac.accountThrottle.Reset(account)
+
+	// The password is only ever known right now, so this is the one chance
+	// to move its hash to the current parameters.
+	if rehash {
+		ac.rehashPassword(r.Context(), user, params.Password)
+	}
+
+	if user.SuspendedAt.Valid {
+		respondWithError(w, http.StatusForbidden, "The account is suspended", nil)
+		return
+	}
+
+	// With two factor authentication the password only earns a challenge,
+	// which is traded for the tokens at /api/login/2fa.
+	if user.TotpEnabledAt.Valid {
+		challenge, err := ac.createTwoFactorChallenge(r.Context(), user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create the challenge token", err)
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, challengeResponse{
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+		})
+		return
+	}
+
+	ac.startSession(w, r, user)
+}
+
+// startSession responds with the access and refresh tokens of a new session
+// for user, who has already proven who they are.
+func (ac *apiConfig) startSession(w http.ResponseWriter, r *http.Request, user database.User) {
+	type response struct {
+		User
+		AccessToken  string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	jti := uuid.NewString()
+	accessToken, err := ac.keyring.MakeJWT(user.ID, jti, ac.expirationTime)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create the access token", err)
 		return
@@ -106,8 +212,12 @@ func (ac *apiConfig) loginUser(w http.ResponseWriter, r *http.Request) {
 	refreshToken := auth.MakeRefreshToken()
 
 	refreshTokenParams := database.CreateRefreshTokenParams{
-		Token:  refreshToken,
-		UserID: user.ID,
+		TokenHash:      auth.HashRefreshToken(refreshToken),
+		UserID:         user.ID,
+		FamilyID:       uuid.New(),
+		UserAgent:      r.UserAgent(),
+		IpAddress:      ac.clientIP(r),
+		AccessTokenJti: sql.NullString{String: jti, Valid: true},
 	}
 
 	_, err = ac.db.CreateRefreshToken(r.Context(), refreshTokenParams)
@@ -117,13 +227,7 @@ func (ac *apiConfig) loginUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	resp := response{
-		User: User{
-			ID:          user.ID,
-			CreatedAt:   user.CreatedAt,
-			UpdatedAt:   user.UpdatedAt,
-			Email:       user.Email,
-			IsChirpyRed: user.IsChirpyRed,
-		},
+		User:         userFromDatabase(user),
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
 	}
@@ -131,6 +235,8 @@ func (ac *apiConfig) loginUser(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, http.StatusOK, resp)
 }
 
+// updateUser replaces the email and password of the user. Unlike patchUser it
+// doesn't ask for the current password, which existing clients don't send.
 func (ac *apiConfig) updateUser(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Email    string `json:"email"`
@@ -140,29 +246,32 @@ func (ac *apiConfig) updateUser(w http.ResponseWriter, r *http.Request) {
 		User
 	}
 
-	accessToken, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find the refresh token", err)
-		return
-	}
+	principal, _ := auth.PrincipalFromContext(r.Context())
 
-	userId, err := auth.ValidateJWT(accessToken, ac.secret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate the token", err)
+	if !authorize(w, principal, authz.UpdateUser, authz.Resource{OwnerID: principal.UserID}) {
 		return
 	}
 
 	params := parameters{}
 	decoder := json.NewDecoder(r.Body)
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode the parameters", err)
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(params.Password)
+	if !validEmail(params.Email) {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address", nil)
+		return
+	}
+
+	if !ac.checkPassword(w, params.Password, params.Email) {
+		return
+	}
+
+	hashedPassword, err := ac.hashPool.HashPassword(r.Context(), params.Password, ac.passwordParams)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't hash the password", err)
+		respondHashError(w, "Couldn't hash the password", err)
 		return
 	}
 
@@ -171,23 +280,275 @@ func (ac *apiConfig) updateUser(w http.ResponseWriter, r *http.Request) {
 		database.UpdateUserParams{
 			Email:          params.Email,
 			HashedPassword: hashedPassword,
-			ID:             userId,
+			ID:             principal.UserID,
 		},
 	)
+	if errors.Is(err, store.ErrUniqueViolation) {
+		respondWithError(w, http.StatusConflict, "The email address is already in use", nil)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't update the user", err)
 		return
 	}
 
+	if !ac.endOtherSessions(w, r, principal.UserID) {
+		return
+	}
+
+	// Changing the address clears its verification, so the new one gets a
+	// fresh link.
+	if !updatedUser.EmailVerifiedAt.Valid {
+		err = ac.sendVerificationEmail(r.Context(), updatedUser)
+		if err != nil {
+			log.Printf("Couldn't send the verification email to %s: %s", updatedUser.Email, err)
+		}
+	}
+
 	resp := response{
-		User: User{
-			ID:          updatedUser.ID,
-			CreatedAt:   updatedUser.CreatedAt,
-			UpdatedAt:   updatedUser.UpdatedAt,
-			Email:       updatedUser.Email,
-			IsChirpyRed: updatedUser.IsChirpyRed,
-		},
+		User: userFromDatabase(updatedUser),
 	}
 
 	respondWithJSON(w, http.StatusOK, resp)
 }
+
+// patchUser applies a JSON merge patch (RFC 7396) to the user, so only the
+// fields given change. Changing the email or password takes the current
+// password, the public profile can be edited without it.
+func (ac *apiConfig) patchUser(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email           patchField `json:"email"`
+		Password        patchField `json:"password"`
+		CurrentPassword string     `json:"current_password"`
+		profilePatch
+	}
+	type response struct {
+		User
+	}
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	if !authorize(w, principal, authz.UpdateUser, authz.Resource{OwnerID: principal.UserID}) {
+		return
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode the patch", err)
+		return
+	}
+
+	// In a merge patch null removes the field, which neither of these can be.
+	if params.Email.Null {
+		respondWithError(w, http.StatusBadRequest, "The email can't be removed", nil)
+		return
+	}
+	if params.Password.Null {
+		respondWithError(w, http.StatusBadRequest, "The password can't be removed", nil)
+		return
+	}
+
+	user, err := ac.db.GetUserById(r.Context(), principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve the user", err)
+		return
+	}
+
+	// Everything is checked before the single write, so a rejected patch
+	// changes nothing.
+	update, ok := ac.applyProfilePatch(w, r, user, params.profilePatch)
+	if !ok {
+		return
+	}
+
+	if params.Email.Set || params.Password.Set {
+		if !ac.confirmPassword(w, r, user, params.CurrentPassword) {
+			return
+		}
+
+		email := user.Email
+		if params.Email.Set && params.Email.Value != user.Email {
+			if !validEmail(params.Email.Value) {
+				respondWithError(w, http.StatusBadRequest, "Invalid email address", nil)
+				return
+			}
+
+			_, err = ac.db.GetUserByEmail(r.Context(), params.Email.Value)
+			if err == nil {
+				respondWithError(w, http.StatusConflict, "The email address is already in use", nil)
+				return
+			}
+			if !errors.Is(err, sql.ErrNoRows) {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve the user", err)
+				return
+			}
+
+			email = params.Email.Value
+			update.Email = sql.NullString{String: email, Valid: true}
+		}
+
+		if params.Password.Set {
+			if !ac.checkPassword(w, params.Password.Value, email) {
+				return
+			}
+
+			hashedPassword, err := ac.hashPool.HashPassword(r.Context(), params.Password.Value, ac.passwordParams)
+			if err != nil {
+				respondHashError(w, "Couldn't hash the password", err)
+				return
+			}
+			update.HashedPassword = sql.NullString{String: hashedPassword, Valid: true}
+		}
+	}
+
+	// The checks above can race with another user taking the same email or
+	// handle, which the unique constraints catch.
+	updatedUser, err := ac.db.PatchUser(r.Context(), update)
+	if errors.Is(err, store.ErrUniqueViolation) {
+		respondWithError(w, http.StatusConflict, "The email address or handle is already in use", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update the user", err)
+		return
+	}
+
+	if params.Password.Set && !ac.endOtherSessions(w, r, user.ID) {
+		return
+	}
+
+	if updatedUser.Email != user.Email {
+		err = ac.sendVerificationEmail(r.Context(), updatedUser)
+		if err != nil {
+			log.Printf("Couldn't send the verification email to %s: %s", updatedUser.Email, err)
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, response{User: userFromDatabase(updatedUser)})
+}
+
+// confirmPassword checks the current password of user before their email or
+// password changes, since an access token alone shouldn't be enough to take
+// over the account. Guessing it here is as good as guessing it at login, so it
+// shares the login throttles. It responds with 400, 403, 429 or 503 and
+// returns false when the password isn't confirmed.
+func (ac *apiConfig) confirmPassword(w http.ResponseWriter, r *http.Request, user database.User, password string) bool {
+	if password == "" {
+		respondWithError(w, http.StatusBadRequest, "The current password is required to change the email or password", nil)
+		return false
+	}
+
+	account := loginThrottleKey(user.Email)
+	attempt, ok := ac.reserveLogin(w, account, ac.clientIP(r))
+	if !ok {
+		return false
+	}
+	defer attempt.release()
+
+	match, _, err := ac.hashPool.CheckPasswordHash(r.Context(), password, user.HashedPassword, ac.passwordParams)
+	if errors.Is(err, auth.ErrHashPoolFull) {
+		respondHashError(w, "Couldn't check the password", err)
+		return false
+	}
+	if err != nil || !match {
+		attempt.fail()
+		respondWithError(w, http.StatusForbidden, "Incorrect current password", err)
+		return false
+	}
+	ac.accountThrottle.Reset(account)
+
+	return true
+}
+
+// endOtherSessions ends every login of userID but the one making the request,
+// after a password change: the old password may have leaked. Their refresh
+// tokens are revoked and their access tokens denied. It responds with 500 and
+// returns false when that fails.
+func (ac *apiConfig) endOtherSessions(w http.ResponseWriter, r *http.Request, userID uuid.UUID) bool {
+	accessToken, _ := auth.AccessTokenFromContext(r.Context())
+
+	_, err := ac.db.RevokeOtherSessions(r.Context(), database.RevokeOtherSessionsParams{
+		UserID:         userID,
+		AccessTokenJti: accessToken.ID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke the other sessions", err)
+		return false
+	}
+
+	err = denyUserAccessTokens(r.Context(), ac.db, userID, accessToken.ID, ac.expirationTime)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke the access tokens", err)
+		return false
+	}
+
+	return true
+}
+
+// rehashPassword replaces the stored hash of user's password with one made
+// with the current parameters. Failing only costs the upgrade, so errors are
+// logged and the login goes on.
+func (ac *apiConfig) rehashPassword(ctx context.Context, user database.User, password string) {
+	hashedPassword, err := ac.hashPool.HashPassword(ctx, password, ac.passwordParams)
+	if err != nil {
+		log.Printf("Couldn't rehash the password of %s: %s", user.Email, err)
+		return
+	}
+
+	// Matching on the old hash keeps a password changed in the meantime
+	// from being overwritten.
+	err = ac.db.RehashUserPassword(ctx, database.RehashUserPasswordParams{
+		HashedPassword:    hashedPassword,
+		ID:                user.ID,
+		OldHashedPassword: user.HashedPassword,
+	})
+	if err != nil {
+		log.Printf("Couldn't rehash the password of %s: %s", user.Email, err)
+	}
+}
+
+// hashRetryAfter is how many seconds clients wait when the password hashing
+// queue is full.
+const hashRetryAfter = 1
+
+// respondHashError responds to a failed password hash. A full hashing queue
+// sheds the request with 503 instead of counting as a server error.
+func respondHashError(w http.ResponseWriter, msg string, err error) {
+	if errors.Is(err, auth.ErrHashPoolFull) {
+		w.Header().Set("Retry-After", strconv.Itoa(hashRetryAfter))
+		respondWithError(w, http.StatusServiceUnavailable, "The server is busy, try again later", err)
+		return
+	}
+
+	respondWithError(w, http.StatusInternalServerError, msg, err)
+}
+
+// checkPassword responds with 422 and returns false when password breaks the
+// password policy, naming every rule it breaks.
+func (ac *apiConfig) checkPassword(w http.ResponseWriter, password, email string) bool {
+	type response struct {
+		Error      string                   `json:"error"`
+		Violations []auth.PasswordViolation `json:"violations"`
+	}
+
+	err := ac.passwordPolicy.Check(password, email)
+
+	var passwordErr *auth.PasswordError
+	if errors.As(err, &passwordErr) {
+		respondWithJSON(w, http.StatusUnprocessableEntity, response{
+			Error:      "Password doesn't meet the requirements",
+			Violations: passwordErr.Violations,
+		})
+		return false
+	}
+
+	return true
+}
+
+// validEmail reports whether email is a bare address like alice@example.com.
+func validEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Address == email
+}
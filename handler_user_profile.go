@@ -0,0 +1,214 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fernando8franco/http-server-golang/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+	maxAvatarURLLength   = 2048
+)
+
+// A handle can never parse as a UUID, so /api/users/{idOrHandle} can tell
+// them apart.
+var handlePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,30}$`)
+
+// reservedHandles would be confusing as handles or clash with the routes
+// under /api/users.
+var reservedHandles = []string{"admin", "api", "chirpy", "handles", "me", "support", "verify"}
+
+// Profile is what anyone can see of a user. It leaves out the email address.
+type Profile struct {
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Handle      string    `json:"handle,omitempty"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	AvatarUrl   string    `json:"avatar_url"`
+	IsChirpyRed bool      `json:"is_chirpy_red"`
+}
+
+func profileFromDatabase(user database.User) Profile {
+	return Profile{
+		ID:          user.ID,
+		CreatedAt:   user.CreatedAt,
+		Handle:      user.Handle.String,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarUrl:   user.AvatarUrl,
+		IsChirpyRed: user.IsChirpyRed,
+	}
+}
+
+func validHandle(handle string) bool {
+	return handlePattern.MatchString(handle)
+}
+
+func reservedHandle(handle string) bool {
+	return slices.Contains(reservedHandles, strings.ToLower(handle))
+}
+
+// patchField is a field of a JSON merge patch, which is either missing, null
+// or set to a string.
+type patchField struct {
+	Set   bool
+	Null  bool
+	Value string
+}
+
+func (f *patchField) UnmarshalJSON(data []byte) error {
+	f.Set = true
+	if string(data) == "null" {
+		f.Null = true
+		return nil
+	}
+	return json.Unmarshal(data, &f.Value)
+}
+
+// profilePatch is the part of a user patch that edits the public profile.
+// Null removes the handle and empties the other fields.
+type profilePatch struct {
+	Handle      patchField `json:"handle"`
+	DisplayName patchField `json:"display_name"`
+	Bio         patchField `json:"bio"`
+	AvatarUrl   patchField `json:"avatar_url"`
+}
+
+// applyProfilePatch returns the PatchUser params for user with patch applied,
+// leaving the email and password alone. It responds with 400 or 409 and
+// returns false when the result isn't valid.
+func (ac *apiConfig) applyProfilePatch(w http.ResponseWriter, r *http.Request, user database.User, patch profilePatch) (database.PatchUserParams, bool) {
+	profile := database.PatchUserParams{
+		ID:          user.ID,
+		Handle:      user.Handle,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarUrl:   user.AvatarUrl,
+	}
+
+	if patch.Handle.Set {
+		profile.Handle = sql.NullString{String: patch.Handle.Value, Valid: !patch.Handle.Null}
+	}
+	if patch.DisplayName.Set {
+		profile.DisplayName = patch.DisplayName.Value
+	}
+	if patch.Bio.Set {
+		profile.Bio = patch.Bio.Value
+	}
+	if patch.AvatarUrl.Set {
+		profile.AvatarUrl = patch.AvatarUrl.Value
+	}
+
+	if utf8.RuneCountInString(profile.DisplayName) > maxDisplayNameLength {
+		respondWithError(w, http.StatusBadRequest, "Display name is too long", nil)
+		return database.PatchUserParams{}, false
+	}
+	if utf8.RuneCountInString(profile.Bio) > maxBioLength {
+		respondWithError(w, http.StatusBadRequest, "Bio is too long", nil)
+		return database.PatchUserParams{}, false
+	}
+	if profile.AvatarUrl != "" && !validAvatarURL(profile.AvatarUrl) {
+		respondWithError(w, http.StatusBadRequest, "Invalid avatar URL, use an https URL", nil)
+		return database.PatchUserParams{}, false
+	}
+
+	// Only a new handle is checked, so a handle taken before the rules
+	// changed can still be kept.
+	if profile.Handle.Valid && !strings.EqualFold(profile.Handle.String, user.Handle.String) {
+		handle := profile.Handle.String
+		if !validHandle(handle) {
+			respondWithError(w, http.StatusBadRequest, "Invalid handle, use 3 to 30 letters, digits or underscores", nil)
+			return database.PatchUserParams{}, false
+		}
+		if reservedHandle(handle) {
+			respondWithError(w, http.StatusConflict, "The handle is not available", nil)
+			return database.PatchUserParams{}, false
+		}
+
+		owner, err := ac.db.GetUserByHandle(r.Context(), handle)
+		if err == nil && owner.ID != user.ID {
+			respondWithError(w, http.StatusConflict, "The handle is not available", nil)
+			return database.PatchUserParams{}, false
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check the handle", err)
+			return database.PatchUserParams{}, false
+		}
+	}
+
+	return profile, true
+}
+
+func validAvatarURL(avatarURL string) bool {
+	if len(avatarURL) > maxAvatarURLLength {
+		return false
+	}
+	u, err := url.Parse(avatarURL)
+	return err == nil && u.Scheme == "https" && u.Host != ""
+}
+
+func (ac *apiConfig) getUserProfile(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Profile
+	}
+
+	idOrHandle := r.PathValue("idOrHandle")
+
+	var user database.User
+	var err error
+	if id, parseErr := uuid.Parse(idOrHandle); parseErr == nil {
+		user, err = ac.db.GetUserById(r.Context(), id)
+	} else if validHandle(idOrHandle) {
+		user, err = ac.db.GetUserByHandle(r.Context(), idOrHandle)
+	} else {
+		err = sql.ErrNoRows
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find the user", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve the user", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{Profile: profileFromDatabase(user)})
+}
+
+func (ac *apiConfig) checkHandle(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Handle    string `json:"handle"`
+		Available bool   `json:"available"`
+	}
+
+	handle := r.PathValue("handle")
+	if !validHandle(handle) {
+		respondWithError(w, http.StatusBadRequest, "Invalid handle, use 3 to 30 letters, digits or underscores", nil)
+		return
+	}
+
+	available := !reservedHandle(handle)
+	if available {
+		_, err := ac.db.GetUserByHandle(r.Context(), handle)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check the handle", err)
+			return
+		}
+		available = errors.Is(err, sql.ErrNoRows)
+	}
+
+	respondWithJSON(w, http.StatusOK, response{Handle: handle, Available: available})
+}
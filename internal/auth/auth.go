@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"net/http"
@@ -9,83 +10,81 @@ import (
 	"time"
 
 	"github.com/alexedwards/argon2id"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
 type TokenType string
 
 const (
-	TokenTypeAccess TokenType = "chirpy"
+	TokenTypeAccess            TokenType = "chirpy"
+	TokenTypeChallenge         TokenType = "chirpy-2fa"
+	TokenTypeEmailVerification TokenType = "chirpy-verify"
 )
 
+// PersonalAccessTokenPrefix starts every personal access token, which tells
+// them apart from JWTs and makes leaked ones easy to scan for.
+const PersonalAccessTokenPrefix = "chirpy_pat_"
+
 var ErrNoAuthHeaderIncluded = errors.New("no auth header included in request")
 var ErrMalformedAuthHeader = errors.New("malformed authorization header")
 
-func HashPassword(password string) (string, error) {
-	hashPassword, err := argon2id.CreateHash(password, argon2id.DefaultParams)
-	if err != nil {
-		return "", err
-	}
+// PasswordParams are the Argon2id costs of new password hashes. Raising them
+// makes leaked hashes slower to crack, at the price of slower logins.
+type PasswordParams struct {
+	// Memory is in KiB.
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
 
-	return hashPassword, nil
+// DefaultPasswordParams match argon2id.DefaultParams, except that the
+// parallelism doesn't follow the number of CPUs, so servers of different
+// sizes don't keep rehashing each other's hashes.
+var DefaultPasswordParams = PasswordParams{
+	Memory:      64 * 1024,
+	Iterations:  1,
+	Parallelism: 2,
 }
 
-func CheckPasswordHash(password, hash string) (bool, error) {
-	match, err := argon2id.ComparePasswordAndHash(password, hash)
-	if err != nil {
-		return false, err
+func (p PasswordParams) argon2id() *argon2id.Params {
+	return &argon2id.Params{
+		Memory:      p.Memory,
+		Iterations:  p.Iterations,
+		Parallelism: p.Parallelism,
+		SaltLength:  argon2id.DefaultParams.SaltLength,
+		KeyLength:   argon2id.DefaultParams.KeyLength,
 	}
-
-	return match, nil
 }
 
-func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
-	claims := jwt.RegisteredClaims{
-		Issuer:    string(TokenTypeAccess),
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn).UTC()),
-		Subject:   userID.String(),
-	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	ss, err := token.SignedString([]byte(tokenSecret))
+func HashPassword(password string, params PasswordParams) (string, error) {
+	hashPassword, err := argon2id.CreateHash(password, params.argon2id())
 	if err != nil {
 		return "", err
 	}
 
-	return ss, nil
+	return hashPassword, nil
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(t *jwt.Token) (any, error) {
-		return []byte(tokenSecret), nil
-	})
-	if err != nil {
-		return uuid.Nil, err
-	}
-
-	cl := token.Claims
-
-	issuer, err := cl.GetIssuer()
-	if err != nil {
-		return uuid.Nil, err
-	}
-
-	if issuer != string(TokenTypeAccess) {
-		return uuid.Nil, errors.New("the issuer is not valid")
+// CheckPasswordHash reports whether password matches hash and, when it does,
+// whether hash was made with other parameters than params and should be
+// replaced with a new hash of password.
+func CheckPasswordHash(password, hash string, params PasswordParams) (match, rehash bool, err error) {
+	match, stored, err := argon2id.CheckHash(password, hash)
+	if err != nil || !match {
+		return false, false, err
 	}
 
-	subject, err := cl.GetSubject()
-	if err != nil {
-		return uuid.Nil, err
-	}
+	return true, *stored != *params.argon2id(), nil
+}
 
-	id, err := uuid.Parse(subject)
-	if err != nil {
-		return uuid.Nil, err
-	}
+// MakeJWT signs an access token with a single HS256 secret. Servers with a
+// keyring of rotating keys use Keyring.MakeJWT instead.
+func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return NewKeyring(SecretSigningKey(tokenSecret)).MakeJWT(userID, uuid.NewString(), expiresIn)
+}
 
-	return id, nil
+func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+	return NewKeyring(SecretSigningKey(tokenSecret)).ValidateJWT(tokenString)
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -109,6 +108,34 @@ func MakeRefreshToken() string {
 	return hex.EncodeToString(key)
 }
 
+// HashRefreshToken returns the digest a refresh token is stored under. The
+// tokens are random, so a fast unsalted hash is enough to keep a database leak
+// from turning into stolen sessions.
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func MakePersonalAccessToken() string {
+	return PersonalAccessTokenPrefix + MakeRefreshToken()
+}
+
+// HashPersonalAccessToken returns the digest a personal access token is
+// stored under, for the same reasons as HashRefreshToken.
+func HashPersonalAccessToken(token string) string {
+	return HashRefreshToken(token)
+}
+
+func MakePasswordResetToken() string {
+	return MakeRefreshToken()
+}
+
+// HashPasswordResetToken returns the digest a password reset token is stored
+// under, for the same reasons as HashRefreshToken.
+func HashPasswordResetToken(token string) string {
+	return HashRefreshToken(token)
+}
+
 func GetAPIKey(headers http.Header) (string, error) {
 	authHeader := headers.Get("Authorization")
 	if authHeader == "" {
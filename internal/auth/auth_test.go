@@ -102,3 +102,62 @@ func TestGetBearerToken(t *testing.T) {
 		})
 	}
 }
+
+func TestHashRefreshToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    string
+		wantHash string
+	}{
+		{
+			"Empty token",
+			"",
+			"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		},
+		{
+			"Refresh token",
+			"token",
+			"3c469e9d6c5875d37a43f353d4f88e61fcf812c66eee3457465a40b0da4153e0",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hash := HashRefreshToken(test.token)
+			if hash != test.wantHash {
+				t.Errorf("HashRefreshToken()\nhash = %v\nwantHash = %v", hash, test.wantHash)
+			}
+		})
+	}
+}
+
+func TestCheckPasswordHash(t *testing.T) {
+	oldParams := PasswordParams{Memory: 1024, Iterations: 1, Parallelism: 1}
+	newParams := PasswordParams{Memory: 2048, Iterations: 2, Parallelism: 1}
+	hash, _ := HashPassword("password", oldParams)
+
+	tests := []struct {
+		name       string
+		password   string
+		params     PasswordParams
+		wantMatch  bool
+		wantRehash bool
+	}{
+		{"Current parameters", "password", oldParams, true, false},
+		{"Older parameters", "password", newParams, true, true},
+		{"Wrong password", "wrong-password", newParams, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			match, rehash, err := CheckPasswordHash(test.password, hash, test.params)
+			if err != nil || match != test.wantMatch || rehash != test.wantRehash {
+				t.Errorf("CheckPasswordHash()\nmatch = %v\nrehash = %v\nerror = %v\nwantMatch = %v\nwantRehash = %v", match, rehash, err, test.wantMatch, test.wantRehash)
+			}
+		})
+	}
+
+	if _, _, err := CheckPasswordHash("password", "not-a-hash", newParams); err == nil {
+		t.Errorf("CheckPasswordHash() with an invalid hash\nerror = %v\nwant an error", err)
+	}
+}
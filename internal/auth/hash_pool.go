@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrHashPoolFull is returned when every worker is busy and the queue is
+// full, so the caller should shed load instead of waiting.
+var ErrHashPoolFull = errors.New("password hashing queue is full")
+
+// HashPool runs password hashing on a fixed number of workers. Every Argon2id
+// hash allocates its whole memory cost, so bounding how many run at once
+// bounds the memory a burst of logins can take.
+type HashPool struct {
+	jobs chan func()
+	wg   sync.WaitGroup
+}
+
+// NewHashPool starts workers goroutines that share a queue of queue jobs.
+// With a queue of 0, work is only accepted while a worker is idle.
+func NewHashPool(workers, queue int) *HashPool {
+	p := &HashPool{jobs: make(chan func(), queue)}
+
+	p.wg.Add(workers)
+	for range workers {
+		go func() {
+			defer p.wg.Done()
+			for job := range p.jobs {
+				job()
+			}
+		}()
+	}
+
+	return p
+}
+
+// Close stops the workers after the queued jobs are done. The pool must not
+// be used afterwards.
+func (p *HashPool) Close() {
+	close(p.jobs)
+	p.wg.Wait()
+}
+
+// HashPassword is HashPassword run on the pool.
+func (p *HashPool) HashPassword(ctx context.Context, password string, params PasswordParams) (string, error) {
+	var hash string
+	var err error
+	if runErr := p.run(ctx, func() { hash, err = HashPassword(password, params) }); runErr != nil {
+		return "", runErr
+	}
+	return hash, err
+}
+
+// CheckPasswordHash is CheckPasswordHash run on the pool.
+func (p *HashPool) CheckPasswordHash(ctx context.Context, password, hash string, params PasswordParams) (bool, bool, error) {
+	var match, rehash bool
+	var err error
+	if runErr := p.run(ctx, func() { match, rehash, err = CheckPasswordHash(password, hash, params) }); runErr != nil {
+		return false, false, runErr
+	}
+	return match, rehash, err
+}
+
+// run queues fn and waits until a worker has run it. It gives up when ctx is
+// done, and the worker then skips fn if it hasn't started it yet. fn must not
+// be relied on after run returns an error.
+func (p *HashPool) run(ctx context.Context, fn func()) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	job := func() {
+		defer close(done)
+		if ctx.Err() == nil {
+			fn()
+		}
+	}
+
+	select {
+	case p.jobs <- job:
+	default:
+		return ErrHashPoolFull
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+var benchmarkParams = PasswordParams{Memory: 8 * 1024, Iterations: 1, Parallelism: 1}
+
+// saturate keeps the only worker of pool busy and fills its queue, until the
+// returned func is called.
+func saturate(pool *HashPool) (release func()) {
+	hold := make(chan struct{})
+	started := make(chan struct{})
+	pool.jobs <- func() {
+		close(started)
+		<-hold
+	}
+	<-started
+
+	for len(pool.jobs) < cap(pool.jobs) {
+		pool.jobs <- func() {}
+	}
+
+	return func() { close(hold) }
+}
+
+func TestHashPool(t *testing.T) {
+	pool := NewHashPool(1, 1)
+	defer pool.Close()
+
+	hash, err := pool.HashPassword(context.Background(), "password", benchmarkParams)
+	if err != nil {
+		t.Fatalf("HashPassword()\nerror = %v", err)
+	}
+	match, rehash, err := pool.CheckPasswordHash(context.Background(), "password", hash, benchmarkParams)
+	if err != nil || !match || rehash {
+		t.Errorf("CheckPasswordHash()\nmatch = %v\nrehash = %v\nerror = %v", match, rehash, err)
+	}
+
+	release := saturate(pool)
+	defer release()
+
+	if _, err := pool.HashPassword(context.Background(), "password", benchmarkParams); !errors.Is(err, ErrHashPoolFull) {
+		t.Errorf("HashPassword() with a full queue\nerror = %v\nwantErr = %v", err, ErrHashPoolFull)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := pool.CheckPasswordHash(ctx, "password", hash, benchmarkParams); !errors.Is(err, context.Canceled) {
+		t.Errorf("CheckPasswordHash() with a canceled context\nerror = %v\nwantErr = %v", err, context.Canceled)
+	}
+}
+
+func TestHashPoolSkipsCanceledJobs(t *testing.T) {
+	pool := NewHashPool(1, 1)
+	defer pool.Close()
+
+	// Once the worker is busy, the next job waits in the queue.
+	started := make(chan struct{})
+	hold := make(chan struct{})
+	pool.jobs <- func() {
+		close(started)
+		<-hold
+	}
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ran := make(chan struct{}, 1)
+	result := make(chan error)
+	go func() {
+		result <- pool.run(ctx, func() { ran <- struct{}{} })
+	}()
+	deadline := time.Now().Add(time.Second)
+	for len(pool.jobs) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("run()\nthe job never reached the queue")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	if err := <-result; !errors.Is(err, context.Canceled) {
+		t.Errorf("run() canceled in the queue\nerror = %v\nwantErr = %v", err, context.Canceled)
+	}
+
+	// Once the worker is free, it gets through the canceled job first.
+	close(hold)
+	pool.run(context.Background(), func() {})
+
+	if len(ran) != 0 {
+		t.Errorf("run() canceled in the queue\nwant the job skipped")
+	}
+}
+
+func BenchmarkHashPassword(b *testing.B) {
+	for b.Loop() {
+		HashPassword("password", benchmarkParams)
+	}
+}
+
+func BenchmarkHashPool(b *testing.B) {
+	for _, workers := range []int{1, 2, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			pool := NewHashPool(workers, b.N)
+			defer pool.Close()
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					pool.HashPassword(context.Background(), "password", benchmarkParams)
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkHashPoolShedding measures how fast a saturated pool turns work
+// away, which is what every request costs during a burst of logins.
+func BenchmarkHashPoolShedding(b *testing.B) {
+	pool := NewHashPool(1, 0)
+	defer pool.Close()
+
+	release := saturate(pool)
+	defer release()
+
+	for b.Loop() {
+		pool.HashPassword(context.Background(), "password", benchmarkParams)
+	}
+}
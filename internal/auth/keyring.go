@@ -0,0 +1,520 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+var ErrNoActiveKey = errors.New("no active signing key")
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// SigningKey is one key of a Keyring. The newest key whose ActivatesAt has
+// passed signs new tokens. A retired key, with ExpiresAt set, only validates
+// the tokens it already signed, until ExpiresAt.
+type SigningKey struct {
+	ID          string
+	Algorithm   string
+	CreatedAt   time.Time
+	ActivatesAt time.Time
+	ExpiresAt   time.Time
+
+	// secret is set for HS256 keys and private for the asymmetric ones.
+	secret  []byte
+	private crypto.Signer
+}
+
+// SecretSigningKey turns the old SECRET setting into an HS256 key. The id is
+// derived from the secret so every server using it agrees on it.
+func SecretSigningKey(secret string) SigningKey {
+	sum := sha256.Sum256([]byte(secret))
+	return SigningKey{
+		ID:        "hs-" + hex.EncodeToString(sum[:4]),
+		Algorithm: AlgorithmHS256,
+		secret:    []byte(secret),
+	}
+}
+
+func GenerateSigningKey(algorithm string) (SigningKey, error) {
+	key := SigningKey{
+		ID:        newKeyID(),
+		Algorithm: algorithm,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	switch algorithm {
+	case AlgorithmHS256:
+		key.secret = make([]byte, 32)
+		rand.Read(key.secret)
+	case AlgorithmRS256:
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return SigningKey{}, err
+		}
+		key.private = private
+	case AlgorithmEdDSA:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return SigningKey{}, err
+		}
+		key.private = private
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+
+	return key, nil
+}
+
+func newKeyID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func (k SigningKey) signingMethod() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+func (k SigningKey) signingKey() any {
+	if k.secret != nil {
+		return k.secret
+	}
+	return k.private
+}
+
+func (k SigningKey) verificationKey() any {
+	if k.secret != nil {
+		return k.secret
+	}
+	return k.private.Public()
+}
+
+func (k SigningKey) expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt)
+}
+
+func (k SigningKey) activated(now time.Time) bool {
+	return !now.Before(k.ActivatesAt)
+}
+
+// newerThan reports whether k took over signing after other.
+func (k SigningKey) newerThan(other SigningKey) bool {
+	if !k.ActivatesAt.Equal(other.ActivatesAt) {
+		return k.ActivatesAt.After(other.ActivatesAt)
+	}
+	return k.CreatedAt.After(other.CreatedAt)
+}
+
+// Keyring holds the keys access tokens are signed with. The newest active key
+// signs new tokens, and every key that hasn't expired validates them, so keys
+// can be rotated without logging anyone out. It is safe for concurrent use,
+// so a running server can reload it.
+type Keyring struct {
+	mu   sync.RWMutex
+	keys []SigningKey
+}
+
+func NewKeyring(keys ...SigningKey) *Keyring {
+	return &Keyring{keys: keys}
+}
+
+func (k *Keyring) Keys() []SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return slices.Clone(k.keys)
+}
+
+func (k *Keyring) active() (SigningKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	now := time.Now()
+	var active SigningKey
+	found := false
+	for _, key := range k.keys {
+		if key.expired(now) || !key.activated(now) {
+			continue
+		}
+		if !found || key.newerThan(active) {
+			active = key
+			found = true
+		}
+	}
+
+	if !found {
+		return SigningKey{}, ErrNoActiveKey
+	}
+	return active, nil
+}
+
+// Rotate adds a new key that starts signing after activateAfter and retires
+// the current ones. activateAfter gives every server time to load the new key
+// before it shows up in tokens. The retired keys keep signing until then and
+// stay valid for retireAfter more, which should be the longest lifetime of
+// the tokens the keyring signs. Keys that have already expired are dropped.
+func (k *Keyring) Rotate(algorithm string, activateAfter, retireAfter time.Duration) (SigningKey, error) {
+	key, err := GenerateSigningKey(algorithm)
+	if err != nil {
+		return SigningKey{}, err
+	}
+	key.ActivatesAt = key.CreatedAt.Add(activateAfter)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	keys := []SigningKey{}
+	for _, old := range k.keys {
+		if old.expired(key.CreatedAt) {
+			continue
+		}
+		if old.ExpiresAt.IsZero() {
+			old.ExpiresAt = key.ActivatesAt.Add(retireAfter)
+		}
+		keys = append(keys, old)
+	}
+	k.keys = append(keys, key)
+
+	return key, nil
+}
+
+// Reload replaces the keys with the ones in the keyring at path, so a
+// running server picks up a rotation.
+func (k *Keyring) Reload(path string) error {
+	loaded, err := LoadKeyring(path)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys = loaded.keys
+	return nil
+}
+
+// AccessToken holds the claims of a validated access token.
+type AccessToken struct {
+	UserID    uuid.UUID
+	ID        string
+	ExpiresAt time.Time
+}
+
+// MakeJWT signs an access token for userID. tokenID becomes the jti claim,
+// which is how the token can be denied before it expires.
+func (k *Keyring) MakeJWT(userID uuid.UUID, tokenID string, expiresIn time.Duration) (string, error) {
+	return k.makeJWT(TokenTypeAccess, userID, tokenID, expiresIn)
+}
+
+// MakeChallengeJWT signs the token that proves userID got the password right,
+// for the second step of a two factor login. It isn't an access token.
+// challengeID becomes the jti claim, which is how the challenge is used up.
+func (k *Keyring) MakeChallengeJWT(userID uuid.UUID, challengeID string, expiresIn time.Duration) (string, error) {
+	return k.makeJWT(TokenTypeChallenge, userID, challengeID, expiresIn)
+}
+
+// emailClaims are the claims of an email verification token.
+type emailClaims struct {
+	jwt.RegisteredClaims
+	Email string `json:"email"`
+}
+
+// MakeEmailVerificationJWT signs the token of a verification link for email.
+// The address is part of the token, so the link stops working once the user
+// changes it.
+func (k *Keyring) MakeEmailVerificationJWT(userID uuid.UUID, email string, expiresIn time.Duration) (string, error) {
+	return k.sign(emailClaims{
+		RegisteredClaims: registeredClaims(TokenTypeEmailVerification, userID, uuid.NewString(), expiresIn),
+		Email:            email,
+	})
+}
+
+func (k *Keyring) makeJWT(tokenType TokenType, userID uuid.UUID, tokenID string, expiresIn time.Duration) (string, error) {
+	return k.sign(registeredClaims(tokenType, userID, tokenID, expiresIn))
+}
+
+func registeredClaims(tokenType TokenType, userID uuid.UUID, tokenID string, expiresIn time.Duration) jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		Issuer:    string(tokenType),
+		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn).UTC()),
+		Subject:   userID.String(),
+		ID:        tokenID,
+	}
+}
+
+func (k *Keyring) sign(claims jwt.Claims) (string, error) {
+	key, err := k.active()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.signingMethod(), claims)
+	token.Header["kid"] = key.ID
+	ss, err := token.SignedString(key.signingKey())
+	if err != nil {
+		return "", err
+	}
+
+	return ss, nil
+}
+
+func (k *Keyring) ValidateJWT(tokenString string) (uuid.UUID, error) {
+	accessToken, err := k.ParseJWT(tokenString)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return accessToken.UserID, nil
+}
+
+// ParseChallengeJWT validates a challenge token and returns its claims, the
+// ID being the challenge ID.
+func (k *Keyring) ParseChallengeJWT(tokenString string) (AccessToken, error) {
+	return k.parseJWT(TokenTypeChallenge, tokenString)
+}
+
+// ValidateEmailVerificationJWT returns the user and the address a
+// verification token was issued for.
+func (k *Keyring) ValidateEmailVerificationJWT(tokenString string) (uuid.UUID, string, error) {
+	claims := &emailClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, k.keyFunc)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+
+	if claims.Issuer != string(TokenTypeEmailVerification) {
+		return uuid.Nil, "", errors.New("the issuer is not valid")
+	}
+
+	id, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+
+	return id, claims.Email, nil
+}
+
+// ParseJWT validates an access token and returns its claims. Tokens issued
+// before the jti claim existed have an empty ID.
+func (k *Keyring) ParseJWT(tokenString string) (AccessToken, error) {
+	return k.parseJWT(TokenTypeAccess, tokenString)
+}
+
+func (k *Keyring) parseJWT(tokenType TokenType, tokenString string) (AccessToken, error) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, k.keyFunc)
+	if err != nil {
+		return AccessToken{}, err
+	}
+
+	if claims.Issuer != string(tokenType) {
+		return AccessToken{}, errors.New("the issuer is not valid")
+	}
+
+	id, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return AccessToken{}, err
+	}
+
+	accessToken := AccessToken{
+		UserID: id,
+		ID:     claims.ID,
+	}
+	if claims.ExpiresAt != nil {
+		accessToken.ExpiresAt = claims.ExpiresAt.Time
+	}
+
+	return accessToken, nil
+}
+
+// keyFunc picks the key named by the kid header. Tokens signed before key
+// rotation existed have no kid and are checked against the HS256 keys. The
+// algorithm has to match the key's, so an RSA public key can never be used as
+// an HMAC secret.
+func (k *Keyring) keyFunc(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+	now := time.Now()
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for _, key := range k.keys {
+		if key.expired(now) {
+			continue
+		}
+		if kid != key.ID && (kid != "" || key.Algorithm != AlgorithmHS256) {
+			continue
+		}
+		if t.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method %s for key %s", t.Method.Alg(), key.ID)
+		}
+		return key.verificationKey(), nil
+	}
+
+	return nil, ErrUnknownKey
+}
+
+// JWK is the public half of a signing key, as described in RFC 7517.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS lists the public keys of the keys that haven't expired. HS256 keys are
+// secret and never published.
+func (k *Keyring) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	now := time.Now()
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for _, key := range k.keys {
+		if key.expired(now) || key.private == nil {
+			continue
+		}
+
+		jwk := JWK{
+			KeyID:     key.ID,
+			Use:       "sig",
+			Algorithm: key.Algorithm,
+		}
+		switch public := key.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
+
+type keyringFile struct {
+	Keys []keyFile `json:"keys"`
+}
+
+type keyFile struct {
+	ID          string    `json:"kid"`
+	Algorithm   string    `json:"alg"`
+	CreatedAt   time.Time `json:"created_at"`
+	ActivatesAt time.Time `json:"activates_at,omitzero"`
+	ExpiresAt   time.Time `json:"expires_at,omitzero"`
+	// Key is the HMAC secret or the PKCS #8 private key, base64 encoded.
+	Key string `json:"key"`
+}
+
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file keyringFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("couldn't parse keyring %s: %w", path, err)
+	}
+
+	keyring := &Keyring{}
+	for _, f := range file.Keys {
+		der, err := base64.StdEncoding.DecodeString(f.Key)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't decode key %s: %w", f.ID, err)
+		}
+
+		key := SigningKey{
+			ID:          f.ID,
+			Algorithm:   f.Algorithm,
+			CreatedAt:   f.CreatedAt,
+			ActivatesAt: f.ActivatesAt,
+			ExpiresAt:   f.ExpiresAt,
+		}
+		switch f.Algorithm {
+		case AlgorithmHS256:
+			key.secret = der
+		case AlgorithmRS256, AlgorithmEdDSA:
+			private, err := x509.ParsePKCS8PrivateKey(der)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't parse key %s: %w", f.ID, err)
+			}
+			signer, ok := private.(crypto.Signer)
+			if !ok {
+				return nil, fmt.Errorf("key %s can't sign", f.ID)
+			}
+			key.private = signer
+		default:
+			return nil, fmt.Errorf("unsupported signing algorithm %q for key %s", f.Algorithm, f.ID)
+		}
+		keyring.keys = append(keyring.keys, key)
+	}
+
+	return keyring, nil
+}
+
+// Save writes the keyring to path. The file holds private keys, so only the
+// owner can read it.
+func (k *Keyring) Save(path string) error {
+	file := keyringFile{Keys: []keyFile{}}
+	for _, key := range k.Keys() {
+		der := key.secret
+		if key.private != nil {
+			var err error
+			der, err = x509.MarshalPKCS8PrivateKey(key.private)
+			if err != nil {
+				return fmt.Errorf("couldn't encode key %s: %w", key.ID, err)
+			}
+		}
+
+		file.Keys = append(file.Keys, keyFile{
+			ID:          key.ID,
+			Algorithm:   key.Algorithm,
+			CreatedAt:   key.CreatedAt,
+			ActivatesAt: key.ActivatesAt,
+			ExpiresAt:   key.ExpiresAt,
+			Key:         base64.StdEncoding.EncodeToString(der),
+		})
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func TestKeyring(t *testing.T) {
+	userID := uuid.New()
+
+	tests := []struct {
+		name      string
+		algorithm string
+		wantJWKS  int
+	}{
+		{"HS256", AlgorithmHS256, 0},
+		{"RS256", AlgorithmRS256, 1},
+		{"EdDSA", AlgorithmEdDSA, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keyring := NewKeyring()
+			if _, err := keyring.Rotate(test.algorithm, 0, time.Hour); err != nil {
+				t.Fatalf("Rotate()\nerror = %v", err)
+			}
+
+			token, err := keyring.MakeJWT(userID, uuid.NewString(), time.Hour)
+			if err != nil {
+				t.Fatalf("MakeJWT()\nerror = %v", err)
+			}
+
+			path := filepath.Join(t.TempDir(), "keyring.json")
+			if err := keyring.Save(path); err != nil {
+				t.Fatalf("Save()\nerror = %v", err)
+			}
+			loaded, err := LoadKeyring(path)
+			if err != nil {
+				t.Fatalf("LoadKeyring()\nerror = %v", err)
+			}
+
+			id, err := loaded.ValidateJWT(token)
+			if err != nil || id != userID {
+				t.Errorf("ValidateJWT()\nuserId = %v\nwantUserId = %v\nerror = %v", id, userID, err)
+			}
+
+			if jwks := loaded.JWKS(); len(jwks.Keys) != test.wantJWKS {
+				t.Errorf("JWKS()\nlen = %d\nwantLen = %d", len(jwks.Keys), test.wantJWKS)
+			}
+		})
+	}
+}
+
+func TestKeyringRotate(t *testing.T) {
+	userID := uuid.New()
+	keyring := NewKeyring(SecretSigningKey("secret"))
+	legacyToken, _ := MakeJWT(userID, "secret", time.Hour)
+
+	if _, err := keyring.Rotate(AlgorithmEdDSA, 0, time.Hour); err != nil {
+		t.Fatalf("Rotate()\nerror = %v", err)
+	}
+	rotatedToken, _ := keyring.MakeJWT(userID, uuid.NewString(), time.Hour)
+
+	expired := NewKeyring(keyring.Keys()...)
+	expired.keys[0].ExpiresAt = time.Now().Add(-time.Minute)
+
+	unknown := NewKeyring()
+	unknown.Rotate(AlgorithmEdDSA, 0, time.Hour)
+
+	tests := []struct {
+		name    string
+		keyring *Keyring
+		token   string
+		wantErr bool
+	}{
+		{"Token from the retired key", keyring, legacyToken, false},
+		{"Token from the new key", keyring, rotatedToken, false},
+		{"Token from an expired key", expired, legacyToken, true},
+		{"Token from another keyring", unknown, rotatedToken, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := test.keyring.ValidateJWT(test.token)
+			if (err != nil) != test.wantErr {
+				t.Errorf("ValidateJWT()\nerror = %v\nwantErr = %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestKeyringActivation(t *testing.T) {
+	userID := uuid.New()
+	keyring := NewKeyring(SecretSigningKey("secret"))
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	keyring.Save(path)
+
+	rotated, _ := LoadKeyring(path)
+	key, err := rotated.Rotate(AlgorithmEdDSA, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatalf("Rotate()\nerror = %v", err)
+	}
+	rotated.Save(path)
+
+	if err := keyring.Reload(path); err != nil {
+		t.Fatalf("Reload()\nerror = %v", err)
+	}
+	if keys := keyring.Keys(); len(keys) != 2 {
+		t.Fatalf("Reload()\nlen = %d\nwantLen = 2", len(keys))
+	}
+
+	// The old key signs until the new one activates.
+	token, _ := keyring.MakeJWT(userID, uuid.NewString(), time.Hour)
+	if _, err := ValidateJWT(token, "secret"); err != nil {
+		t.Errorf("ValidateJWT() before the new key activates\nerror = %v", err)
+	}
+
+	keyring.keys[1].ActivatesAt = time.Now().Add(-time.Second)
+	token, _ = keyring.MakeJWT(userID, uuid.NewString(), time.Hour)
+	parsed, _, _ := jwt.NewParser().ParseUnverified(token, &jwt.RegisteredClaims{})
+	if kid := parsed.Header["kid"]; kid != key.ID {
+		t.Errorf("MakeJWT() after the new key activates\nkid = %v\nwantKid = %v", kid, key.ID)
+	}
+}
+
+func TestKeyringRejectsAlgorithmConfusion(t *testing.T) {
+	keyring := NewKeyring()
+	key, _ := keyring.Rotate(AlgorithmRS256, 0, time.Hour)
+
+	// Sign with HS256 using the published RSA public key as the secret.
+	public, _ := x509.MarshalPKIXPublicKey(key.private.Public().(*rsa.PublicKey))
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer:  string(TokenTypeAccess),
+		Subject: uuid.New().String(),
+	})
+	token.Header["kid"] = key.ID
+	forged, _ := token.SignedString(public)
+
+	if _, err := keyring.ValidateJWT(forged); err == nil {
+		t.Errorf("ValidateJWT()\nerror = nil\nwantErr = true")
+	}
+}
+
+func TestEmailVerificationJWT(t *testing.T) {
+	keyring := NewKeyring(SecretSigningKey("secret"))
+	userID := uuid.New()
+
+	token, _ := keyring.MakeEmailVerificationJWT(userID, "alice@example.com", time.Minute)
+	id, email, err := keyring.ValidateEmailVerificationJWT(token)
+	if err != nil || id != userID || email != "alice@example.com" {
+		t.Errorf("ValidateEmailVerificationJWT()\nuserId = %v\nemail = %v\nerror = %v", id, email, err)
+	}
+	if _, err := keyring.ValidateJWT(token); err == nil {
+		t.Errorf("ValidateJWT() of a verification token\nerror = nil\nwantErr = true")
+	}
+
+	challenge, _ := keyring.MakeChallengeJWT(userID, uuid.NewString(), time.Minute)
+	if _, _, err := keyring.ValidateEmailVerificationJWT(challenge); err == nil {
+		t.Errorf("ValidateEmailVerificationJWT() of a challenge token\nerror = nil\nwantErr = true")
+	}
+}
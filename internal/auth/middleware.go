@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/fernando8franco/http-server-golang/internal/authz"
+	"github.com/google/uuid"
+)
+
+type contextKey int
+
+const (
+	principalKey contextKey = iota
+	accessTokenKey
+)
+
+var ErrAccessTokenDenied = errors.New("access token was revoked")
+var ErrInsufficientScope = errors.New("token doesn't have the required scope")
+
+// ErrPrincipalNotFound is returned, possibly wrapped, by the loaders when the
+// user or the token can no longer be used. Any other error of a loader or the
+// denylist is a failed lookup and answered with 500, so clients don't drop
+// their credentials during a database outage.
+var ErrPrincipalNotFound = errors.New("user or token not found")
+
+var errLookupFailed = errors.New("couldn't check the access token")
+
+// PrincipalLoader looks up the user an access token was issued to. It should
+// fail with ErrPrincipalNotFound when the user no longer exists.
+type PrincipalLoader func(ctx context.Context, userID uuid.UUID) (authz.Principal, error)
+
+// PersonalAccessTokenLoader looks up the user and scopes of a personal access
+// token by its hash. It should fail with ErrPrincipalNotFound when the token
+// was deleted or expired.
+type PersonalAccessTokenLoader func(ctx context.Context, tokenHash string) (authz.Principal, error)
+
+// Denylist holds the ids of access tokens that were revoked before they
+// expired.
+type Denylist interface {
+	IsAccessTokenDenied(ctx context.Context, jti string) (bool, error)
+}
+
+// Authenticator validates access tokens and stores the principal of the
+// request in its context.
+type Authenticator struct {
+	keyring   *Keyring
+	denylist  Denylist
+	load      PrincipalLoader
+	loadToken PersonalAccessTokenLoader
+}
+
+func NewAuthenticator(keyring *Keyring, denylist Denylist, load PrincipalLoader, loadToken PersonalAccessTokenLoader) *Authenticator {
+	return &Authenticator{
+		keyring:   keyring,
+		denylist:  denylist,
+		load:      load,
+		loadToken: loadToken,
+	}
+}
+
+// Required rejects requests without a valid access token. Personal access
+// tokens are only accepted when they have every one of scopes, so a route
+// that lists none can only be used after logging in.
+func (a *Authenticator) Required(next http.Handler, scopes ...authz.Scope) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, err := a.authenticate(r, scopes)
+		if errors.Is(err, ErrInsufficientScope) {
+			respondInsufficientScope(w, scopes)
+			return
+		}
+		if errors.Is(err, errLookupFailed) {
+			respondLookupFailed(w, err)
+			return
+		}
+		if err != nil {
+			respondUnauthorized(w, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Optional lets requests without an Authorization header through
+// anonymously, but still rejects the ones with an invalid token or one that
+// is missing scopes.
+func (a *Authenticator) Optional(next http.Handler, scopes ...authz.Scope) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, err := a.authenticate(r, scopes)
+		if errors.Is(err, ErrNoAuthHeaderIncluded) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if errors.Is(err, ErrInsufficientScope) {
+			respondInsufficientScope(w, scopes)
+			return
+		}
+		if errors.Is(err, errLookupFailed) {
+			respondLookupFailed(w, err)
+			return
+		}
+		if err != nil {
+			respondUnauthorized(w, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authenticate returns the request context with the principal and the access
+// token added.
+func (a *Authenticator) authenticate(r *http.Request, scopes []authz.Scope) (context.Context, error) {
+	tokenString, err := GetBearerToken(r.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(tokenString, PersonalAccessTokenPrefix) {
+		return a.authenticatePersonalAccessToken(r, tokenString, scopes)
+	}
+
+	accessToken, err := a.keyring.ParseJWT(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	if accessToken.ID != "" {
+		denied, err := a.denylist.IsAccessTokenDenied(r.Context(), accessToken.ID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errLookupFailed, err)
+		}
+		if denied {
+			return nil, ErrAccessTokenDenied
+		}
+	}
+
+	principal, err := a.load(r.Context(), accessToken.UserID)
+	if err != nil {
+		return nil, lookupError(err)
+	}
+
+	ctx := WithPrincipal(r.Context(), principal)
+	return context.WithValue(ctx, accessTokenKey, accessToken), nil
+}
+
+func (a *Authenticator) authenticatePersonalAccessToken(r *http.Request, tokenString string, scopes []authz.Scope) (context.Context, error) {
+	principal, err := a.loadToken(r.Context(), HashPersonalAccessToken(tokenString))
+	if err != nil {
+		return nil, lookupError(err)
+	}
+
+	if principal.Scopes == nil || len(scopes) == 0 {
+		return nil, ErrInsufficientScope
+	}
+	for _, scope := range scopes {
+		if !principal.HasScope(scope) {
+			return nil, ErrInsufficientScope
+		}
+	}
+
+	return WithPrincipal(r.Context(), principal), nil
+}
+
+// lookupError marks an error of a loader as a failed lookup unless it says
+// the user or token is gone.
+func lookupError(err error) error {
+	if errors.Is(err, ErrPrincipalNotFound) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", errLookupFailed, err)
+}
+
+func WithPrincipal(ctx context.Context, principal authz.Principal) context.Context {
+	return context.WithValue(ctx, principalKey, principal)
+}
+
+// PrincipalFromContext returns the principal stored by the middleware. The
+// second value is false for anonymous requests.
+func PrincipalFromContext(ctx context.Context) (authz.Principal, bool) {
+	principal, ok := ctx.Value(principalKey).(authz.Principal)
+	return principal, ok
+}
+
+// AccessTokenFromContext returns the access token the request was
+// authenticated with.
+func AccessTokenFromContext(ctx context.Context) (AccessToken, bool) {
+	accessToken, ok := ctx.Value(accessTokenKey).(AccessToken)
+	return accessToken, ok
+}
+
+// respondUnauthorized sends the same body for every failure so clients can't
+// tell a malformed token from an expired one or a deleted user. The reason is
+// only given in the WWW-Authenticate header, as RFC 6750 describes.
+func respondUnauthorized(w http.ResponseWriter, err error) {
+	challenge := `Bearer realm="chirpy"`
+	if !errors.Is(err, ErrNoAuthHeaderIncluded) {
+		challenge += `, error="invalid_token"`
+	}
+
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": "Missing or invalid access token",
+	})
+}
+
+// respondLookupFailed answers 500 when the token couldn't be checked, so the
+// client keeps its credentials and tries again later.
+func respondLookupFailed(w http.ResponseWriter, err error) {
+	log.Println(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": "Couldn't check the access token",
+	})
+}
+
+// respondInsufficientScope rejects a valid personal access token that wasn't
+// given the scopes of the route, naming them as RFC 6750 describes.
+func respondInsufficientScope(w http.ResponseWriter, scopes []authz.Scope) {
+	challenge := `Bearer realm="chirpy", error="insufficient_scope"`
+	if len(scopes) > 0 {
+		names := make([]string, len(scopes))
+		for i, scope := range scopes {
+			names[i] = string(scope)
+		}
+		challenge += `, scope="` + strings.Join(names, " ") + `"`
+	}
+
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": "The token doesn't have the scope this action requires",
+	})
+}
@@ -0,0 +1,288 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fernando8franco/http-server-golang/internal/authz"
+	"github.com/google/uuid"
+)
+
+type testDenylist map[string]bool
+
+var errDatabaseDown = errors.New("database is down")
+
+func (d testDenylist) IsAccessTokenDenied(ctx context.Context, jti string) (bool, error) {
+	if jti == "unavailable" {
+		return false, errDatabaseDown
+	}
+	return d[jti], nil
+}
+
+func loadTestToken(ctx context.Context, tokenHash string) (authz.Principal, error) {
+	return authz.Principal{}, ErrPrincipalNotFound
+}
+
+func TestAuthenticator(t *testing.T) {
+	userID := uuid.New()
+	deletedID := uuid.New()
+	keyring := NewKeyring(SecretSigningKey("secret"))
+	validToken, _ := keyring.MakeJWT(userID, uuid.NewString(), time.Hour)
+	deletedToken, _ := keyring.MakeJWT(deletedID, uuid.NewString(), time.Hour)
+	deniedToken, _ := keyring.MakeJWT(userID, "denied", time.Hour)
+	unavailableToken, _ := keyring.MakeJWT(userID, "unavailable", time.Hour)
+	brokenID := uuid.New()
+	brokenToken, _ := keyring.MakeJWT(brokenID, uuid.NewString(), time.Hour)
+
+	load := func(ctx context.Context, id uuid.UUID) (authz.Principal, error) {
+		if id == brokenID {
+			return authz.Principal{}, errDatabaseDown
+		}
+		if id != userID {
+			return authz.Principal{}, ErrPrincipalNotFound
+		}
+		return authz.Principal{UserID: id}, nil
+	}
+	authenticator := NewAuthenticator(keyring, testDenylist{"denied": true}, load, loadTestToken)
+
+	tests := []struct {
+		name          string
+		optional      bool
+		authorization string
+		wantStatus    int
+		wantChallenge string
+		wantPrincipal bool
+	}{
+		{
+			"Required without header",
+			false,
+			"",
+			http.StatusUnauthorized,
+			`Bearer realm="chirpy"`,
+			false,
+		},
+		{
+			"Required with invalid token",
+			false,
+			"Bearer invalid-token-string",
+			http.StatusUnauthorized,
+			`Bearer realm="chirpy", error="invalid_token"`,
+			false,
+		},
+		{
+			"Required with deleted user",
+			false,
+			"Bearer " + deletedToken,
+			http.StatusUnauthorized,
+			`Bearer realm="chirpy", error="invalid_token"`,
+			false,
+		},
+		{
+			"Required with denied token",
+			false,
+			"Bearer " + deniedToken,
+			http.StatusUnauthorized,
+			`Bearer realm="chirpy", error="invalid_token"`,
+			false,
+		},
+		{
+			"Required when the denylist fails",
+			false,
+			"Bearer " + unavailableToken,
+			http.StatusInternalServerError,
+			"",
+			false,
+		},
+		{
+			"Required when loading the user fails",
+			false,
+			"Bearer " + brokenToken,
+			http.StatusInternalServerError,
+			"",
+			false,
+		},
+		{
+			"Required with valid token",
+			false,
+			"Bearer " + validToken,
+			http.StatusOK,
+			"",
+			true,
+		},
+		{
+			"Optional without header",
+			true,
+			"",
+			http.StatusOK,
+			"",
+			false,
+		},
+		{
+			"Optional with invalid token",
+			true,
+			"Bearer invalid-token-string",
+			http.StatusUnauthorized,
+			`Bearer realm="chirpy", error="invalid_token"`,
+			false,
+		},
+		{
+			"Optional with valid token",
+			true,
+			"Bearer " + validToken,
+			http.StatusOK,
+			"",
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var principal authz.Principal
+			var gotPrincipal, gotAccessToken bool
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				principal, gotPrincipal = PrincipalFromContext(r.Context())
+				_, gotAccessToken = AccessTokenFromContext(r.Context())
+			})
+
+			handler := authenticator.Required(next)
+			if test.optional {
+				handler = authenticator.Optional(next)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.authorization != "" {
+				req.Header.Set("Authorization", test.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != test.wantStatus {
+				t.Errorf("Authenticator()\nstatus = %v\nwantStatus = %v", rec.Code, test.wantStatus)
+			}
+
+			if challenge := rec.Header().Get("WWW-Authenticate"); challenge != test.wantChallenge {
+				t.Errorf("Authenticator()\nchallenge = %v\nwantChallenge = %v", challenge, test.wantChallenge)
+			}
+
+			if gotPrincipal != test.wantPrincipal || gotAccessToken != test.wantPrincipal {
+				t.Errorf("Authenticator()\ngotPrincipal = %v\ngotAccessToken = %v\nwantPrincipal = %v", gotPrincipal, gotAccessToken, test.wantPrincipal)
+			}
+
+			if test.wantPrincipal && principal.UserID != userID {
+				t.Errorf("Authenticator()\nuserID = %v\nwantUserID = %v", principal.UserID, userID)
+			}
+		})
+	}
+}
+
+func TestAuthenticatorScopes(t *testing.T) {
+	userID := uuid.New()
+	keyring := NewKeyring(SecretSigningKey("secret"))
+	sessionToken, _ := keyring.MakeJWT(userID, uuid.NewString(), time.Hour)
+	personalToken := MakePersonalAccessToken()
+
+	load := func(ctx context.Context, id uuid.UUID) (authz.Principal, error) {
+		return authz.Principal{UserID: id}, nil
+	}
+	loadToken := func(ctx context.Context, tokenHash string) (authz.Principal, error) {
+		if tokenHash != HashPersonalAccessToken(personalToken) {
+			return authz.Principal{}, ErrPrincipalNotFound
+		}
+		return authz.Principal{UserID: userID, Scopes: []authz.Scope{authz.ScopeChirpsRead}}, nil
+	}
+	authenticator := NewAuthenticator(keyring, testDenylist{}, load, loadToken)
+
+	tests := []struct {
+		name          string
+		optional      bool
+		token         string
+		scopes        []authz.Scope
+		wantStatus    int
+		wantChallenge string
+	}{
+		{
+			"Session on a scoped route",
+			false,
+			sessionToken,
+			[]authz.Scope{authz.ScopeChirpsWrite},
+			http.StatusOK,
+			"",
+		},
+		{
+			"Token with the scope",
+			false,
+			personalToken,
+			[]authz.Scope{authz.ScopeChirpsRead},
+			http.StatusOK,
+			"",
+		},
+		{
+			"Token without the scope",
+			false,
+			personalToken,
+			[]authz.Scope{authz.ScopeChirpsWrite},
+			http.StatusForbidden,
+			`Bearer realm="chirpy", error="insufficient_scope", scope="chirps:write"`,
+		},
+		{
+			"Token on a session only route",
+			false,
+			personalToken,
+			nil,
+			http.StatusForbidden,
+			`Bearer realm="chirpy", error="insufficient_scope"`,
+		},
+		{
+			"Unknown token",
+			false,
+			MakePersonalAccessToken(),
+			[]authz.Scope{authz.ScopeChirpsRead},
+			http.StatusUnauthorized,
+			`Bearer realm="chirpy", error="invalid_token"`,
+		},
+		{
+			"Optional with the scope",
+			true,
+			personalToken,
+			[]authz.Scope{authz.ScopeChirpsRead},
+			http.StatusOK,
+			"",
+		},
+		{
+			"Optional without the scope",
+			true,
+			personalToken,
+			[]authz.Scope{authz.ScopeChirpsWrite},
+			http.StatusForbidden,
+			`Bearer realm="chirpy", error="insufficient_scope", scope="chirps:write"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+			handler := authenticator.Required(next, test.scopes...)
+			if test.optional {
+				handler = authenticator.Optional(next, test.scopes...)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+test.token)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != test.wantStatus {
+				t.Errorf("Authenticator()\nstatus = %v\nwantStatus = %v", rec.Code, test.wantStatus)
+			}
+
+			if challenge := rec.Header().Get("WWW-Authenticate"); challenge != test.wantChallenge {
+				t.Errorf("Authenticator()\nchallenge = %v\nwantChallenge = %v", challenge, test.wantChallenge)
+			}
+		})
+	}
+}
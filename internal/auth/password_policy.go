@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PasswordPolicy is what a new password must satisfy. A zero field turns its
+// rule off.
+type PasswordPolicy struct {
+	MinLength int
+	// MinClasses is how many of lowercase letters, uppercase letters, digits
+	// and other characters the password must mix.
+	MinClasses int
+	// Breached lists leaked passwords that may not be reused.
+	Breached *BreachedPasswords
+}
+
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:  8,
+	MinClasses: 2,
+}
+
+// The rules a password can break, as reported in PasswordViolation.
+const (
+	PasswordRuleMinLength  = "min_length"
+	PasswordRuleMinClasses = "min_classes"
+	PasswordRuleEmail      = "contains_email"
+	PasswordRuleBreached   = "breached"
+)
+
+// PasswordViolation is a rule of the policy a password breaks.
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PasswordError lists every rule a password breaks, so they can all be fixed
+// at once.
+type PasswordError struct {
+	Violations []PasswordViolation
+}
+
+func (e *PasswordError) Error() string {
+	rules := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		rules[i] = violation.Rule
+	}
+	return "password breaks the policy: " + strings.Join(rules, ", ")
+}
+
+// Check returns a *PasswordError when password breaks the policy for the
+// user with email, which may be empty when it isn't known.
+func (p PasswordPolicy) Check(password, email string) error {
+	var violations []PasswordViolation
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, PasswordViolation{
+			Rule:    PasswordRuleMinLength,
+			Message: fmt.Sprintf("Use at least %d characters", p.MinLength),
+		})
+	}
+
+	if passwordClasses(password) < p.MinClasses {
+		violations = append(violations, PasswordViolation{
+			Rule:    PasswordRuleMinClasses,
+			Message: fmt.Sprintf("Mix at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinClasses),
+		})
+	}
+
+	if containsEmail(password, email) {
+		violations = append(violations, PasswordViolation{
+			Rule:    PasswordRuleEmail,
+			Message: "Don't use your email address",
+		})
+	}
+
+	if p.Breached != nil && p.Breached.Contains(password) {
+		violations = append(violations, PasswordViolation{
+			Rule:    PasswordRuleBreached,
+			Message: "This password appeared in a data breach, choose another one",
+		})
+	}
+
+	if len(violations) > 0 {
+		return &PasswordError{Violations: violations}
+	}
+	return nil
+}
+
+func passwordClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, other} {
+		if present {
+			classes++
+		}
+	}
+	return classes
+}
+
+// containsEmail reports whether password contains the email address or its
+// local part. Local parts too short to be guessed from are ignored.
+func containsEmail(password, email string) bool {
+	if email == "" {
+		return false
+	}
+
+	password = strings.ToLower(password)
+	email = strings.ToLower(email)
+	local, _, _ := strings.Cut(email, "@")
+
+	return strings.Contains(password, email) || (len(local) >= 3 && strings.Contains(password, local))
+}
+
+// breachedPrefixLength is how many hex characters of the SHA-1 hash pick a
+// range, like the Pwned Passwords range API.
+const breachedPrefixLength = 5
+
+// BreachedPasswords is a list of leaked passwords in the Pwned Passwords
+// format: one uppercase SHA-1 hash per line, optionally followed by a colon
+// and a count. The hashes are grouped by prefix the way the range API serves
+// them, so a lookup only ever compares against one small range.
+type BreachedPasswords struct {
+	ranges map[string]map[string]struct{}
+}
+
+// LoadBreachedPasswords reads the list of leaked passwords at path.
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadBreachedPasswords(f)
+}
+
+// ReadBreachedPasswords reads a list of leaked passwords from r.
+func ReadBreachedPasswords(r io.Reader) (*BreachedPasswords, error) {
+	breached := &BreachedPasswords{ranges: map[string]map[string]struct{}{}}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		hash, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if hash == "" {
+			continue
+		}
+
+		hash = strings.ToUpper(hash)
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != 2*sha1.Size {
+			return nil, fmt.Errorf("line %d: %q is not a SHA-1 hash", line, hash)
+		}
+
+		prefix, suffix := hash[:breachedPrefixLength], hash[breachedPrefixLength:]
+		if breached.ranges[prefix] == nil {
+			breached.ranges[prefix] = map[string]struct{}{}
+		}
+		breached.ranges[prefix][suffix] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return breached, nil
+}
+
+// Contains reports whether password is on the list.
+func (b *BreachedPasswords) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	_, ok := b.ranges[hash[:breachedPrefixLength]][hash[breachedPrefixLength:]]
+	return ok
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	breached, err := ReadBreachedPasswords(strings.NewReader(
+		"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493\n" +
+			"\n" +
+			"21bd12dc183f740ee76f27b78eb39c8ad972a757\n",
+	))
+	if err != nil {
+		t.Fatalf("ReadBreachedPasswords()\nerror = %v", err)
+	}
+
+	policy := PasswordPolicy{MinLength: 8, MinClasses: 2, Breached: breached}
+
+	tests := []struct {
+		name      string
+		password  string
+		email     string
+		wantRules []string
+	}{
+		{"Strong password", "correct-horse-battery", "alice@example.com", nil},
+		{"Empty password", "", "alice@example.com", []string{PasswordRuleMinLength, PasswordRuleMinClasses}},
+		{"Short password", "ab1!", "alice@example.com", []string{PasswordRuleMinLength}},
+		{"Single class", "abcdefghij", "alice@example.com", []string{PasswordRuleMinClasses}},
+		{"Multibyte characters", "pässwörd1", "alice@example.com", nil},
+		{"Contains the email", "ALICE@example.com1", "alice@example.com", []string{PasswordRuleEmail}},
+		{"Contains the local part", "Alice-2024", "alice@example.com", []string{PasswordRuleEmail}},
+		{"Unknown email", "Alice-2024", "", nil},
+		{"Breached password", "P@ssw0rd", "alice@example.com", []string{PasswordRuleBreached}},
+		{"Breached and weak", "password", "alice@example.com", []string{PasswordRuleMinClasses, PasswordRuleBreached}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := policy.Check(test.password, test.email)
+
+			var rules []string
+			var passwordErr *PasswordError
+			if errors.As(err, &passwordErr) {
+				for _, violation := range passwordErr.Violations {
+					rules = append(rules, violation.Rule)
+				}
+			} else if err != nil {
+				t.Fatalf("Check()\nerror = %v\nwant a *PasswordError", err)
+			}
+
+			if !slices.Equal(rules, test.wantRules) {
+				t.Errorf("Check()\nrules = %v\nwantRules = %v", rules, test.wantRules)
+			}
+		})
+	}
+}
+
+func TestReadBreachedPasswordsInvalid(t *testing.T) {
+	_, err := ReadBreachedPasswords(strings.NewReader("not-a-hash:12\n"))
+	if err == nil {
+		t.Errorf("ReadBreachedPasswords()\nerror = %v\nwant an error", err)
+	}
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestValidateTOTP(t *testing.T) {
+	// The SHA-1 test vectors of RFC 6238, cut down to 6 digits.
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		name     string
+		time     int64
+		code     string
+		wantStep int64
+		wantOk   bool
+	}{
+		{"T = 59", 59, "287082", 1, true},
+		{"T = 1111111109", 1111111109, "081804", 37037036, true},
+		{"T = 1111111111", 1111111111, "050471", 37037037, true},
+		{"T = 1234567890", 1234567890, "005924", 41152263, true},
+		{"T = 2000000000", 2000000000, "279037", 66666666, true},
+		{"Previous step", 1111111111 + totpPeriod, "050471", 37037037, true},
+		{"Too old", 1111111111 + 2*totpPeriod, "050471", 0, false},
+		{"Wrong code", 59, "287083", 0, false},
+		{"Wrong length", 59, "94287082", 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(secret, test.code, time.Unix(test.time, 0))
+			if step != test.wantStep || ok != test.wantOk {
+				t.Errorf("ValidateTOTP()\nstep = %v\nok = %v\nwantStep = %v\nwantOk = %v", step, ok, test.wantStep, test.wantOk)
+			}
+		})
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("JBSWY3DPEHPK3PXP", "Chirpy", "alice@example.com")
+
+	want := "otpauth://totp/Chirpy:alice@example.com?algorithm=SHA1&digits=6&issuer=Chirpy&period=30&secret=JBSWY3DPEHPK3PXP"
+	if uri != want {
+		t.Errorf("TOTPURI()\nuri = %v\nwantUri = %v", uri, want)
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	codes := GenerateRecoveryCodes(10)
+	if len(codes) != 10 || len(codes[0]) != 11 || codes[0] == codes[1] {
+		t.Fatalf("GenerateRecoveryCodes()\ncodes = %v", codes)
+	}
+
+	typed := strings.ToUpper(strings.ReplaceAll(codes[0], "-", " "))
+	if HashRecoveryCode(typed) != HashRecoveryCode(codes[0]) {
+		t.Errorf("HashRecoveryCode(%q)\nwant the hash of %q", typed, codes[0])
+	}
+}
+
+func TestChallengeJWT(t *testing.T) {
+	keyring := NewKeyring(SecretSigningKey("secret"))
+	userID := uuid.New()
+
+	challengeID := uuid.NewString()
+	challenge, _ := keyring.MakeChallengeJWT(userID, challengeID, time.Minute)
+	if parsed, err := keyring.ParseChallengeJWT(challenge); err != nil || parsed.UserID != userID || parsed.ID != challengeID {
+		t.Errorf("ParseChallengeJWT()\nchallenge = %+v\nerror = %v", parsed, err)
+	}
+	if _, err := keyring.ValidateJWT(challenge); err == nil {
+		t.Errorf("ValidateJWT() of a challenge token\nerror = nil\nwantErr = true")
+	}
+
+	accessToken, _ := keyring.MakeJWT(userID, uuid.NewString(), time.Minute)
+	if _, err := keyring.ParseChallengeJWT(accessToken); err == nil {
+		t.Errorf("ParseChallengeJWT() of an access token\nerror = nil\nwantErr = true")
+	}
+}
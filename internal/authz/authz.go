@@ -0,0 +1,91 @@
+package authz
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+var ErrForbidden = errors.New("action not allowed")
+
+// Principal is the user a request acts on behalf of.
+type Principal struct {
+	UserID        uuid.UUID
+	IsAdmin       bool
+	EmailVerified bool
+	// Scopes is nil when the user logged in, which allows everything, and
+	// holds the scopes of the personal access token otherwise.
+	Scopes []Scope
+}
+
+// HasScope reports whether the request may act within scope.
+func (p Principal) HasScope(scope Scope) bool {
+	return p.Scopes == nil || slices.Contains(p.Scopes, scope)
+}
+
+// Scope is a part of the API a personal access token can be given access to.
+type Scope string
+
+const (
+	ScopeChirpsRead   Scope = "chirps:read"
+	ScopeChirpsWrite  Scope = "chirps:write"
+	ScopeProfileWrite Scope = "profile:write"
+)
+
+// Scopes lists every scope, in the order they are documented.
+var Scopes = []Scope{ScopeChirpsRead, ScopeChirpsWrite, ScopeProfileWrite}
+
+// Resource is what an action is performed on. Only the owner matters to the
+// current policies, it is the author for chirps and the user itself for
+// profiles.
+type Resource struct {
+	OwnerID uuid.UUID
+}
+
+type Action string
+
+const (
+	CreateChirp  Action = "chirp:create"
+	EditChirp    Action = "chirp:edit"
+	DeleteChirp  Action = "chirp:delete"
+	RestoreChirp Action = "chirp:restore"
+	UpdateUser   Action = "user:update"
+	UnlockUser   Action = "user:unlock"
+)
+
+// Policy reports whether principal may perform an action on resource.
+type Policy func(principal Principal, resource Resource) bool
+
+func Authenticated(principal Principal, resource Resource) bool {
+	return principal.UserID != uuid.Nil
+}
+
+func Owner(principal Principal, resource Resource) bool {
+	return principal.UserID != uuid.Nil && principal.UserID == resource.OwnerID
+}
+
+func Admin(principal Principal, resource Resource) bool {
+	return principal.IsAdmin
+}
+
+// policies lists, for every action, the policies that allow it. An action is
+// allowed when any of its policies is, and denied when it has none.
+var policies = map[Action][]Policy{
+	CreateChirp:  {Authenticated},
+	EditChirp:    {Owner},
+	DeleteChirp:  {Owner, Admin},
+	RestoreChirp: {Owner},
+	UpdateUser:   {Owner},
+	UnlockUser:   {Admin},
+}
+
+func Authorize(principal Principal, action Action, resource Resource) error {
+	for _, policy := range policies[action] {
+		if policy(principal, resource) {
+			return nil
+		}
+	}
+
+	return ErrForbidden
+}
@@ -0,0 +1,50 @@
+package authz
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestAuthorize(t *testing.T) {
+	alice := Principal{UserID: uuid.New()}
+	bob := Principal{UserID: uuid.New()}
+	admin := Principal{UserID: uuid.New(), IsAdmin: true}
+	anonymous := Principal{}
+
+	aliceChirp := Resource{OwnerID: alice.UserID}
+
+	tests := []struct {
+		name      string
+		principal Principal
+		action    Action
+		resource  Resource
+		wantErr   bool
+	}{
+		{"Author creates chirp", alice, CreateChirp, aliceChirp, false},
+		{"Anonymous creates chirp", anonymous, CreateChirp, aliceChirp, true},
+		{"Author edits own chirp", alice, EditChirp, aliceChirp, false},
+		{"Other user edits chirp", bob, EditChirp, aliceChirp, true},
+		{"Admin edits chirp", admin, EditChirp, aliceChirp, true},
+		{"Author deletes own chirp", alice, DeleteChirp, aliceChirp, false},
+		{"Other user deletes chirp", bob, DeleteChirp, aliceChirp, true},
+		{"Admin deletes any chirp", admin, DeleteChirp, aliceChirp, false},
+		{"Anonymous deletes chirp", anonymous, DeleteChirp, Resource{}, true},
+		{"Author restores own chirp", alice, RestoreChirp, aliceChirp, false},
+		{"Other user restores chirp", bob, RestoreChirp, aliceChirp, true},
+		{"User updates self", alice, UpdateUser, Resource{OwnerID: alice.UserID}, false},
+		{"User updates someone else", bob, UpdateUser, Resource{OwnerID: alice.UserID}, true},
+		{"Admin unlocks user", admin, UnlockUser, Resource{}, false},
+		{"User unlocks user", alice, UnlockUser, Resource{}, true},
+		{"Unknown action", admin, Action("chirp:launch"), aliceChirp, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Authorize(test.principal, test.action, test.resource)
+			if (err != nil) != test.wantErr {
+				t.Errorf("Authorize()\nerror = %v\nwantErr = %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -7,6 +7,8 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -14,7 +16,7 @@ import (
 const createChirp = `-- name: CreateChirp :one
 INSERT INTO chirps (id, created_at, updated_at, body, user_id)
 VALUES (gen_random_uuid(), NOW(), NOW(), $1, $2)
-RETURNING id, created_at, updated_at, body, user_id
+RETURNING id, created_at, updated_at, body, user_id, deleted_at, edited_at
 `
 
 type CreateChirpParams struct {
@@ -31,6 +33,8 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.DeletedAt,
+		&i.EditedAt,
 	)
 	return i, err
 }
@@ -44,23 +48,111 @@ func (q *Queries) DeleteChirp(ctx context.Context) error {
 	return err
 }
 
-const deleteChirpById = `-- name: DeleteChirpById :exec
-DELETE FROM chirps
+const getChirpIncludingDeleted = `-- name: GetChirpIncludingDeleted :one
+SELECT id, created_at, updated_at, body, user_id, deleted_at, edited_at FROM chirps
 WHERE id = $1
 `
 
-func (q *Queries) DeleteChirpById(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.ExecContext(ctx, deleteChirpById, id)
-	return err
+func (q *Queries) GetChirpIncludingDeleted(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getChirpIncludingDeleted, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.DeletedAt,
+		&i.EditedAt,
+	)
+	return i, err
+}
+
+const getOneChirp = `-- name: GetOneChirp :one
+SELECT id, created_at, updated_at, body, user_id, deleted_at, edited_at FROM chirps
+WHERE id = $1
+AND deleted_at IS NULL
+`
+
+func (q *Queries) GetOneChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getOneChirp, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.DeletedAt,
+		&i.EditedAt,
+	)
+	return i, err
 }
 
-const getAllChirps = `-- name: GetAllChirps :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
-ORDER BY created_at ASC
+const listChirpRevisions = `-- name: ListChirpRevisions :many
+SELECT id, chirp_id, body, created_at FROM chirp_revisions
+WHERE chirp_id = $1
+ORDER BY created_at DESC, id DESC
 `
 
-func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getAllChirps)
+func (q *Queries) ListChirpRevisions(ctx context.Context, chirpID uuid.UUID) ([]ChirpRevision, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpRevisions, chirpID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ChirpRevision
+	for rows.Next() {
+		var i ChirpRevision
+		if err := rows.Scan(
+			&i.ID,
+			&i.ChirpID,
+			&i.Body,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listChirps = `-- name: ListChirps :many
+SELECT id, created_at, updated_at, body, user_id, deleted_at, edited_at FROM chirps
+WHERE deleted_at IS NULL
+AND ($1::timestamp IS NULL
+    OR (created_at, id) > ($1::timestamp, $2::uuid))
+AND ($3::uuid IS NULL OR user_id = $3::uuid)
+AND ($4::timestamp IS NULL OR created_at >= $4::timestamp)
+AND ($5::timestamp IS NULL OR created_at < $5::timestamp)
+ORDER BY created_at ASC, id ASC
+LIMIT $6
+`
+
+type ListChirpsParams struct {
+	AfterCreatedAt sql.NullTime
+	AfterID        uuid.NullUUID
+	AuthorID       uuid.NullUUID
+	Since          sql.NullTime
+	Until          sql.NullTime
+	PageSize       int32
+}
+
+func (q *Queries) ListChirps(ctx context.Context, arg ListChirpsParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirps,
+		arg.AfterCreatedAt,
+		arg.AfterID,
+		arg.AuthorID,
+		arg.Since,
+		arg.Until,
+		arg.PageSize,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -74,6 +166,8 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.DeletedAt,
+			&i.EditedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -88,13 +182,93 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 	return items, nil
 }
 
-const getOneChirp = `-- name: GetOneChirp :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps
+const listChirpsDesc = `-- name: ListChirpsDesc :many
+SELECT id, created_at, updated_at, body, user_id, deleted_at, edited_at FROM chirps
+WHERE deleted_at IS NULL
+AND ($1::timestamp IS NULL
+    OR (created_at, id) < ($1::timestamp, $2::uuid))
+AND ($3::uuid IS NULL OR user_id = $3::uuid)
+AND ($4::timestamp IS NULL OR created_at >= $4::timestamp)
+AND ($5::timestamp IS NULL OR created_at < $5::timestamp)
+ORDER BY created_at DESC, id DESC
+LIMIT $6
+`
+
+type ListChirpsDescParams struct {
+	AfterCreatedAt sql.NullTime
+	AfterID        uuid.NullUUID
+	AuthorID       uuid.NullUUID
+	Since          sql.NullTime
+	Until          sql.NullTime
+	PageSize       int32
+}
+
+func (q *Queries) ListChirpsDesc(ctx context.Context, arg ListChirpsDescParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsDesc,
+		arg.AfterCreatedAt,
+		arg.AfterID,
+		arg.AuthorID,
+		arg.Since,
+		arg.Until,
+		arg.PageSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.DeletedAt,
+			&i.EditedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const purgeDeletedChirps = `-- name: PurgeDeletedChirps :execrows
+DELETE FROM chirps
+WHERE deleted_at < NOW() - $1::float8 * INTERVAL '1 second'
+`
+
+func (q *Queries) PurgeDeletedChirps(ctx context.Context, retentionSeconds float64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeDeletedChirps, retentionSeconds)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const restoreChirp = `-- name: RestoreChirp :one
+UPDATE chirps
+SET deleted_at = NULL, updated_at = NOW()
 WHERE id = $1
+AND deleted_at > NOW() - $2::float8 * INTERVAL '1 second'
+RETURNING id, created_at, updated_at, body, user_id, deleted_at, edited_at
 `
 
-func (q *Queries) GetOneChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, getOneChirp, id)
+type RestoreChirpParams struct {
+	ID            uuid.UUID
+	WindowSeconds float64
+}
+
+func (q *Queries) RestoreChirp(ctx context.Context, arg RestoreChirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, restoreChirp, arg.ID, arg.WindowSeconds)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -102,21 +276,142 @@ func (q *Queries) GetOneChirp(ctx context.Context, id uuid.UUID) (Chirp, error)
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.DeletedAt,
+		&i.EditedAt,
 	)
 	return i, err
 }
 
-const userOwnsChirp = `-- name: UserOwnsChirp :one
-SELECT EXISTS (
-    SELECT 1
-    FROM chirps
-    WHERE user_id = $1
+const searchChirps = `-- name: SearchChirps :many
+SELECT id, created_at, updated_at, body, user_id, edited_at, rank, snippet FROM (
+    SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.edited_at,
+        ts_rank(to_tsvector('english', chirps.body), query)::float8 AS rank,
+        ts_headline('english', chirps.body, query, 'StartSel=' || chr(57344) || ', StopSel=' || chr(57345) || ', HighlightAll=true')::text AS snippet
+    FROM chirps, to_tsquery('english', $1) AS query
+    WHERE to_tsvector('english', chirps.body) @@ query
+    AND chirps.deleted_at IS NULL
+    AND ($2::uuid IS NULL OR chirps.user_id = $2::uuid)
+    AND ($3::timestamp IS NULL OR chirps.created_at >= $3::timestamp)
+    AND ($4::timestamp IS NULL OR chirps.created_at < $4::timestamp)
+) AS results
+WHERE $5::float8 IS NULL
+OR (rank, created_at, id) < ($5::float8, $6::timestamp, $7::uuid)
+ORDER BY rank DESC, created_at DESC, id DESC
+LIMIT $8
+`
+
+type SearchChirpsParams struct {
+	Query          string
+	AuthorID       uuid.NullUUID
+	Since          sql.NullTime
+	Until          sql.NullTime
+	AfterRank      sql.NullFloat64
+	AfterCreatedAt sql.NullTime
+	AfterID        uuid.NullUUID
+	PageSize       int32
+}
+
+type SearchChirpsRow struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Body      string
+	UserID    uuid.UUID
+	EditedAt  sql.NullTime
+	Rank      float64
+	Snippet   string
+}
+
+func (q *Queries) SearchChirps(ctx context.Context, arg SearchChirpsParams) ([]SearchChirpsRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchChirps,
+		arg.Query,
+		arg.AuthorID,
+		arg.Since,
+		arg.Until,
+		arg.AfterRank,
+		arg.AfterCreatedAt,
+		arg.AfterID,
+		arg.PageSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchChirpsRow
+	for rows.Next() {
+		var i SearchChirpsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.EditedAt,
+			&i.Rank,
+			&i.Snippet,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const softDeleteChirpById = `-- name: SoftDeleteChirpById :execrows
+UPDATE chirps
+SET deleted_at = NOW(), updated_at = NOW()
+WHERE id = $1
+AND deleted_at IS NULL
+`
+
+func (q *Queries) SoftDeleteChirpById(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, softDeleteChirpById, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updateChirpBody = `-- name: UpdateChirpBody :one
+WITH locked AS (
+    SELECT chirps.id, chirps.body FROM chirps
+    WHERE chirps.id = $1
+    AND chirps.deleted_at IS NULL
+    FOR UPDATE
+), previous AS (
+    INSERT INTO chirp_revisions (id, chirp_id, body, created_at)
+    SELECT gen_random_uuid(), locked.id, locked.body, NOW() FROM locked
+    RETURNING chirp_id
 )
+UPDATE chirps
+SET body = $2, updated_at = NOW(), edited_at = NOW()
+FROM previous
+WHERE chirps.id = previous.chirp_id
+RETURNING chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.deleted_at, chirps.edited_at
 `
 
-func (q *Queries) UserOwnsChirp(ctx context.Context, userID uuid.UUID) (bool, error) {
-	row := q.db.QueryRowContext(ctx, userOwnsChirp, userID)
-	var exists bool
-	err := row.Scan(&exists)
-	return exists, err
+type UpdateChirpBodyParams struct {
+	ID   uuid.UUID
+	Body string
+}
+
+func (q *Queries) UpdateChirpBody(ctx context.Context, arg UpdateChirpBodyParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, updateChirpBody, arg.ID, arg.Body)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.DeletedAt,
+		&i.EditedAt,
+	)
+	return i, err
 }
@@ -0,0 +1,35 @@
+package mail
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Outbox writes every message to a .eml file in Dir instead of sending it,
+// for development and for servers without an SMTP relay.
+type Outbox struct {
+	Dir string
+}
+
+func (o *Outbox) Send(ctx context.Context, msg Message) error {
+	now := time.Now().UTC()
+	data, err := format("chirpy@localhost", msg, now)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(o.Dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	name := now.Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(suffix) + ".eml"
+
+	return os.WriteFile(filepath.Join(o.Dir, name), data, 0600)
+}
@@ -0,0 +1,65 @@
+package migrate
+
+// Dialect holds the statements used to keep track of the applied versions.
+// The version table uses the same layout as goose, so databases that were
+// migrated with the goose CLI are picked up without reapplying anything.
+type Dialect struct {
+	createVersionTable string
+	selectVersions     string
+	insertVersion      string
+	deleteVersion      string
+	// lock and unlock keep two migrators from running at once. They take the
+	// lock id and run on the same connection. Empty when the database needs
+	// no lock.
+	lock   string
+	unlock string
+}
+
+// lockID is the advisory lock goose takes by default, so this migrator and
+// the goose CLI also wait for each other.
+const lockID int64 = 5887940537704921958
+
+var Postgres = Dialect{
+	createVersionTable: `
+CREATE TABLE IF NOT EXISTS goose_db_version (
+    id SERIAL PRIMARY KEY,
+    version_id BIGINT NOT NULL,
+    is_applied BOOLEAN NOT NULL,
+    tstamp TIMESTAMP NULL DEFAULT NOW()
+)`,
+	selectVersions: `
+SELECT version_id, is_applied, tstamp FROM goose_db_version
+ORDER BY id DESC`,
+	insertVersion: `
+INSERT INTO goose_db_version (version_id, is_applied, tstamp)
+VALUES ($1, TRUE, $2)`,
+	deleteVersion: `
+DELETE FROM goose_db_version
+WHERE version_id = $1`,
+	lock: `
+SELECT pg_advisory_lock($1)`,
+	unlock: `
+SELECT pg_advisory_unlock($1)`,
+}
+
+// SQLite has no advisory locks. The store opens a single connection per
+// process, and a second process migrating the same file waits on SQLite's
+// own write lock, one migration at a time.
+var SQLite = Dialect{
+	createVersionTable: `
+CREATE TABLE IF NOT EXISTS goose_db_version (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    version_id INTEGER NOT NULL,
+    is_applied BOOLEAN NOT NULL,
+    tstamp TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP
+)`,
+	selectVersions: `
+SELECT version_id, is_applied, tstamp FROM goose_db_version
+ORDER BY id DESC`,
+	insertVersion: `
+INSERT INTO goose_db_version (version_id, is_applied, tstamp)
+VALUES (?, TRUE, ?)`,
+	deleteVersion: `
+DELETE FROM goose_db_version
+WHERE version_id = ?`,
+}
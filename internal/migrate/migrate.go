@@ -0,0 +1,366 @@
+package migrate
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	directiveUp     = "-- +goose Up"
+	directiveDown   = "-- +goose Down"
+	directiveNoTx   = "-- +goose NO TRANSACTION"
+	directivePrefix = "-- +goose"
+)
+
+var ErrNoMigrations = errors.New("no migrations found")
+var ErrNothingToRollback = errors.New("no applied migrations to roll back")
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+	NoTx    bool
+}
+
+type Status struct {
+	Migration
+	AppliedAt time.Time
+	Applied   bool
+}
+
+type Migrator struct {
+	db         *sql.DB
+	dialect    Dialect
+	migrations []Migration
+}
+
+func New(db *sql.DB, dialect Dialect, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		db:         db,
+		dialect:    dialect,
+		migrations: migrations,
+	}, nil
+}
+
+// Load reads every .sql file at the root of fsys and returns the migrations
+// sorted by version. File names must start with the version number, as in
+// 001_users.sql.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := []Migration{}
+	seen := map[int64]string{}
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, err := Parse(entry.Name(), string(content))
+		if err != nil {
+			return nil, err
+		}
+
+		if other, ok := seen[migration.Version]; ok {
+			return nil, fmt.Errorf("duplicate migration version %d: %s and %s", migration.Version, other, entry.Name())
+		}
+		seen[migration.Version] = entry.Name()
+
+		migrations = append(migrations, migration)
+	}
+
+	if len(migrations) == 0 {
+		return nil, ErrNoMigrations
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Parse splits a goose annotated file into its Up and Down sections.
+func Parse(name, content string) (Migration, error) {
+	base := strings.TrimSuffix(path.Base(name), path.Ext(name))
+	versionStr, _, _ := strings.Cut(base, "_")
+	version, err := strconv.ParseInt(versionStr, 10, 64)
+	if err != nil || version < 1 {
+		return Migration{}, fmt.Errorf("invalid migration version in %q", name)
+	}
+
+	migration := Migration{
+		Version: version,
+		Name:    base,
+	}
+
+	var up, down strings.Builder
+	var current *strings.Builder
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(trimmed, directiveUp):
+			current = &up
+			continue
+		case strings.HasPrefix(trimmed, directiveDown):
+			current = &down
+			continue
+		case strings.HasPrefix(trimmed, directiveNoTx):
+			migration.NoTx = true
+			continue
+		case strings.HasPrefix(trimmed, directivePrefix):
+			// StatementBegin/End only matter to goose's statement splitter,
+			// each section is sent to the database as a whole.
+			continue
+		}
+
+		if current == nil {
+			if trimmed != "" && !strings.HasPrefix(trimmed, "--") {
+				return Migration{}, fmt.Errorf("statement outside of an Up or Down section in %q", name)
+			}
+			continue
+		}
+
+		current.WriteString(line)
+		current.WriteString("\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return Migration{}, err
+	}
+
+	migration.Up = strings.TrimSpace(up.String())
+	migration.Down = strings.TrimSpace(down.String())
+	if migration.Up == "" {
+		return Migration{}, fmt.Errorf("missing %q section in %q", directiveUp, name)
+	}
+
+	return migration, nil
+}
+
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Up applies every pending migration in order. Servers starting together can
+// all call it: the others wait for the lock and then find nothing pending.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	done := []Migration{}
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		err := m.run(ctx, migration, migration.Up, func(tx execer) error {
+			_, err := tx.ExecContext(ctx, m.dialect.insertVersion, migration.Version, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("applying %s: %w", migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+// Down rolls back the most recently applied migration.
+func (m *Migrator) Down(ctx context.Context) (Migration, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return Migration{}, err
+	}
+	defer unlock()
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return Migration{}, err
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		err := m.run(ctx, migration, migration.Down, func(tx execer) error {
+			_, err := tx.ExecContext(ctx, m.dialect.deleteVersion, migration.Version)
+			return err
+		})
+		if err != nil {
+			return Migration{}, fmt.Errorf("rolling back %s: %w", migration.Name, err)
+		}
+
+		return migration, nil
+	}
+
+	return Migration{}, ErrNothingToRollback
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []Status{}
+	for _, migration := range m.migrations {
+		appliedAt, ok := applied[migration.Version]
+		statuses = append(statuses, Status{
+			Migration: migration,
+			AppliedAt: appliedAt,
+			Applied:   ok,
+		})
+	}
+
+	return statuses, nil
+}
+
+// Pending reports how many migrations have not been applied yet.
+func (m *Migrator) Pending(ctx context.Context) (int, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	pending := 0
+	for _, status := range statuses {
+		if !status.Applied {
+			pending++
+		}
+	}
+
+	return pending, nil
+}
+
+// lock blocks until no other migrator holds the lock and returns the func
+// that releases it. Postgres advisory locks belong to the session, so the
+// lock is held on a connection of its own while the migrations run on others.
+func (m *Migrator) lock(ctx context.Context) (unlock func(), err error) {
+	if m.dialect.lock == "" {
+		return func() {}, nil
+	}
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.ExecContext(ctx, m.dialect.lock, lockID); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("taking the migration lock: %w", err)
+	}
+
+	return func() {
+		// Closing the connection would release the lock too, but it only goes
+		// back to the pool, so it has to be unlocked explicitly.
+		conn.ExecContext(context.Background(), m.dialect.unlock, lockID)
+		conn.Close()
+	}, nil
+}
+
+type execer interface {
+	ExecContext(context.Context, string, ...any) (sql.Result, error)
+}
+
+func (m *Migrator) run(ctx context.Context, migration Migration, statements string, record func(execer) error) error {
+	if migration.NoTx {
+		if statements != "" {
+			if _, err := m.db.ExecContext(ctx, statements); err != nil {
+				return err
+			}
+		}
+		return record(m.db)
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if statements != "" {
+		if _, err := tx.ExecContext(ctx, statements); err != nil {
+			return err
+		}
+	}
+
+	if err := record(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int64]time.Time, error) {
+	if _, err := m.db.ExecContext(ctx, m.dialect.createVersionTable); err != nil {
+		return nil, fmt.Errorf("creating version table: %w", err)
+	}
+
+	rows, err := m.db.QueryContext(ctx, m.dialect.selectVersions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int64]time.Time{}
+	seen := map[int64]struct{}{}
+	for rows.Next() {
+		var version int64
+		var isApplied bool
+		var tstamp sql.NullTime
+		if err := rows.Scan(&version, &isApplied, &tstamp); err != nil {
+			return nil, err
+		}
+
+		// Only the newest row of a version counts.
+		if _, ok := seen[version]; ok {
+			continue
+		}
+		seen[version] = struct{}{}
+
+		if isApplied {
+			applied[version] = tstamp.Time
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return applied, nil
+}
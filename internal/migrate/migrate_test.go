@@ -0,0 +1,118 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileName string
+		content  string
+		wantUp   string
+		wantDown string
+		wantNoTx bool
+		wantErr  bool
+	}{
+		{
+			"Up and Down",
+			"001_users.sql",
+			"-- +goose Up\nCREATE TABLE users (id UUID);\n\n-- +goose Down\nDROP TABLE users;",
+			"CREATE TABLE users (id UUID);",
+			"DROP TABLE users;",
+			false,
+			false,
+		},
+		{
+			"Statement block",
+			"002_fn.sql",
+			"-- +goose Up\n-- +goose StatementBegin\nSELECT 1;\n-- +goose StatementEnd\n-- +goose Down\nSELECT 2;",
+			"SELECT 1;",
+			"SELECT 2;",
+			false,
+			false,
+		},
+		{
+			"No transaction",
+			"003_index.sql",
+			"-- +goose NO TRANSACTION\n-- +goose Up\nCREATE INDEX CONCURRENTLY idx ON t (c);",
+			"CREATE INDEX CONCURRENTLY idx ON t (c);",
+			"",
+			true,
+			false,
+		},
+		{
+			"Missing Up section",
+			"004_empty.sql",
+			"-- +goose Down\nDROP TABLE users;",
+			"",
+			"",
+			false,
+			true,
+		},
+		{
+			"Invalid version",
+			"users.sql",
+			"-- +goose Up\nSELECT 1;",
+			"",
+			"",
+			false,
+			true,
+		},
+		{
+			"Statement outside section",
+			"005_bad.sql",
+			"SELECT 1;\n-- +goose Up\nSELECT 2;",
+			"",
+			"",
+			false,
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			migration, err := Parse(test.fileName, test.content)
+			if (err != nil) != test.wantErr {
+				t.Errorf("Parse()\nerror = %v\nwantErr = %v", err, test.wantErr)
+				return
+			}
+
+			if migration.Up != test.wantUp {
+				t.Errorf("Parse()\nup = %q\nwantUp = %q", migration.Up, test.wantUp)
+			}
+			if migration.Down != test.wantDown {
+				t.Errorf("Parse()\ndown = %q\nwantDown = %q", migration.Down, test.wantDown)
+			}
+			if migration.NoTx != test.wantNoTx {
+				t.Errorf("Parse()\nnoTx = %v\nwantNoTx = %v", migration.NoTx, test.wantNoTx)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"002_chirps.sql": {Data: []byte("-- +goose Up\nCREATE TABLE chirps ();")},
+		"001_users.sql":  {Data: []byte("-- +goose Up\nCREATE TABLE users ();")},
+		"README.md":      {Data: []byte("not a migration")},
+	}
+
+	migrations, err := Load(fsys)
+	if err != nil {
+		t.Fatalf("Load()\nerror = %v", err)
+	}
+
+	if len(migrations) != 2 {
+		t.Fatalf("Load()\nlen = %d\nwantLen = 2", len(migrations))
+	}
+	if migrations[0].Version != 1 || migrations[1].Version != 2 {
+		t.Errorf("Load()\nversions = %d, %d\nwantVersions = 1, 2", migrations[0].Version, migrations[1].Version)
+	}
+
+	fsys["01_duplicate.sql"] = &fstest.MapFile{Data: []byte("-- +goose Up\nSELECT 1;")}
+	if _, err := Load(fsys); err == nil {
+		t.Errorf("Load()\nerror = nil\nwantErr = duplicate version")
+	}
+}
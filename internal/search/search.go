@@ -0,0 +1,203 @@
+package search
+
+import (
+	"errors"
+	"html"
+	"strings"
+	"unicode"
+)
+
+const (
+	maxQueryLength = 256
+	maxTerms       = 16
+
+	HighlightStart = "<mark>"
+	HighlightStop  = "</mark>"
+
+	// The databases wrap matches in these private use characters instead of
+	// the HTML tags, so Snippet can escape the text around them first.
+	MatchStart = "\uE000"
+	MatchStop  = "\uE001"
+)
+
+var ErrEmptyQuery = errors.New("search query has no terms")
+var ErrQueryTooLong = errors.New("search query is too long")
+var ErrUnbalancedQuotes = errors.New("search query has an unclosed quote")
+
+// Term is a single word, or a phrase when it has more than one word. A prefix
+// term matches any word starting with its last word.
+type Term struct {
+	Words  []string
+	Prefix bool
+}
+
+// Query is a search where every term has to match.
+//
+// The syntax is a small subset of what search engines accept: bare words,
+// "quoted phrases" and a trailing * for prefixes, as in `"hello world" chir*`.
+// Anything other than letters and digits is dropped, so the compiled queries
+// never contain operators the user didn't ask for.
+type Query struct {
+	Terms []Term
+}
+
+func Parse(q string) (Query, error) {
+	if len(q) > maxQueryLength {
+		return Query{}, ErrQueryTooLong
+	}
+	if strings.Count(q, `"`)%2 != 0 {
+		return Query{}, ErrUnbalancedQuotes
+	}
+
+	query := Query{}
+	for i, part := range strings.Split(q, `"`) {
+		// Odd parts were between quotes.
+		if i%2 == 1 {
+			term := Term{}
+			for _, field := range strings.Fields(part) {
+				term.Words = append(term.Words, words(field)...)
+			}
+			if len(term.Words) > 0 {
+				query.Terms = append(query.Terms, term)
+			}
+			continue
+		}
+
+		for _, field := range strings.Fields(part) {
+			fieldWords := words(field)
+			if len(fieldWords) == 0 {
+				continue
+			}
+			query.Terms = append(query.Terms, Term{
+				Words:  fieldWords,
+				Prefix: strings.HasSuffix(field, "*"),
+			})
+		}
+	}
+
+	if len(query.Terms) == 0 {
+		return Query{}, ErrEmptyQuery
+	}
+	if len(query.Terms) > maxTerms {
+		query.Terms = query.Terms[:maxTerms]
+	}
+
+	return query, nil
+}
+
+// words lowercases s and splits it on everything that isn't a letter or a
+// digit, so "don't" becomes a phrase of "don" and "t" like the full-text
+// parsers do.
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// TSQuery compiles the query for Postgres' to_tsquery.
+func (q Query) TSQuery() string {
+	terms := []string{}
+	for _, term := range q.Terms {
+		compiled := strings.Join(term.Words, " <-> ")
+		if term.Prefix {
+			compiled += ":*"
+		}
+		if len(term.Words) > 1 {
+			compiled = "(" + compiled + ")"
+		}
+		terms = append(terms, compiled)
+	}
+
+	return strings.Join(terms, " & ")
+}
+
+// FTS5 compiles the query for a SQLite FTS5 MATCH.
+func (q Query) FTS5() string {
+	terms := []string{}
+	for _, term := range q.Terms {
+		compiled := `"` + strings.Join(term.Words, " ") + `"`
+		if term.Prefix {
+			compiled += "*"
+		}
+		terms = append(terms, compiled)
+	}
+
+	return strings.Join(terms, " AND ")
+}
+
+// Match runs the query against text without an index. The rank is the share
+// of words in text that are part of a match, and the snippet is text with
+// those words highlighted.
+func (q Query) Match(text string) (rank float64, snippet string, ok bool) {
+	type token struct {
+		word       string
+		start, end int
+	}
+
+	tokens := []token{}
+	start := -1
+	for i, r := range text + " " {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		}
+		if !isWord && start >= 0 {
+			tokens = append(tokens, token{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+
+	highlighted := make([]bool, len(tokens))
+	for _, term := range q.Terms {
+		found := false
+		for i := 0; i+len(term.Words) <= len(tokens); i++ {
+			matches := true
+			for j, word := range term.Words {
+				last := j == len(term.Words)-1
+				if tokens[i+j].word == word || (last && term.Prefix && strings.HasPrefix(tokens[i+j].word, word)) {
+					continue
+				}
+				matches = false
+				break
+			}
+
+			if matches {
+				found = true
+				for j := range term.Words {
+					highlighted[i+j] = true
+				}
+			}
+		}
+
+		if !found {
+			return 0, "", false
+		}
+	}
+
+	var b strings.Builder
+	prev, count := 0, 0
+	for i, tok := range tokens {
+		if !highlighted[i] {
+			continue
+		}
+		count++
+		b.WriteString(html.EscapeString(text[prev:tok.start]))
+		b.WriteString(HighlightStart)
+		b.WriteString(html.EscapeString(text[tok.start:tok.end]))
+		b.WriteString(HighlightStop)
+		prev = tok.end
+	}
+	b.WriteString(html.EscapeString(text[prev:]))
+
+	return float64(count) / float64(len(tokens)), b.String(), true
+}
+
+var matchReplacer = strings.NewReplacer(MatchStart, HighlightStart, MatchStop, HighlightStop)
+
+// Snippet turns text with its matches between MatchStart and MatchStop into
+// HTML: the text is escaped, so markup in a chirp comes back as text, and the
+// matches are wrapped in <mark>, the same HTML Match makes. A chirp that
+// contains the private use characters itself can only add stray <mark> tags.
+func Snippet(text string) string {
+	return matchReplacer.Replace(html.EscapeString(text))
+}
@@ -0,0 +1,171 @@
+package search
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name        string
+		q           string
+		wantTSQuery string
+		wantFTS5    string
+		wantErr     bool
+	}{
+		{
+			"Single word",
+			"Hello",
+			"hello",
+			`"hello"`,
+			false,
+		},
+		{
+			"Words and prefix",
+			"hello wor*",
+			"hello & wor:*",
+			`"hello" AND "wor"*`,
+			false,
+		},
+		{
+			"Phrase",
+			`"hello world" chirpy`,
+			"(hello <-> world) & chirpy",
+			`"hello world" AND "chirpy"`,
+			false,
+		},
+		{
+			"Operators are dropped",
+			"a&b | !c:*",
+			"(a <-> b) & c:*",
+			`"a b" AND "c"*`,
+			false,
+		},
+		{
+			"Empty query",
+			"  ",
+			"",
+			"",
+			true,
+		},
+		{
+			"Only punctuation",
+			"&& ||",
+			"",
+			"",
+			true,
+		},
+		{
+			"Unclosed quote",
+			`"hello world`,
+			"",
+			"",
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := Parse(test.q)
+			if (err != nil) != test.wantErr {
+				t.Errorf("Parse()\nerror = %v\nwantErr = %v", err, test.wantErr)
+				return
+			}
+			if test.wantErr {
+				return
+			}
+
+			if tsquery := query.TSQuery(); tsquery != test.wantTSQuery {
+				t.Errorf("TSQuery()\ntsquery = %q\nwantTSQuery = %q", tsquery, test.wantTSQuery)
+			}
+			if fts5 := query.FTS5(); fts5 != test.wantFTS5 {
+				t.Errorf("FTS5()\nfts5 = %q\nwantFTS5 = %q", fts5, test.wantFTS5)
+			}
+		})
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"Match", "hello " + MatchStart + "world" + MatchStop, "hello <mark>world</mark>"},
+		{"Markup", "<b>" + MatchStart + "bold" + MatchStop + "</b> & more", "&lt;b&gt;<mark>bold</mark>&lt;/b&gt; &amp; more"},
+		{"No match", "plain", "plain"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Snippet(test.text); got != test.want {
+				t.Errorf("Snippet()\nsnippet = %q\nwantSnippet = %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		q           string
+		text        string
+		wantSnippet string
+		wantOk      bool
+	}{
+		{
+			"Word",
+			"world",
+			"Hello, World!",
+			"Hello, <mark>World</mark>!",
+			true,
+		},
+		{
+			"Prefix",
+			"chir*",
+			"I love chirping",
+			"I love <mark>chirping</mark>",
+			true,
+		},
+		{
+			"Phrase",
+			`"hello world"`,
+			"hello world, hello there",
+			"<mark>hello</mark> <mark>world</mark>, hello there",
+			true,
+		},
+		{
+			"Markup",
+			"world",
+			"<script>alert('world')</script>",
+			"&lt;script&gt;alert(&#39;<mark>world</mark>&#39;)&lt;/script&gt;",
+			true,
+		},
+		{
+			"Phrase out of order",
+			`"world hello"`,
+			"hello world",
+			"",
+			false,
+		},
+		{
+			"Missing term",
+			"hello moon",
+			"hello world",
+			"",
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, _ := Parse(test.q)
+			_, snippet, ok := query.Match(test.text)
+			if ok != test.wantOk {
+				t.Errorf("Match()\nok = %v\nwantOk = %v", ok, test.wantOk)
+				return
+			}
+
+			if snippet != test.wantSnippet {
+				t.Errorf("Match()\nsnippet = %q\nwantSnippet = %q", snippet, test.wantSnippet)
+			}
+		})
+	}
+}
@@ -0,0 +1,1184 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fernando8franco/http-server-golang/internal/database"
+	"github.com/fernando8franco/http-server-golang/internal/search"
+	"github.com/google/uuid"
+)
+
+const refreshTokenDuration = 60 * 24 * time.Hour
+
+// Memory is a database.Querier that keeps everything in process memory. It
+// follows the same constraints as the Postgres schema: unique emails and
+// handles, foreign keys that cascade on delete and refresh tokens that expire.
+type Memory struct {
+	mu             sync.RWMutex
+	users          map[uuid.UUID]database.User
+	chirps         map[uuid.UUID]database.Chirp
+	chirpRevisions map[uuid.UUID][]database.ChirpRevision
+	refreshTokens  map[string]database.RefreshToken
+	deniedTokens   map[string]time.Time
+	accessTokens   map[uuid.UUID]database.PersonalAccessToken
+	recoveryCodes  map[uuid.UUID]database.RecoveryCode
+	passwordResets map[string]database.PasswordResetToken
+	challenges     map[string]database.TwoFactorChallenge
+}
+
+var _ database.Querier = (*Memory)(nil)
+
+func NewMemory() *Memory {
+	return &Memory{
+		users:          map[uuid.UUID]database.User{},
+		chirps:         map[uuid.UUID]database.Chirp{},
+		chirpRevisions: map[uuid.UUID][]database.ChirpRevision{},
+		refreshTokens:  map[string]database.RefreshToken{},
+		deniedTokens:   map[string]time.Time{},
+		accessTokens:   map[uuid.UUID]database.PersonalAccessToken{},
+		recoveryCodes:  map[uuid.UUID]database.RecoveryCode{},
+		passwordResets: map[string]database.PasswordResetToken{},
+		challenges:     map[string]database.TwoFactorChallenge{},
+	}
+}
+
+func now() time.Time {
+	return time.Now().UTC()
+}
+
+// seconds turns the lengths of time the queries take in seconds, so Postgres
+// can do the arithmetic on its own clock, back into a time.Duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func (m *Memory) CreateUser(ctx context.Context, arg database.CreateUserParams) (database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.emailTaken(arg.Email, uuid.Nil) {
+		return database.User{}, ErrUniqueViolation
+	}
+
+	t := now()
+	user := database.User{
+		ID:             uuid.New(),
+		CreatedAt:      t,
+		UpdatedAt:      t,
+		Email:          arg.Email,
+		HashedPassword: arg.HashedPassword,
+	}
+	m.users[user.ID] = user
+
+	return user, nil
+}
+
+func (m *Memory) DeleteUsers(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clear(m.users)
+	clear(m.chirps)
+	clear(m.chirpRevisions)
+	clear(m.refreshTokens)
+	clear(m.accessTokens)
+	clear(m.recoveryCodes)
+	clear(m.passwordResets)
+	clear(m.challenges)
+
+	return nil
+}
+
+func (m *Memory) GetUserByEmail(ctx context.Context, email string) (database.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, user := range m.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+
+	return database.User{}, sql.ErrNoRows
+}
+
+func (m *Memory) GetUserByHandle(ctx context.Context, lower string) (database.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, user := range m.users {
+		if user.Handle.Valid && strings.EqualFold(user.Handle.String, lower) {
+			return user, nil
+		}
+	}
+
+	return database.User{}, sql.ErrNoRows
+}
+
+func (m *Memory) GetUserById(ctx context.Context, id uuid.UUID) (database.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return database.User{}, sql.ErrNoRows
+	}
+
+	return user, nil
+}
+
+func (m *Memory) ListChirpAuthors(ctx context.Context, ids []uuid.UUID) ([]database.ListChirpAuthorsRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []database.ListChirpAuthorsRow
+	for _, id := range ids {
+		user, ok := m.users[id]
+		if !ok || slices.ContainsFunc(items, func(i database.ListChirpAuthorsRow) bool { return i.ID == id }) {
+			continue
+		}
+		items = append(items, database.ListChirpAuthorsRow{
+			ID:          user.ID,
+			Handle:      user.Handle,
+			DisplayName: user.DisplayName,
+			AvatarUrl:   user.AvatarUrl,
+		})
+	}
+
+	return items, nil
+}
+
+func (m *Memory) SetUserAdmin(ctx context.Context, arg database.SetUserAdminParams) (database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, user := range m.users {
+		if user.Email != arg.Email {
+			continue
+		}
+
+		user.UpdatedAt = now()
+		user.IsAdmin = arg.IsAdmin
+		m.users[user.ID] = user
+
+		return user, nil
+	}
+
+	return database.User{}, sql.ErrNoRows
+}
+
+func (m *Memory) SetUserSuspended(ctx context.Context, arg database.SetUserSuspendedParams) (database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, user := range m.users {
+		if user.Email != arg.Email {
+			continue
+		}
+
+		t := now()
+		user.UpdatedAt = t
+		user.SuspendedAt = sql.NullTime{}
+		if arg.Suspended {
+			user.SuspendedAt = sql.NullTime{Time: t, Valid: true}
+		}
+		m.users[user.ID] = user
+
+		return user, nil
+	}
+
+	return database.User{}, sql.ErrNoRows
+}
+
+func (m *Memory) SetUserTOTPSecret(ctx context.Context, arg database.SetUserTOTPSecretParams) (database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[arg.ID]
+	if !ok {
+		return database.User{}, sql.ErrNoRows
+	}
+
+	user.UpdatedAt = now()
+	user.TotpSecret = arg.TotpSecret
+	user.TotpEnabledAt = sql.NullTime{}
+	user.TotpLastStep = sql.NullInt64{}
+	m.users[user.ID] = user
+
+	return user, nil
+}
+
+func (m *Memory) EnableUserTOTP(ctx context.Context, id uuid.UUID) (database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok || !user.TotpSecret.Valid {
+		return database.User{}, sql.ErrNoRows
+	}
+
+	t := now()
+	user.UpdatedAt = t
+	user.TotpEnabledAt = sql.NullTime{Time: t, Valid: true}
+	m.users[user.ID] = user
+
+	return user, nil
+}
+
+func (m *Memory) UseTOTPStep(ctx context.Context, arg database.UseTOTPStepParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[arg.ID]
+	if !ok || (user.TotpLastStep.Valid && user.TotpLastStep.Int64 >= arg.Step) {
+		return 0, nil
+	}
+
+	user.TotpLastStep = sql.NullInt64{Int64: arg.Step, Valid: true}
+	m.users[user.ID] = user
+
+	return 1, nil
+}
+
+func (m *Memory) PatchUser(ctx context.Context, arg database.PatchUserParams) (database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[arg.ID]
+	if !ok {
+		return database.User{}, sql.ErrNoRows
+	}
+
+	if arg.Email.Valid && m.emailTaken(arg.Email.String, arg.ID) {
+		return database.User{}, ErrUniqueViolation
+	}
+	if arg.Handle.Valid && m.handleTaken(arg.Handle.String, arg.ID) {
+		return database.User{}, ErrUniqueViolation
+	}
+
+	if arg.Email.Valid && user.Email != arg.Email.String {
+		user.Email = arg.Email.String
+		user.EmailVerifiedAt = sql.NullTime{}
+	}
+	if arg.HashedPassword.Valid {
+		user.HashedPassword = arg.HashedPassword.String
+	}
+	user.UpdatedAt = now()
+	user.Handle = arg.Handle
+	user.DisplayName = arg.DisplayName
+	user.Bio = arg.Bio
+	user.AvatarUrl = arg.AvatarUrl
+	m.users[user.ID] = user
+
+	return user, nil
+}
+
+func (m *Memory) UpdateUser(ctx context.Context, arg database.UpdateUserParams) (database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[arg.ID]
+	if !ok {
+		return database.User{}, sql.ErrNoRows
+	}
+
+	if m.emailTaken(arg.Email, arg.ID) {
+		return database.User{}, ErrUniqueViolation
+	}
+
+	if user.Email != arg.Email {
+		user.EmailVerifiedAt = sql.NullTime{}
+	}
+	user.UpdatedAt = now()
+	user.Email = arg.Email
+	user.HashedPassword = arg.HashedPassword
+	m.users[user.ID] = user
+
+	return user, nil
+}
+
+func (m *Memory) UpdateUserPassword(ctx context.Context, arg database.UpdateUserPasswordParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[arg.ID]
+	if !ok {
+		return nil
+	}
+
+	user.UpdatedAt = now()
+	user.HashedPassword = arg.HashedPassword
+	m.users[user.ID] = user
+
+	return nil
+}
+
+func (m *Memory) RehashUserPassword(ctx context.Context, arg database.RehashUserPasswordParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[arg.ID]
+	if !ok || user.HashedPassword != arg.OldHashedPassword {
+		return nil
+	}
+
+	user.HashedPassword = arg.HashedPassword
+	m.users[user.ID] = user
+
+	return nil
+}
+
+func (m *Memory) VerifyUserEmail(ctx context.Context, arg database.VerifyUserEmailParams) (database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[arg.ID]
+	if !ok || user.Email != arg.Email {
+		return database.User{}, sql.ErrNoRows
+	}
+
+	t := now()
+	user.UpdatedAt = t
+	if !user.EmailVerifiedAt.Valid {
+		user.EmailVerifiedAt = sql.NullTime{Time: t, Valid: true}
+	}
+	m.users[user.ID] = user
+
+	return user, nil
+}
+
+func (m *Memory) UpdateUserToChirpyRed(ctx context.Context, id uuid.UUID) (database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return database.User{}, sql.ErrNoRows
+	}
+
+	user.UpdatedAt = now()
+	user.IsChirpyRed = true
+	m.users[user.ID] = user
+
+	return user, nil
+}
+
+func (m *Memory) CreateChirp(ctx context.Context, arg database.CreateChirpParams) (database.Chirp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[arg.UserID]; !ok {
+		return database.Chirp{}, ErrForeignKeyViolation
+	}
+
+	t := now()
+	chirp := database.Chirp{
+		ID:        uuid.New(),
+		CreatedAt: t,
+		UpdatedAt: t,
+		Body:      arg.Body,
+		UserID:    arg.UserID,
+	}
+	m.chirps[chirp.ID] = chirp
+
+	return chirp, nil
+}
+
+func (m *Memory) DeleteChirp(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clear(m.chirps)
+	clear(m.chirpRevisions)
+
+	return nil
+}
+
+func (m *Memory) SoftDeleteChirpById(ctx context.Context, id uuid.UUID) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chirp, ok := m.chirps[id]
+	if !ok || chirp.DeletedAt.Valid {
+		return 0, nil
+	}
+
+	t := now()
+	chirp.DeletedAt = sql.NullTime{Time: t, Valid: true}
+	chirp.UpdatedAt = t
+	m.chirps[id] = chirp
+
+	return 1, nil
+}
+
+func (m *Memory) RestoreChirp(ctx context.Context, arg database.RestoreChirpParams) (database.Chirp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := now()
+	chirp, ok := m.chirps[arg.ID]
+	if !ok || !chirp.DeletedAt.Valid || !chirp.DeletedAt.Time.After(t.Add(-seconds(arg.WindowSeconds))) {
+		return database.Chirp{}, sql.ErrNoRows
+	}
+
+	chirp.DeletedAt = sql.NullTime{}
+	chirp.UpdatedAt = t
+	m.chirps[arg.ID] = chirp
+
+	return chirp, nil
+}
+
+func (m *Memory) PurgeDeletedChirps(ctx context.Context, retentionSeconds float64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cutoff := now().Add(-seconds(retentionSeconds))
+	var purged int64
+	for id, chirp := range m.chirps {
+		if chirp.DeletedAt.Valid && chirp.DeletedAt.Time.Before(cutoff) {
+			delete(m.chirps, id)
+			delete(m.chirpRevisions, id)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+func (m *Memory) UpdateChirpBody(ctx context.Context, arg database.UpdateChirpBodyParams) (database.Chirp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chirp, ok := m.chirps[arg.ID]
+	if !ok || chirp.DeletedAt.Valid {
+		return database.Chirp{}, sql.ErrNoRows
+	}
+
+	t := now()
+	m.chirpRevisions[chirp.ID] = append(m.chirpRevisions[chirp.ID], database.ChirpRevision{
+		ID:        uuid.New(),
+		ChirpID:   chirp.ID,
+		Body:      chirp.Body,
+		CreatedAt: t,
+	})
+
+	chirp.Body = arg.Body
+	chirp.UpdatedAt = t
+	chirp.EditedAt = sql.NullTime{Time: t, Valid: true}
+	m.chirps[chirp.ID] = chirp
+
+	return chirp, nil
+}
+
+func (m *Memory) ListChirpRevisions(ctx context.Context, chirpID uuid.UUID) ([]database.ChirpRevision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	revisions := slices.Clone(m.chirpRevisions[chirpID])
+	slices.Reverse(revisions)
+
+	return revisions, nil
+}
+
+func (m *Memory) ListChirps(ctx context.Context, arg database.ListChirpsParams) ([]database.Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.listChirps(arg, false), nil
+}
+
+func (m *Memory) ListChirpsDesc(ctx context.Context, arg database.ListChirpsDescParams) ([]database.Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.listChirps(database.ListChirpsParams(arg), true), nil
+}
+
+func (m *Memory) SearchChirps(ctx context.Context, arg database.SearchChirpsParams) ([]database.SearchChirpsRow, error) {
+	query, err := search.Parse(arg.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rows := []database.SearchChirpsRow{}
+	for _, chirp := range m.listChirps(database.ListChirpsParams{
+		AuthorID: arg.AuthorID,
+		Since:    arg.Since,
+		Until:    arg.Until,
+		PageSize: int32(len(m.chirps)),
+	}, true) {
+		rank, snippet, ok := query.Match(chirp.Body)
+		if !ok {
+			continue
+		}
+		rows = append(rows, database.SearchChirpsRow{
+			ID:        chirp.ID,
+			CreatedAt: chirp.CreatedAt,
+			UpdatedAt: chirp.UpdatedAt,
+			Body:      chirp.Body,
+			UserID:    chirp.UserID,
+			EditedAt:  chirp.EditedAt,
+			Rank:      rank,
+			Snippet:   snippet,
+		})
+	}
+
+	slices.SortStableFunc(rows, func(a, b database.SearchChirpsRow) int {
+		return cmp.Compare(b.Rank, a.Rank)
+	})
+
+	if arg.AfterRank.Valid {
+		rows = slices.DeleteFunc(rows, func(row database.SearchChirpsRow) bool {
+			if row.Rank != arg.AfterRank.Float64 {
+				return row.Rank > arg.AfterRank.Float64
+			}
+			chirp := database.Chirp{ID: row.ID, CreatedAt: row.CreatedAt}
+			return compareChirpKey(chirp, arg.AfterCreatedAt.Time, arg.AfterID.UUID) >= 0
+		})
+	}
+
+	return rows[:min(len(rows), int(arg.PageSize))], nil
+}
+
+func (m *Memory) GetOneChirp(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	chirp, ok := m.chirps[id]
+	if !ok || chirp.DeletedAt.Valid {
+		return database.Chirp{}, sql.ErrNoRows
+	}
+
+	return chirp, nil
+}
+
+func (m *Memory) GetChirpIncludingDeleted(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	chirp, ok := m.chirps[id]
+	if !ok {
+		return database.Chirp{}, sql.ErrNoRows
+	}
+
+	return chirp, nil
+}
+
+func (m *Memory) DenyFamilyAccessTokens(ctx context.Context, arg database.DenyFamilyAccessTokensParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.denyAccessTokens(arg.ExpiresInSeconds, arg.IssuedWithinSeconds, func(refreshToken database.RefreshToken) bool {
+		return refreshToken.FamilyID == arg.FamilyID
+	}), nil
+}
+
+func (m *Memory) DenyUserAccessTokens(ctx context.Context, arg database.DenyUserAccessTokensParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.denyAccessTokens(arg.ExpiresInSeconds, arg.IssuedWithinSeconds, func(refreshToken database.RefreshToken) bool {
+		return refreshToken.UserID == arg.UserID && refreshToken.AccessTokenJti.String != arg.ExceptJti
+	}), nil
+}
+
+func (m *Memory) IsAccessTokenDenied(ctx context.Context, jti string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	expiresAt, ok := m.deniedTokens[jti]
+	return ok && expiresAt.After(now()), nil
+}
+
+func (m *Memory) PurgeDeniedAccessTokens(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := now()
+	var purged int64
+	for jti, expiresAt := range m.deniedTokens {
+		if !expiresAt.After(t) {
+			delete(m.deniedTokens, jti)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+func (m *Memory) CreateRefreshToken(ctx context.Context, arg database.CreateRefreshTokenParams) (database.RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.refreshTokens[arg.TokenHash]; ok {
+		return database.RefreshToken{}, ErrUniqueViolation
+	}
+	if _, ok := m.users[arg.UserID]; !ok {
+		return database.RefreshToken{}, ErrForeignKeyViolation
+	}
+
+	t := now()
+	refreshToken := database.RefreshToken{
+		TokenHash:        arg.TokenHash,
+		CreatedAt:        t,
+		UpdatedAt:        t,
+		UserID:           arg.UserID,
+		ExpiresAt:        t.Add(refreshTokenDuration),
+		FamilyID:         arg.FamilyID,
+		UserAgent:        arg.UserAgent,
+		IpAddress:        arg.IpAddress,
+		SessionStartedAt: t,
+		AccessTokenJti:   arg.AccessTokenJti,
+	}
+	m.refreshTokens[refreshToken.TokenHash] = refreshToken
+
+	return refreshToken, nil
+}
+
+func (m *Memory) GetRefreshToken(ctx context.Context, tokenHash string) (database.RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	refreshToken, ok := m.refreshTokens[tokenHash]
+	if !ok {
+		return database.RefreshToken{}, sql.ErrNoRows
+	}
+
+	return refreshToken, nil
+}
+
+func (m *Memory) GetUserIdFromRefreshToken(ctx context.Context, tokenHash string) (uuid.UUID, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	refreshToken, ok := m.refreshTokens[tokenHash]
+	if !ok || refreshToken.RevokedAt.Valid || !refreshToken.ExpiresAt.After(now()) {
+		return uuid.Nil, sql.ErrNoRows
+	}
+
+	return refreshToken.UserID, nil
+}
+
+func (m *Memory) ListSessions(ctx context.Context, userID uuid.UUID) ([]database.RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t := now()
+	var sessions []database.RefreshToken
+	for _, refreshToken := range m.refreshTokens {
+		if refreshToken.UserID != userID || refreshToken.RevokedAt.Valid || !refreshToken.ExpiresAt.After(t) {
+			continue
+		}
+		sessions = append(sessions, refreshToken)
+	}
+
+	slices.SortFunc(sessions, func(a, b database.RefreshToken) int {
+		if c := b.SessionStartedAt.Compare(a.SessionStartedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.FamilyID.String(), b.FamilyID.String())
+	})
+
+	return sessions, nil
+}
+
+func (m *Memory) RevokeAllSessions(ctx context.Context, userID uuid.UUID) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.revokeRefreshTokens(func(refreshToken database.RefreshToken) bool {
+		return refreshToken.UserID == userID
+	}), nil
+}
+
+func (m *Memory) RevokeOtherSessions(ctx context.Context, arg database.RevokeOtherSessionsParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := map[uuid.UUID]bool{}
+	for _, refreshToken := range m.refreshTokens {
+		if refreshToken.AccessTokenJti.Valid && refreshToken.AccessTokenJti.String == arg.AccessTokenJti {
+			current[refreshToken.FamilyID] = true
+		}
+	}
+
+	return m.revokeRefreshTokens(func(refreshToken database.RefreshToken) bool {
+		return refreshToken.UserID == arg.UserID && !current[refreshToken.FamilyID]
+	}), nil
+}
+
+func (m *Memory) RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.revokeRefreshTokens(func(refreshToken database.RefreshToken) bool {
+		return refreshToken.FamilyID == familyID
+	}), nil
+}
+
+func (m *Memory) RevokeSession(ctx context.Context, arg database.RevokeSessionParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.revokeRefreshTokens(func(refreshToken database.RefreshToken) bool {
+		return refreshToken.FamilyID == arg.FamilyID && refreshToken.UserID == arg.UserID
+	}), nil
+}
+
+func (m *Memory) RotateRefreshToken(ctx context.Context, arg database.RotateRefreshTokenParams) (database.RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := now()
+	previous, ok := m.refreshTokens[arg.TokenHash]
+	if !ok || previous.RevokedAt.Valid || !previous.ExpiresAt.After(t) {
+		return database.RefreshToken{}, sql.ErrNoRows
+	}
+	if _, ok := m.refreshTokens[arg.NewTokenHash]; ok {
+		return database.RefreshToken{}, ErrUniqueViolation
+	}
+
+	previous.RevokedAt = sql.NullTime{Time: t, Valid: true}
+	previous.UpdatedAt = t
+	m.refreshTokens[arg.TokenHash] = previous
+
+	refreshToken := database.RefreshToken{
+		TokenHash:        arg.NewTokenHash,
+		CreatedAt:        t,
+		UpdatedAt:        t,
+		UserID:           previous.UserID,
+		ExpiresAt:        t.Add(refreshTokenDuration),
+		FamilyID:         previous.FamilyID,
+		UserAgent:        previous.UserAgent,
+		IpAddress:        previous.IpAddress,
+		SessionStartedAt: previous.SessionStartedAt,
+		LastUsedAt:       sql.NullTime{Time: t, Valid: true},
+		AccessTokenJti:   arg.AccessTokenJti,
+	}
+	m.refreshTokens[refreshToken.TokenHash] = refreshToken
+
+	return refreshToken, nil
+}
+
+func (m *Memory) SetRevokedAt(ctx context.Context, tokenHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	refreshToken, ok := m.refreshTokens[tokenHash]
+	if !ok {
+		return nil
+	}
+
+	t := now()
+	refreshToken.RevokedAt = sql.NullTime{Time: t, Valid: true}
+	refreshToken.UpdatedAt = t
+	m.refreshTokens[tokenHash] = refreshToken
+
+	return nil
+}
+
+func (m *Memory) CreatePersonalAccessToken(ctx context.Context, arg database.CreatePersonalAccessTokenParams) (database.PersonalAccessToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, accessToken := range m.accessTokens {
+		if accessToken.TokenHash == arg.TokenHash {
+			return database.PersonalAccessToken{}, ErrUniqueViolation
+		}
+	}
+	if _, ok := m.users[arg.UserID]; !ok {
+		return database.PersonalAccessToken{}, ErrForeignKeyViolation
+	}
+
+	accessToken := database.PersonalAccessToken{
+		ID:        uuid.New(),
+		UserID:    arg.UserID,
+		Name:      arg.Name,
+		TokenHash: arg.TokenHash,
+		Scopes:    arg.Scopes,
+		CreatedAt: now(),
+		ExpiresAt: arg.ExpiresAt,
+	}
+	m.accessTokens[accessToken.ID] = accessToken
+
+	return accessToken, nil
+}
+
+func (m *Memory) DeletePersonalAccessToken(ctx context.Context, arg database.DeletePersonalAccessTokenParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	accessToken, ok := m.accessTokens[arg.ID]
+	if !ok || accessToken.UserID != arg.UserID {
+		return 0, nil
+	}
+	delete(m.accessTokens, arg.ID)
+
+	return 1, nil
+}
+
+func (m *Memory) ListPersonalAccessTokens(ctx context.Context, userID uuid.UUID) ([]database.PersonalAccessToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var accessTokens []database.PersonalAccessToken
+	for _, accessToken := range m.accessTokens {
+		if accessToken.UserID == userID {
+			accessTokens = append(accessTokens, accessToken)
+		}
+	}
+
+	slices.SortFunc(accessTokens, func(a, b database.PersonalAccessToken) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID.String(), b.ID.String())
+	})
+
+	return accessTokens, nil
+}
+
+func (m *Memory) UsePersonalAccessToken(ctx context.Context, arg database.UsePersonalAccessTokenParams) (database.PersonalAccessToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, accessToken := range m.accessTokens {
+		if accessToken.TokenHash != arg.TokenHash {
+			continue
+		}
+		if accessToken.ExpiresAt.Valid && !accessToken.ExpiresAt.Time.After(arg.Now) {
+			break
+		}
+
+		accessToken.LastUsedAt = sql.NullTime{Time: arg.Now, Valid: true}
+		m.accessTokens[id] = accessToken
+		return accessToken, nil
+	}
+
+	return database.PersonalAccessToken{}, sql.ErrNoRows
+}
+
+func (m *Memory) CreateRecoveryCode(ctx context.Context, arg database.CreateRecoveryCodeParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[arg.UserID]; !ok {
+		return ErrForeignKeyViolation
+	}
+
+	recoveryCode := database.RecoveryCode{
+		ID:        uuid.New(),
+		UserID:    arg.UserID,
+		CodeHash:  arg.CodeHash,
+		CreatedAt: now(),
+	}
+	m.recoveryCodes[recoveryCode.ID] = recoveryCode
+
+	return nil
+}
+
+func (m *Memory) DeleteRecoveryCodes(ctx context.Context, userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, recoveryCode := range m.recoveryCodes {
+		if recoveryCode.UserID == userID {
+			delete(m.recoveryCodes, id)
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) UseRecoveryCode(ctx context.Context, arg database.UseRecoveryCodeParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var used int64
+	for id, recoveryCode := range m.recoveryCodes {
+		if recoveryCode.UserID != arg.UserID || recoveryCode.CodeHash != arg.CodeHash || recoveryCode.UsedAt.Valid {
+			continue
+		}
+		recoveryCode.UsedAt = sql.NullTime{Time: now(), Valid: true}
+		m.recoveryCodes[id] = recoveryCode
+		used++
+	}
+
+	return used, nil
+}
+
+func (m *Memory) CreatePasswordResetToken(ctx context.Context, arg database.CreatePasswordResetTokenParams) (database.PasswordResetToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.passwordResets[arg.TokenHash]; ok {
+		return database.PasswordResetToken{}, ErrUniqueViolation
+	}
+	if _, ok := m.users[arg.UserID]; !ok {
+		return database.PasswordResetToken{}, ErrForeignKeyViolation
+	}
+
+	t := now()
+	since := t.Add(-seconds(arg.WindowSeconds))
+	var count int32
+	for _, passwordReset := range m.passwordResets {
+		if passwordReset.UserID == arg.UserID && passwordReset.CreatedAt.After(since) {
+			count++
+		}
+	}
+	if count >= arg.MaxTokens {
+		return database.PasswordResetToken{}, sql.ErrNoRows
+	}
+
+	passwordReset := database.PasswordResetToken{
+		TokenHash: arg.TokenHash,
+		UserID:    arg.UserID,
+		CreatedAt: t,
+		ExpiresAt: t.Add(seconds(arg.ExpiresInSeconds)),
+	}
+	m.passwordResets[passwordReset.TokenHash] = passwordReset
+
+	return passwordReset, nil
+}
+
+func (m *Memory) DeletePasswordResetTokens(ctx context.Context, userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for tokenHash, passwordReset := range m.passwordResets {
+		if passwordReset.UserID == userID {
+			delete(m.passwordResets, tokenHash)
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) GetPasswordResetTokenEmail(ctx context.Context, tokenHash string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	passwordReset, ok := m.passwordResets[tokenHash]
+	if !ok || passwordReset.UsedAt.Valid || !passwordReset.ExpiresAt.After(now()) {
+		return "", sql.ErrNoRows
+	}
+
+	user, ok := m.users[passwordReset.UserID]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+
+	return user.Email, nil
+}
+
+func (m *Memory) UsePasswordResetToken(ctx context.Context, tokenHash string) (uuid.UUID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := now()
+	passwordReset, ok := m.passwordResets[tokenHash]
+	if !ok || passwordReset.UsedAt.Valid || !passwordReset.ExpiresAt.After(t) {
+		return uuid.Nil, sql.ErrNoRows
+	}
+
+	passwordReset.UsedAt = sql.NullTime{Time: t, Valid: true}
+	m.passwordResets[tokenHash] = passwordReset
+
+	return passwordReset.UserID, nil
+}
+
+func (m *Memory) CreateTwoFactorChallenge(ctx context.Context, arg database.CreateTwoFactorChallengeParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.challenges[arg.ID]; ok {
+		return ErrUniqueViolation
+	}
+	if _, ok := m.users[arg.UserID]; !ok {
+		return ErrForeignKeyViolation
+	}
+
+	m.challenges[arg.ID] = database.TwoFactorChallenge{
+		ID:        arg.ID,
+		UserID:    arg.UserID,
+		ExpiresAt: now().Add(seconds(arg.ExpiresInSeconds)),
+	}
+
+	return nil
+}
+
+func (m *Memory) AttemptTwoFactorChallenge(ctx context.Context, arg database.AttemptTwoFactorChallengeParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	challenge, ok := m.challenges[arg.ID]
+	if !ok || challenge.UserID != arg.UserID || challenge.Attempts >= arg.MaxAttempts || !challenge.ExpiresAt.After(now()) {
+		return 0, nil
+	}
+
+	challenge.Attempts++
+	m.challenges[arg.ID] = challenge
+
+	return 1, nil
+}
+
+func (m *Memory) DeleteTwoFactorChallenge(ctx context.Context, id string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.challenges[id]; !ok {
+		return 0, nil
+	}
+	delete(m.challenges, id)
+
+	return 1, nil
+}
+
+func (m *Memory) PurgeTwoFactorChallenges(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := now()
+	var purged int64
+	for id, challenge := range m.challenges {
+		if !challenge.ExpiresAt.After(t) {
+			delete(m.challenges, id)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// revokeRefreshTokens revokes the active tokens that match and returns how
+// many there were. It must be called with the lock held.
+func (m *Memory) revokeRefreshTokens(match func(database.RefreshToken) bool) int64 {
+	t := now()
+	var revoked int64
+	for tokenHash, refreshToken := range m.refreshTokens {
+		if refreshToken.RevokedAt.Valid || !match(refreshToken) {
+			continue
+		}
+		refreshToken.RevokedAt = sql.NullTime{Time: t, Valid: true}
+		refreshToken.UpdatedAt = t
+		m.refreshTokens[tokenHash] = refreshToken
+		revoked++
+	}
+	return revoked
+}
+
+// denyAccessTokens adds the access tokens issued with the matching refresh
+// tokens after issuedAfter to the denylist. It must be called with the lock
+// held.
+func (m *Memory) denyAccessTokens(expiresInSeconds, issuedWithinSeconds float64, match func(database.RefreshToken) bool) int64 {
+	t := now()
+	expiresAt := t.Add(seconds(expiresInSeconds))
+	issuedAfter := t.Add(-seconds(issuedWithinSeconds))
+
+	var denied int64
+	for _, refreshToken := range m.refreshTokens {
+		jti := refreshToken.AccessTokenJti
+		if !jti.Valid || !refreshToken.CreatedAt.After(issuedAfter) || !match(refreshToken) {
+			continue
+		}
+		if _, ok := m.deniedTokens[jti.String]; ok {
+			continue
+		}
+		m.deniedTokens[jti.String] = expiresAt
+		denied++
+	}
+	return denied
+}
+
+// emailTaken must be called with the lock held.
+func (m *Memory) emailTaken(email string, except uuid.UUID) bool {
+	for _, user := range m.users {
+		if user.Email == email && user.ID != except {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handleTaken compares handles without case, like the unique index on
+// LOWER(handle). It must be called with the lock held.
+func (m *Memory) handleTaken(handle string, except uuid.UUID) bool {
+	for _, user := range m.users {
+		if user.Handle.Valid && strings.EqualFold(user.Handle.String, handle) && user.ID != except {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sortedChirps returns the chirps that are not soft deleted. It must be
+// called with the lock held.
+func (m *Memory) sortedChirps() []database.Chirp {
+	chirps := make([]database.Chirp, 0, len(m.chirps))
+	for _, chirp := range m.chirps {
+		if !chirp.DeletedAt.Valid {
+			chirps = append(chirps, chirp)
+		}
+	}
+
+	slices.SortFunc(chirps, func(a, b database.Chirp) int {
+		return compareChirpKey(a, b.CreatedAt, b.ID)
+	})
+
+	return chirps
+}
+
+// listChirps must be called with the lock held.
+func (m *Memory) listChirps(arg database.ListChirpsParams, desc bool) []database.Chirp {
+	sorted := m.sortedChirps()
+	if desc {
+		slices.Reverse(sorted)
+	}
+
+	chirps := []database.Chirp{}
+	for _, chirp := range sorted {
+		if len(chirps) == int(arg.PageSize) {
+			break
+		}
+
+		if arg.AfterCreatedAt.Valid {
+			c := compareChirpKey(chirp, arg.AfterCreatedAt.Time, arg.AfterID.UUID)
+			if (!desc && c <= 0) || (desc && c >= 0) {
+				continue
+			}
+		}
+		if arg.AuthorID.Valid && chirp.UserID != arg.AuthorID.UUID {
+			continue
+		}
+		if arg.Since.Valid && chirp.CreatedAt.Before(arg.Since.Time) {
+			continue
+		}
+		if arg.Until.Valid && !chirp.CreatedAt.Before(arg.Until.Time) {
+			continue
+		}
+
+		chirps = append(chirps, chirp)
+	}
+
+	return chirps
+}
+
+// compareChirpKey orders chirps by (created_at, id), the same key the
+// listing queries use.
+func compareChirpKey(chirp database.Chirp, createdAt time.Time, id uuid.UUID) int {
+	if c := chirp.CreatedAt.Compare(createdAt); c != 0 {
+		return c
+	}
+	return cmp.Compare(chirp.ID.String(), id.String())
+}
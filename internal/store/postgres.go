@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/fernando8franco/http-server-golang/internal/database"
+	"github.com/fernando8franco/http-server-golang/internal/search"
+)
+
+// Postgres is the sqlc generated database.Queries plus the few methods whose
+// arguments have to be prepared before they reach the SQL, or whose errors
+// are translated to the ones every backend returns.
+type Postgres struct {
+	*database.Queries
+	db *sql.DB
+}
+
+var _ database.Querier = (*Postgres)(nil)
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{Queries: database.New(db), db: db}
+}
+
+func (p *Postgres) CreateUser(ctx context.Context, arg database.CreateUserParams) (database.User, error) {
+	user, err := p.Queries.CreateUser(ctx, arg)
+	return user, uniqueViolation(err)
+}
+
+func (p *Postgres) PatchUser(ctx context.Context, arg database.PatchUserParams) (database.User, error) {
+	user, err := p.Queries.PatchUser(ctx, arg)
+	return user, uniqueViolation(err)
+}
+
+func (p *Postgres) UpdateUser(ctx context.Context, arg database.UpdateUserParams) (database.User, error) {
+	user, err := p.Queries.UpdateUser(ctx, arg)
+	return user, uniqueViolation(err)
+}
+
+// postgresLockUser holds the row of a user until the transaction ends.
+const postgresLockUser = `
+SELECT id FROM users
+WHERE id = $1
+FOR UPDATE
+`
+
+// CreatePasswordResetToken locks the user before counting their recent
+// tokens. The count only sees the tokens committed when its statement starts,
+// so without the lock two requests could both come in under the limit.
+func (p *Postgres) CreatePasswordResetToken(ctx context.Context, arg database.CreatePasswordResetTokenParams) (database.PasswordResetToken, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return database.PasswordResetToken{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, postgresLockUser, arg.UserID)
+	if err != nil {
+		return database.PasswordResetToken{}, err
+	}
+
+	passwordReset, err := p.Queries.WithTx(tx).CreatePasswordResetToken(ctx, arg)
+	if err != nil {
+		return database.PasswordResetToken{}, err
+	}
+
+	return passwordReset, tx.Commit()
+}
+
+// SearchChirps expects the query as the user typed it and compiles it to a
+// tsquery. The snippets come back as escaped HTML, like search.Match makes.
+func (p *Postgres) SearchChirps(ctx context.Context, arg database.SearchChirpsParams) ([]database.SearchChirpsRow, error) {
+	query, err := search.Parse(arg.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	arg.Query = query.TSQuery()
+	rows, err := p.Queries.SearchChirps(ctx, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range rows {
+		rows[i].Snippet = search.Snippet(rows[i].Snippet)
+	}
+	return rows, nil
+}
@@ -0,0 +1,1122 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/fernando8franco/http-server-golang/internal/database"
+	"github.com/fernando8franco/http-server-golang/internal/search"
+	"github.com/google/uuid"
+)
+
+// SQLite is a database.Querier backed by a SQLite file. SQLite has no
+// gen_random_uuid() or interval arithmetic, so ids and timestamps are
+// generated here and passed to the queries. Timestamps are stored as text and
+// always written in UTC, which keeps the comparisons in the queries correct.
+type SQLite struct {
+	db *sql.DB
+}
+
+var _ database.Querier = (*SQLite)(nil)
+
+func NewSQLite(db *sql.DB) *SQLite {
+	return &SQLite{db: db}
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanUser(row scanner) (database.User, error) {
+	var i database.User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.IsAdmin,
+		&i.SuspendedAt,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+	)
+	return i, err
+}
+
+func scanChirp(row scanner) (database.Chirp, error) {
+	var i database.Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.DeletedAt,
+		&i.EditedAt,
+	)
+	return i, err
+}
+
+func scanChirps(rows *sql.Rows, err error) ([]database.Chirp, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []database.Chirp
+	for rows.Next() {
+		i, err := scanChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func scanRefreshToken(row scanner) (database.RefreshToken, error) {
+	var i database.RefreshToken
+	err := row.Scan(
+		&i.TokenHash,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.FamilyID,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.SessionStartedAt,
+		&i.LastUsedAt,
+		&i.AccessTokenJti,
+	)
+	return i, err
+}
+
+func scanPersonalAccessToken(row scanner) (database.PersonalAccessToken, error) {
+	var i database.PersonalAccessToken
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.TokenHash,
+		&i.Scopes,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+	)
+	return i, err
+}
+
+const sqliteCreateUser = `
+INSERT INTO users (id, created_at, updated_at, email, hashed_password)
+VALUES (?1, ?2, ?2, ?3, ?4)
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, is_admin, suspended_at, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, handle, display_name, bio, avatar_url
+`
+
+func (s *SQLite) CreateUser(ctx context.Context, arg database.CreateUserParams) (database.User, error) {
+	row := s.db.QueryRowContext(ctx, sqliteCreateUser, uuid.New(), now(), arg.Email, arg.HashedPassword)
+	user, err := scanUser(row)
+	return user, uniqueViolation(err)
+}
+
+const sqliteDeleteUsers = `
+DELETE FROM users
+`
+
+func (s *SQLite) DeleteUsers(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, sqliteDeleteUsers)
+	return err
+}
+
+const sqliteGetUserByEmail = `
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, is_admin, suspended_at, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, handle, display_name, bio, avatar_url FROM users
+WHERE email = ?1
+`
+
+func (s *SQLite) GetUserByEmail(ctx context.Context, email string) (database.User, error) {
+	row := s.db.QueryRowContext(ctx, sqliteGetUserByEmail, email)
+	return scanUser(row)
+}
+
+const sqliteGetUserByHandle = `
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, is_admin, suspended_at, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, handle, display_name, bio, avatar_url FROM users
+WHERE LOWER(handle) = LOWER(?1)
+`
+
+func (s *SQLite) GetUserByHandle(ctx context.Context, lower string) (database.User, error) {
+	row := s.db.QueryRowContext(ctx, sqliteGetUserByHandle, lower)
+	return scanUser(row)
+}
+
+const sqliteGetUserById = `
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, is_admin, suspended_at, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, handle, display_name, bio, avatar_url FROM users
+WHERE id = ?1
+`
+
+func (s *SQLite) GetUserById(ctx context.Context, id uuid.UUID) (database.User, error) {
+	row := s.db.QueryRowContext(ctx, sqliteGetUserById, id)
+	return scanUser(row)
+}
+
+// SQLite has no arrays, so the ids are passed as a JSON array.
+const sqliteListChirpAuthors = `
+SELECT id, handle, display_name, avatar_url FROM users
+WHERE id IN (SELECT value FROM json_each(?1))
+`
+
+func (s *SQLite) ListChirpAuthors(ctx context.Context, ids []uuid.UUID) ([]database.ListChirpAuthorsRow, error) {
+	idsJSON, err := json.Marshal(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, sqliteListChirpAuthors, string(idsJSON))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []database.ListChirpAuthorsRow
+	for rows.Next() {
+		var i database.ListChirpAuthorsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Handle,
+			&i.DisplayName,
+			&i.AvatarUrl,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const sqliteSetUserAdmin = `
+UPDATE users
+SET updated_at = ?1, is_admin = ?2
+WHERE email = ?3
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, is_admin, suspended_at, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, handle, display_name, bio, avatar_url
+`
+
+func (s *SQLite) SetUserAdmin(ctx context.Context, arg database.SetUserAdminParams) (database.User, error) {
+	row := s.db.QueryRowContext(ctx, sqliteSetUserAdmin, now(), arg.IsAdmin, arg.Email)
+	return scanUser(row)
+}
+
+const sqliteSetUserSuspended = `
+UPDATE users
+SET updated_at = ?1, suspended_at = CASE WHEN ?2 THEN ?1 ELSE NULL END
+WHERE email = ?3
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, is_admin, suspended_at, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, handle, display_name, bio, avatar_url
+`
+
+func (s *SQLite) SetUserSuspended(ctx context.Context, arg database.SetUserSuspendedParams) (database.User, error) {
+	row := s.db.QueryRowContext(ctx, sqliteSetUserSuspended, now(), arg.Suspended, arg.Email)
+	return scanUser(row)
+}
+
+const sqliteSetUserTOTPSecret = `
+UPDATE users
+SET updated_at = ?1, totp_secret = ?2, totp_enabled_at = NULL, totp_last_step = NULL
+WHERE id = ?3
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, is_admin, suspended_at, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, handle, display_name, bio, avatar_url
+`
+
+func (s *SQLite) SetUserTOTPSecret(ctx context.Context, arg database.SetUserTOTPSecretParams) (database.User, error) {
+	row := s.db.QueryRowContext(ctx, sqliteSetUserTOTPSecret, now(), arg.TotpSecret, arg.ID)
+	return scanUser(row)
+}
+
+const sqliteEnableUserTOTP = `
+UPDATE users
+SET updated_at = ?1, totp_enabled_at = ?1
+WHERE id = ?2
+AND totp_secret IS NOT NULL
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, is_admin, suspended_at, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, handle, display_name, bio, avatar_url
+`
+
+func (s *SQLite) EnableUserTOTP(ctx context.Context, id uuid.UUID) (database.User, error) {
+	row := s.db.QueryRowContext(ctx, sqliteEnableUserTOTP, now(), id)
+	return scanUser(row)
+}
+
+const sqliteUseTOTPStep = `
+UPDATE users
+SET totp_last_step = ?1
+WHERE id = ?2
+AND (totp_last_step IS NULL OR totp_last_step < ?1)
+`
+
+func (s *SQLite) UseTOTPStep(ctx context.Context, arg database.UseTOTPStepParams) (int64, error) {
+	result, err := s.db.ExecContext(ctx, sqliteUseTOTPStep, arg.Step, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const sqlitePatchUser = `
+UPDATE users
+SET updated_at = ?1,
+    email = COALESCE(?2, email),
+    hashed_password = COALESCE(?3, hashed_password),
+    email_verified_at = CASE WHEN email = COALESCE(?2, email) THEN email_verified_at ELSE NULL END,
+    handle = ?4, display_name = ?5, bio = ?6, avatar_url = ?7
+WHERE id = ?8
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, is_admin, suspended_at, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, handle, display_name, bio, avatar_url
+`
+
+func (s *SQLite) PatchUser(ctx context.Context, arg database.PatchUserParams) (database.User, error) {
+	row := s.db.QueryRowContext(ctx, sqlitePatchUser, now(), arg.Email, arg.HashedPassword, arg.Handle, arg.DisplayName, arg.Bio, arg.AvatarUrl, arg.ID)
+	user, err := scanUser(row)
+	return user, uniqueViolation(err)
+}
+
+const sqliteUpdateUser = `
+UPDATE users
+SET updated_at = ?1, email = ?2, hashed_password = ?3,
+    email_verified_at = CASE WHEN email = ?2 THEN email_verified_at ELSE NULL END
+WHERE id = ?4
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, is_admin, suspended_at, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, handle, display_name, bio, avatar_url
+`
+
+func (s *SQLite) UpdateUser(ctx context.Context, arg database.UpdateUserParams) (database.User, error) {
+	row := s.db.QueryRowContext(ctx, sqliteUpdateUser, now(), arg.Email, arg.HashedPassword, arg.ID)
+	user, err := scanUser(row)
+	return user, uniqueViolation(err)
+}
+
+const sqliteUpdateUserPassword = `
+UPDATE users
+SET updated_at = ?1, hashed_password = ?2
+WHERE id = ?3
+`
+
+func (s *SQLite) UpdateUserPassword(ctx context.Context, arg database.UpdateUserPasswordParams) error {
+	_, err := s.db.ExecContext(ctx, sqliteUpdateUserPassword, now(), arg.HashedPassword, arg.ID)
+	return err
+}
+
+const sqliteRehashUserPassword = `
+UPDATE users
+SET hashed_password = ?1
+WHERE id = ?2
+AND hashed_password = ?3
+`
+
+func (s *SQLite) RehashUserPassword(ctx context.Context, arg database.RehashUserPasswordParams) error {
+	_, err := s.db.ExecContext(ctx, sqliteRehashUserPassword, arg.HashedPassword, arg.ID, arg.OldHashedPassword)
+	return err
+}
+
+const sqliteVerifyUserEmail = `
+UPDATE users
+SET updated_at = ?1, email_verified_at = COALESCE(email_verified_at, ?1)
+WHERE id = ?2
+AND email = ?3
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, is_admin, suspended_at, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, handle, display_name, bio, avatar_url
+`
+
+func (s *SQLite) VerifyUserEmail(ctx context.Context, arg database.VerifyUserEmailParams) (database.User, error) {
+	row := s.db.QueryRowContext(ctx, sqliteVerifyUserEmail, now(), arg.ID, arg.Email)
+	return scanUser(row)
+}
+
+const sqliteUpdateUserToChirpyRed = `
+UPDATE users
+SET updated_at = ?1, is_chirpy_red = TRUE
+WHERE id = ?2
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, is_admin, suspended_at, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, handle, display_name, bio, avatar_url
+`
+
+func (s *SQLite) UpdateUserToChirpyRed(ctx context.Context, id uuid.UUID) (database.User, error) {
+	row := s.db.QueryRowContext(ctx, sqliteUpdateUserToChirpyRed, now(), id)
+	return scanUser(row)
+}
+
+const sqliteCreateChirp = `
+INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+VALUES (?1, ?2, ?2, ?3, ?4)
+RETURNING id, created_at, updated_at, body, user_id, deleted_at, edited_at
+`
+
+func (s *SQLite) CreateChirp(ctx context.Context, arg database.CreateChirpParams) (database.Chirp, error) {
+	row := s.db.QueryRowContext(ctx, sqliteCreateChirp, uuid.New(), now(), arg.Body, arg.UserID)
+	return scanChirp(row)
+}
+
+const sqliteDeleteChirp = `
+DELETE FROM chirps
+`
+
+func (s *SQLite) DeleteChirp(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, sqliteDeleteChirp)
+	return err
+}
+
+const sqliteSoftDeleteChirpById = `
+UPDATE chirps
+SET deleted_at = ?1, updated_at = ?1
+WHERE id = ?2
+AND deleted_at IS NULL
+`
+
+func (s *SQLite) SoftDeleteChirpById(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := s.db.ExecContext(ctx, sqliteSoftDeleteChirpById, now(), id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const sqliteRestoreChirp = `
+UPDATE chirps
+SET deleted_at = NULL, updated_at = ?1
+WHERE id = ?2
+AND deleted_at > ?3
+RETURNING id, created_at, updated_at, body, user_id, deleted_at, edited_at
+`
+
+func (s *SQLite) RestoreChirp(ctx context.Context, arg database.RestoreChirpParams) (database.Chirp, error) {
+	t := now()
+	row := s.db.QueryRowContext(ctx, sqliteRestoreChirp, t, arg.ID, t.Add(-seconds(arg.WindowSeconds)))
+	return scanChirp(row)
+}
+
+const sqlitePurgeDeletedChirps = `
+DELETE FROM chirps
+WHERE deleted_at < ?1
+`
+
+func (s *SQLite) PurgeDeletedChirps(ctx context.Context, retentionSeconds float64) (int64, error) {
+	result, err := s.db.ExecContext(ctx, sqlitePurgeDeletedChirps, now().Add(-seconds(retentionSeconds)))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const sqliteGetOneChirp = `
+SELECT id, created_at, updated_at, body, user_id, deleted_at, edited_at FROM chirps
+WHERE id = ?1
+AND deleted_at IS NULL
+`
+
+func (s *SQLite) GetOneChirp(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	row := s.db.QueryRowContext(ctx, sqliteGetOneChirp, id)
+	return scanChirp(row)
+}
+
+const sqliteGetChirpIncludingDeleted = `
+SELECT id, created_at, updated_at, body, user_id, deleted_at, edited_at FROM chirps
+WHERE id = ?1
+`
+
+func (s *SQLite) GetChirpIncludingDeleted(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	row := s.db.QueryRowContext(ctx, sqliteGetChirpIncludingDeleted, id)
+	return scanChirp(row)
+}
+
+const sqliteListChirps = `
+SELECT id, created_at, updated_at, body, user_id, deleted_at, edited_at FROM chirps
+WHERE deleted_at IS NULL
+AND (?1 IS NULL OR (created_at, id) > (?1, ?2))
+AND (?3 IS NULL OR user_id = ?3)
+AND (?4 IS NULL OR created_at >= ?4)
+AND (?5 IS NULL OR created_at < ?5)
+ORDER BY created_at ASC, id ASC
+LIMIT ?6
+`
+
+func (s *SQLite) ListChirps(ctx context.Context, arg database.ListChirpsParams) ([]database.Chirp, error) {
+	return scanChirps(s.db.QueryContext(ctx, sqliteListChirps,
+		arg.AfterCreatedAt,
+		arg.AfterID,
+		arg.AuthorID,
+		arg.Since,
+		arg.Until,
+		arg.PageSize,
+	))
+}
+
+const sqliteListChirpsDesc = `
+SELECT id, created_at, updated_at, body, user_id, deleted_at, edited_at FROM chirps
+WHERE deleted_at IS NULL
+AND (?1 IS NULL OR (created_at, id) < (?1, ?2))
+AND (?3 IS NULL OR user_id = ?3)
+AND (?4 IS NULL OR created_at >= ?4)
+AND (?5 IS NULL OR created_at < ?5)
+ORDER BY created_at DESC, id DESC
+LIMIT ?6
+`
+
+func (s *SQLite) ListChirpsDesc(ctx context.Context, arg database.ListChirpsDescParams) ([]database.Chirp, error) {
+	return scanChirps(s.db.QueryContext(ctx, sqliteListChirpsDesc,
+		arg.AfterCreatedAt,
+		arg.AfterID,
+		arg.AuthorID,
+		arg.Since,
+		arg.Until,
+		arg.PageSize,
+	))
+}
+
+// bm25 scores better matches lower, so it is negated to sort like ts_rank.
+const sqliteSearchChirps = `
+SELECT id, created_at, updated_at, body, user_id, edited_at, rank, snippet FROM (
+    SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.edited_at,
+        -bm25(chirps_fts) AS rank,
+        highlight(chirps_fts, 1, char(57344), char(57345)) AS snippet
+    FROM chirps_fts
+    JOIN chirps ON chirps.id = chirps_fts.id
+    WHERE chirps_fts MATCH ?1
+    AND chirps.deleted_at IS NULL
+    AND (?2 IS NULL OR chirps.user_id = ?2)
+    AND (?3 IS NULL OR chirps.created_at >= ?3)
+    AND (?4 IS NULL OR chirps.created_at < ?4)
+) AS results
+WHERE ?5 IS NULL
+OR (rank, created_at, id) < (?5, ?6, ?7)
+ORDER BY rank DESC, created_at DESC, id DESC
+LIMIT ?8
+`
+
+func (s *SQLite) SearchChirps(ctx context.Context, arg database.SearchChirpsParams) ([]database.SearchChirpsRow, error) {
+	query, err := search.Parse(arg.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, sqliteSearchChirps,
+		query.FTS5(),
+		arg.AuthorID,
+		arg.Since,
+		arg.Until,
+		arg.AfterRank,
+		arg.AfterCreatedAt,
+		arg.AfterID,
+		arg.PageSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []database.SearchChirpsRow
+	for rows.Next() {
+		var i database.SearchChirpsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.EditedAt,
+			&i.Rank,
+			&i.Snippet,
+		); err != nil {
+			return nil, err
+		}
+		i.Snippet = search.Snippet(i.Snippet)
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const sqliteInsertChirpRevision = `
+INSERT INTO chirp_revisions (id, chirp_id, body, created_at)
+SELECT ?1, chirps.id, chirps.body, ?2 FROM chirps
+WHERE chirps.id = ?3
+AND chirps.deleted_at IS NULL
+`
+
+const sqliteUpdateChirpBody = `
+UPDATE chirps
+SET body = ?1, updated_at = ?2, edited_at = ?2
+WHERE id = ?3
+AND deleted_at IS NULL
+RETURNING id, created_at, updated_at, body, user_id, deleted_at, edited_at
+`
+
+// UpdateChirpBody saves the current body as a revision and replaces it in one
+// transaction, SQLite has no data-modifying CTEs to do it in one statement.
+// Transactions begin immediate, so no other writer can change the body in
+// between.
+func (s *SQLite) UpdateChirpBody(ctx context.Context, arg database.UpdateChirpBodyParams) (database.Chirp, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	t := now()
+	if _, err := tx.ExecContext(ctx, sqliteInsertChirpRevision, uuid.New(), t, arg.ID); err != nil {
+		return database.Chirp{}, err
+	}
+
+	chirp, err := scanChirp(tx.QueryRowContext(ctx, sqliteUpdateChirpBody, arg.Body, t, arg.ID))
+	if err != nil {
+		return database.Chirp{}, err
+	}
+
+	return chirp, tx.Commit()
+}
+
+const sqliteListChirpRevisions = `
+SELECT id, chirp_id, body, created_at FROM chirp_revisions
+WHERE chirp_id = ?1
+ORDER BY created_at DESC, id DESC
+`
+
+func (s *SQLite) ListChirpRevisions(ctx context.Context, chirpID uuid.UUID) ([]database.ChirpRevision, error) {
+	rows, err := s.db.QueryContext(ctx, sqliteListChirpRevisions, chirpID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []database.ChirpRevision
+	for rows.Next() {
+		var i database.ChirpRevision
+		if err := rows.Scan(
+			&i.ID,
+			&i.ChirpID,
+			&i.Body,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const sqliteDenyFamilyAccessTokens = `
+INSERT INTO denied_access_tokens (jti, expires_at)
+SELECT access_token_jti, ?1 FROM refresh_tokens
+WHERE family_id = ?2
+AND created_at > ?3
+AND access_token_jti IS NOT NULL
+ON CONFLICT (jti) DO NOTHING
+`
+
+func (s *SQLite) DenyFamilyAccessTokens(ctx context.Context, arg database.DenyFamilyAccessTokensParams) (int64, error) {
+	t := now()
+	result, err := s.db.ExecContext(ctx, sqliteDenyFamilyAccessTokens,
+		t.Add(seconds(arg.ExpiresInSeconds)),
+		arg.FamilyID,
+		t.Add(-seconds(arg.IssuedWithinSeconds)),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const sqliteDenyUserAccessTokens = `
+INSERT INTO denied_access_tokens (jti, expires_at)
+SELECT access_token_jti, ?1 FROM refresh_tokens
+WHERE user_id = ?2
+AND created_at > ?3
+AND access_token_jti IS NOT NULL
+AND access_token_jti <> ?4
+ON CONFLICT (jti) DO NOTHING
+`
+
+func (s *SQLite) DenyUserAccessTokens(ctx context.Context, arg database.DenyUserAccessTokensParams) (int64, error) {
+	t := now()
+	result, err := s.db.ExecContext(ctx, sqliteDenyUserAccessTokens,
+		t.Add(seconds(arg.ExpiresInSeconds)),
+		arg.UserID,
+		t.Add(-seconds(arg.IssuedWithinSeconds)),
+		arg.ExceptJti,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const sqliteIsAccessTokenDenied = `
+SELECT EXISTS (
+    SELECT 1 FROM denied_access_tokens
+    WHERE jti = ?1
+    AND expires_at > ?2
+)
+`
+
+func (s *SQLite) IsAccessTokenDenied(ctx context.Context, jti string) (bool, error) {
+	row := s.db.QueryRowContext(ctx, sqliteIsAccessTokenDenied, jti, now())
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const sqlitePurgeDeniedAccessTokens = `
+DELETE FROM denied_access_tokens
+WHERE expires_at <= ?1
+`
+
+func (s *SQLite) PurgeDeniedAccessTokens(ctx context.Context) (int64, error) {
+	result, err := s.db.ExecContext(ctx, sqlitePurgeDeniedAccessTokens, now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const sqliteConsumeRefreshToken = `
+UPDATE refresh_tokens
+SET revoked_at = ?1, updated_at = ?1
+WHERE token_hash = ?2
+AND revoked_at IS NULL
+AND expires_at > ?1
+`
+
+const sqliteCreateRefreshToken = `
+INSERT INTO refresh_tokens (token_hash, created_at, updated_at, user_id, expires_at, family_id, user_agent, ip_address, session_started_at, access_token_jti)
+VALUES (?1, ?2, ?2, ?3, ?4, ?5, ?6, ?7, ?2, ?8)
+RETURNING token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, user_agent, ip_address, session_started_at, last_used_at, access_token_jti
+`
+
+func (s *SQLite) CreateRefreshToken(ctx context.Context, arg database.CreateRefreshTokenParams) (database.RefreshToken, error) {
+	t := now()
+	row := s.db.QueryRowContext(ctx, sqliteCreateRefreshToken,
+		arg.TokenHash,
+		t,
+		arg.UserID,
+		t.Add(refreshTokenDuration),
+		arg.FamilyID,
+		arg.UserAgent,
+		arg.IpAddress,
+		arg.AccessTokenJti,
+	)
+	return scanRefreshToken(row)
+}
+
+const sqliteGetRefreshToken = `
+SELECT token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, user_agent, ip_address, session_started_at, last_used_at, access_token_jti FROM refresh_tokens
+WHERE token_hash = ?1
+`
+
+func (s *SQLite) GetRefreshToken(ctx context.Context, tokenHash string) (database.RefreshToken, error) {
+	row := s.db.QueryRowContext(ctx, sqliteGetRefreshToken, tokenHash)
+	return scanRefreshToken(row)
+}
+
+const sqliteGetUserIdFromRefreshToken = `
+SELECT user_id FROM refresh_tokens
+WHERE token_hash = ?1
+AND revoked_at IS NULL
+AND expires_at > ?2
+`
+
+func (s *SQLite) GetUserIdFromRefreshToken(ctx context.Context, tokenHash string) (uuid.UUID, error) {
+	row := s.db.QueryRowContext(ctx, sqliteGetUserIdFromRefreshToken, tokenHash, now())
+	var user_id uuid.UUID
+	err := row.Scan(&user_id)
+	return user_id, err
+}
+
+const sqliteListSessions = `
+SELECT token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, user_agent, ip_address, session_started_at, last_used_at, access_token_jti FROM refresh_tokens
+WHERE user_id = ?1
+AND revoked_at IS NULL
+AND expires_at > ?2
+ORDER BY session_started_at DESC, family_id
+`
+
+func (s *SQLite) ListSessions(ctx context.Context, userID uuid.UUID) ([]database.RefreshToken, error) {
+	rows, err := s.db.QueryContext(ctx, sqliteListSessions, userID, now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []database.RefreshToken
+	for rows.Next() {
+		i, err := scanRefreshToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const sqliteRevokeAllSessions = `
+UPDATE refresh_tokens
+SET revoked_at = ?1, updated_at = ?1
+WHERE user_id = ?2
+AND revoked_at IS NULL
+`
+
+func (s *SQLite) RevokeAllSessions(ctx context.Context, userID uuid.UUID) (int64, error) {
+	result, err := s.db.ExecContext(ctx, sqliteRevokeAllSessions, now(), userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const sqliteRevokeOtherSessions = `
+UPDATE refresh_tokens
+SET revoked_at = ?1, updated_at = ?1
+WHERE user_id = ?2
+AND revoked_at IS NULL
+AND family_id NOT IN (
+    SELECT family_id FROM refresh_tokens
+    WHERE access_token_jti = ?3
+)
+`
+
+func (s *SQLite) RevokeOtherSessions(ctx context.Context, arg database.RevokeOtherSessionsParams) (int64, error) {
+	result, err := s.db.ExecContext(ctx, sqliteRevokeOtherSessions, now(), arg.UserID, arg.AccessTokenJti)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const sqliteRevokeRefreshTokenFamily = `
+UPDATE refresh_tokens
+SET revoked_at = ?1, updated_at = ?1
+WHERE family_id = ?2
+AND revoked_at IS NULL
+`
+
+func (s *SQLite) RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) (int64, error) {
+	result, err := s.db.ExecContext(ctx, sqliteRevokeRefreshTokenFamily, now(), familyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const sqliteRevokeSession = `
+UPDATE refresh_tokens
+SET revoked_at = ?1, updated_at = ?1
+WHERE family_id = ?2
+AND user_id = ?3
+AND revoked_at IS NULL
+`
+
+func (s *SQLite) RevokeSession(ctx context.Context, arg database.RevokeSessionParams) (int64, error) {
+	result, err := s.db.ExecContext(ctx, sqliteRevokeSession, now(), arg.FamilyID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const sqliteRotateRefreshToken = `
+INSERT INTO refresh_tokens (token_hash, created_at, updated_at, user_id, expires_at, family_id, user_agent, ip_address, session_started_at, last_used_at, access_token_jti)
+SELECT ?1, ?2, ?2, user_id, ?3, family_id, user_agent, ip_address, session_started_at, ?2, ?4
+FROM refresh_tokens
+WHERE token_hash = ?5
+RETURNING token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, user_agent, ip_address, session_started_at, last_used_at, access_token_jti
+`
+
+// RotateRefreshToken revokes the token and inserts its successor in one
+// transaction, SQLite has no data-modifying CTEs to do it in one statement.
+func (s *SQLite) RotateRefreshToken(ctx context.Context, arg database.RotateRefreshTokenParams) (database.RefreshToken, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return database.RefreshToken{}, err
+	}
+	defer tx.Rollback()
+
+	t := now()
+	result, err := tx.ExecContext(ctx, sqliteConsumeRefreshToken, t, arg.TokenHash)
+	if err != nil {
+		return database.RefreshToken{}, err
+	}
+	consumed, err := result.RowsAffected()
+	if err != nil {
+		return database.RefreshToken{}, err
+	}
+	if consumed == 0 {
+		return database.RefreshToken{}, sql.ErrNoRows
+	}
+
+	refreshToken, err := scanRefreshToken(tx.QueryRowContext(ctx, sqliteRotateRefreshToken, arg.NewTokenHash, t, t.Add(refreshTokenDuration), arg.AccessTokenJti, arg.TokenHash))
+	if err != nil {
+		return database.RefreshToken{}, err
+	}
+
+	return refreshToken, tx.Commit()
+}
+
+const sqliteSetRevokedAt = `
+UPDATE refresh_tokens
+SET revoked_at = ?1, updated_at = ?1
+WHERE token_hash = ?2
+`
+
+func (s *SQLite) SetRevokedAt(ctx context.Context, tokenHash string) error {
+	_, err := s.db.ExecContext(ctx, sqliteSetRevokedAt, now(), tokenHash)
+	return err
+}
+
+const sqliteCreatePersonalAccessToken = `
+INSERT INTO personal_access_tokens (id, user_id, name, token_hash, scopes, created_at, expires_at)
+VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7)
+RETURNING id, user_id, name, token_hash, scopes, created_at, expires_at, last_used_at
+`
+
+func (s *SQLite) CreatePersonalAccessToken(ctx context.Context, arg database.CreatePersonalAccessTokenParams) (database.PersonalAccessToken, error) {
+	row := s.db.QueryRowContext(ctx, sqliteCreatePersonalAccessToken,
+		uuid.New(),
+		arg.UserID,
+		arg.Name,
+		arg.TokenHash,
+		arg.Scopes,
+		now(),
+		arg.ExpiresAt,
+	)
+	return scanPersonalAccessToken(row)
+}
+
+const sqliteDeletePersonalAccessToken = `
+DELETE FROM personal_access_tokens
+WHERE id = ?1
+AND user_id = ?2
+`
+
+func (s *SQLite) DeletePersonalAccessToken(ctx context.Context, arg database.DeletePersonalAccessTokenParams) (int64, error) {
+	result, err := s.db.ExecContext(ctx, sqliteDeletePersonalAccessToken, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const sqliteListPersonalAccessTokens = `
+SELECT id, user_id, name, token_hash, scopes, created_at, expires_at, last_used_at FROM personal_access_tokens
+WHERE user_id = ?1
+ORDER BY created_at DESC, id
+`
+
+func (s *SQLite) ListPersonalAccessTokens(ctx context.Context, userID uuid.UUID) ([]database.PersonalAccessToken, error) {
+	rows, err := s.db.QueryContext(ctx, sqliteListPersonalAccessTokens, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []database.PersonalAccessToken
+	for rows.Next() {
+		i, err := scanPersonalAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const sqliteUsePersonalAccessToken = `
+UPDATE personal_access_tokens
+SET last_used_at = ?1
+WHERE token_hash = ?2
+AND (expires_at IS NULL OR expires_at > ?1)
+RETURNING id, user_id, name, token_hash, scopes, created_at, expires_at, last_used_at
+`
+
+func (s *SQLite) UsePersonalAccessToken(ctx context.Context, arg database.UsePersonalAccessTokenParams) (database.PersonalAccessToken, error) {
+	row := s.db.QueryRowContext(ctx, sqliteUsePersonalAccessToken, arg.Now, arg.TokenHash)
+	return scanPersonalAccessToken(row)
+}
+
+const sqliteCreateRecoveryCode = `
+INSERT INTO recovery_codes (id, user_id, code_hash, created_at)
+VALUES (?1, ?2, ?3, ?4)
+`
+
+func (s *SQLite) CreateRecoveryCode(ctx context.Context, arg database.CreateRecoveryCodeParams) error {
+	_, err := s.db.ExecContext(ctx, sqliteCreateRecoveryCode, uuid.New(), arg.UserID, arg.CodeHash, now())
+	return err
+}
+
+const sqliteDeleteRecoveryCodes = `
+DELETE FROM recovery_codes
+WHERE user_id = ?1
+`
+
+func (s *SQLite) DeleteRecoveryCodes(ctx context.Context, userID uuid.UUID) error {
+	_, err := s.db.ExecContext(ctx, sqliteDeleteRecoveryCodes, userID)
+	return err
+}
+
+const sqliteUseRecoveryCode = `
+UPDATE recovery_codes
+SET used_at = ?1
+WHERE user_id = ?2
+AND code_hash = ?3
+AND used_at IS NULL
+`
+
+func (s *SQLite) UseRecoveryCode(ctx context.Context, arg database.UseRecoveryCodeParams) (int64, error) {
+	result, err := s.db.ExecContext(ctx, sqliteUseRecoveryCode, now(), arg.UserID, arg.CodeHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const sqliteCreatePasswordResetToken = `
+INSERT INTO password_reset_tokens (token_hash, user_id, created_at, expires_at)
+SELECT ?1, ?2, ?3, ?4
+WHERE (
+	SELECT COUNT(*) FROM password_reset_tokens
+	WHERE user_id = ?2
+	AND created_at > ?5
+) < ?6
+RETURNING token_hash, user_id, created_at, expires_at, used_at
+`
+
+// CreatePasswordResetToken counts and inserts in one statement, which SQLite
+// runs under its write lock, so concurrent requests can't both come in under
+// the limit.
+func (s *SQLite) CreatePasswordResetToken(ctx context.Context, arg database.CreatePasswordResetTokenParams) (database.PasswordResetToken, error) {
+	t := now()
+	row := s.db.QueryRowContext(ctx, sqliteCreatePasswordResetToken,
+		arg.TokenHash,
+		arg.UserID,
+		t,
+		t.Add(seconds(arg.ExpiresInSeconds)),
+		t.Add(-seconds(arg.WindowSeconds)),
+		arg.MaxTokens,
+	)
+	var i database.PasswordResetToken
+	err := row.Scan(
+		&i.TokenHash,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.UsedAt,
+	)
+	return i, err
+}
+
+const sqliteDeletePasswordResetTokens = `
+DELETE FROM password_reset_tokens
+WHERE user_id = ?1
+`
+
+func (s *SQLite) DeletePasswordResetTokens(ctx context.Context, userID uuid.UUID) error {
+	_, err := s.db.ExecContext(ctx, sqliteDeletePasswordResetTokens, userID)
+	return err
+}
+
+const sqliteGetPasswordResetTokenEmail = `
+SELECT users.email FROM password_reset_tokens
+JOIN users ON users.id = password_reset_tokens.user_id
+WHERE password_reset_tokens.token_hash = ?1
+AND password_reset_tokens.used_at IS NULL
+AND password_reset_tokens.expires_at > ?2
+`
+
+func (s *SQLite) GetPasswordResetTokenEmail(ctx context.Context, tokenHash string) (string, error) {
+	row := s.db.QueryRowContext(ctx, sqliteGetPasswordResetTokenEmail, tokenHash, now())
+	var email string
+	err := row.Scan(&email)
+	return email, err
+}
+
+const sqliteUsePasswordResetToken = `
+UPDATE password_reset_tokens
+SET used_at = ?1
+WHERE token_hash = ?2
+AND used_at IS NULL
+AND expires_at > ?1
+RETURNING user_id
+`
+
+func (s *SQLite) UsePasswordResetToken(ctx context.Context, tokenHash string) (uuid.UUID, error) {
+	row := s.db.QueryRowContext(ctx, sqliteUsePasswordResetToken, now(), tokenHash)
+	var userID uuid.UUID
+	err := row.Scan(&userID)
+	return userID, err
+}
+
+const sqliteCreateTwoFactorChallenge = `
+INSERT INTO two_factor_challenges (id, user_id, expires_at)
+VALUES (?1, ?2, ?3)
+`
+
+func (s *SQLite) CreateTwoFactorChallenge(ctx context.Context, arg database.CreateTwoFactorChallengeParams) error {
+	_, err := s.db.ExecContext(ctx, sqliteCreateTwoFactorChallenge, arg.ID, arg.UserID, now().Add(seconds(arg.ExpiresInSeconds)))
+	return err
+}
+
+const sqliteAttemptTwoFactorChallenge = `
+UPDATE two_factor_challenges
+SET attempts = attempts + 1
+WHERE id = ?1
+AND user_id = ?2
+AND attempts < ?3
+AND expires_at > ?4
+`
+
+func (s *SQLite) AttemptTwoFactorChallenge(ctx context.Context, arg database.AttemptTwoFactorChallengeParams) (int64, error) {
+	result, err := s.db.ExecContext(ctx, sqliteAttemptTwoFactorChallenge, arg.ID, arg.UserID, arg.MaxAttempts, now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const sqliteDeleteTwoFactorChallenge = `
+DELETE FROM two_factor_challenges
+WHERE id = ?1
+`
+
+func (s *SQLite) DeleteTwoFactorChallenge(ctx context.Context, id string) (int64, error) {
+	result, err := s.db.ExecContext(ctx, sqliteDeleteTwoFactorChallenge, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const sqlitePurgeTwoFactorChallenges = `
+DELETE FROM two_factor_challenges
+WHERE expires_at <= ?1
+`
+
+func (s *SQLite) PurgeTwoFactorChallenges(ctx context.Context) (int64, error) {
+	result, err := s.db.ExecContext(ctx, sqlitePurgeTwoFactorChallenges, now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,131 @@
+package store
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fernando8franco/http-server-golang/internal/database"
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+var ErrUniqueViolation = errors.New("duplicate key value violates unique constraint")
+var ErrForeignKeyViolation = errors.New("insert or update violates foreign key constraint")
+
+// uniqueViolation returns ErrUniqueViolation wrapping err when the database
+// rejected a duplicate key, as Memory does, so callers don't depend on the
+// driver. Other errors are returned unchanged.
+func uniqueViolation(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return fmt.Errorf("%w: %w", ErrUniqueViolation, err)
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() {
+		case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+			return fmt.Errorf("%w: %w", ErrUniqueViolation, err)
+		}
+	}
+
+	return err
+}
+
+const (
+	BackendPostgres = "postgres"
+	BackendSQLite   = "sqlite"
+	BackendMemory   = "memory"
+)
+
+// Backend returns the storage backend selected by the scheme of dbURL.
+func Backend(dbURL string) (string, error) {
+	scheme, _, found := strings.Cut(dbURL, "://")
+	if !found {
+		return "", fmt.Errorf("missing scheme in database url")
+	}
+
+	switch scheme {
+	case "postgres", "postgresql":
+		return BackendPostgres, nil
+	case "sqlite":
+		return BackendSQLite, nil
+	case "memory":
+		return BackendMemory, nil
+	}
+
+	return "", fmt.Errorf("unsupported database scheme %q", scheme)
+}
+
+// Open connects to the backend selected by dbURL. The returned *sql.DB is nil
+// for backends that don't use database/sql, like the in-memory one.
+func Open(dbURL string) (database.Querier, *sql.DB, error) {
+	backend, err := Backend(dbURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch backend {
+	case BackendMemory:
+		return NewMemory(), nil, nil
+	case BackendSQLite:
+		db, err := openSQLite(strings.TrimPrefix(dbURL, "sqlite://"))
+		if err != nil {
+			return nil, nil, err
+		}
+		return NewSQLite(db), db, nil
+	default:
+		db, err := sql.Open("postgres", dbURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		return NewPostgres(db), db, nil
+	}
+}
+
+// SQLite has no built-in SHA-256, which the migration that hashes the stored
+// refresh tokens needs.
+func init() {
+	err := sqlite.RegisterDeterministicScalarFunction("sha256_hex", 1, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		var data []byte
+		switch arg := args[0].(type) {
+		case string:
+			data = []byte(arg)
+		case []byte:
+			data = arg
+		default:
+			return nil, fmt.Errorf("sha256_hex: unsupported argument type %T", arg)
+		}
+
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:]), nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// openSQLite opens the file at path, e.g. sqlite://chirpy.db or
+// sqlite://:memory:. Foreign keys are off by default in SQLite and have to be
+// enabled for the ON DELETE CASCADE clauses to work. Transactions take the
+// write lock when they begin, so one that reads before it writes can't be
+// overtaken by another process.
+func openSQLite(path string) (*sql.DB, error) {
+	dsn := "file:" + path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_time_format=sqlite&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows a single writer, and every connection to :memory: gets
+	// its own database, so everything goes through one connection.
+	db.SetMaxOpenConns(1)
+
+	return db, nil
+}
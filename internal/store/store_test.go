@@ -0,0 +1,1366 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fernando8franco/http-server-golang/internal/auth"
+	"github.com/fernando8franco/http-server-golang/internal/database"
+	"github.com/fernando8franco/http-server-golang/internal/migrate"
+	"github.com/google/uuid"
+)
+
+type testBackend struct {
+	name string
+	q    database.Querier
+	// expire moves the expiry of a refresh token into the past.
+	expire func(token string)
+}
+
+func newTestSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := openSQLite(":memory:")
+	if err != nil {
+		t.Fatalf("openSQLite()\nerror = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := migrate.New(db, migrate.SQLite, os.DirFS("../../sql/sqlite/schema"))
+	if err != nil {
+		t.Fatalf("migrate.New()\nerror = %v", err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("Up()\nerror = %v", err)
+	}
+
+	return db
+}
+
+// newTestPostgres migrates a schema of its own in the database at
+// TEST_POSTGRES_URL, with the session time zone set to timeZone, and drops it
+// after the test. Without the variable the test is skipped.
+func newTestPostgres(t *testing.T, timeZone string) *sql.DB {
+	t.Helper()
+
+	dbURL := os.Getenv("TEST_POSTGRES_URL")
+	if dbURL == "" {
+		t.Skip("TEST_POSTGRES_URL is not set")
+	}
+
+	admin, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		t.Fatalf("sql.Open()\nerror = %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := "test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("CREATE SCHEMA\nerror = %v", err)
+	}
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	// lib/pq sends the parameters it doesn't know as session settings, so
+	// every connection in the pool gets them.
+	u, err := url.Parse(dbURL)
+	if err != nil {
+		t.Fatalf("url.Parse()\nerror = %v", err)
+	}
+	query := u.Query()
+	query.Set("TimeZone", timeZone)
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+
+	db, err := sql.Open("postgres", u.String())
+	if err != nil {
+		t.Fatalf("sql.Open()\nerror = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := migrate.New(db, migrate.Postgres, os.DirFS("../../sql/schema"))
+	if err != nil {
+		t.Fatalf("migrate.New()\nerror = %v", err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("Up()\nerror = %v", err)
+	}
+
+	return db
+}
+
+func testBackends(t *testing.T) []testBackend {
+	t.Helper()
+
+	memory := NewMemory()
+	db := newTestSQLite(t)
+
+	return []testBackend{
+		{
+			"Memory",
+			memory,
+			func(token string) {
+				memory.mu.Lock()
+				defer memory.mu.Unlock()
+				refreshToken := memory.refreshTokens[token]
+				refreshToken.ExpiresAt = now().Add(-time.Minute)
+				memory.refreshTokens[token] = refreshToken
+			},
+		},
+		{
+			"SQLite",
+			NewSQLite(db),
+			func(token string) {
+				db.Exec("UPDATE refresh_tokens SET expires_at = ? WHERE token_hash = ?", now().Add(-time.Minute), token)
+			},
+		},
+	}
+}
+
+func TestUsers(t *testing.T) {
+	ctx := context.Background()
+
+	for _, backend := range testBackends(t) {
+		q := backend.q
+
+		alice, err := q.CreateUser(ctx, database.CreateUserParams{Email: "alice@example.com", HashedPassword: "hash"})
+		if err != nil {
+			t.Fatalf("%s CreateUser()\nerror = %v", backend.name, err)
+		}
+		bob, err := q.CreateUser(ctx, database.CreateUserParams{Email: "bob@example.com", HashedPassword: "hash"})
+		if err != nil {
+			t.Fatalf("%s CreateUser()\nerror = %v", backend.name, err)
+		}
+
+		tests := []struct {
+			name    string
+			run     func() error
+			wantErr bool
+		}{
+			{
+				"Duplicate email on create",
+				func() error {
+					_, err := q.CreateUser(ctx, database.CreateUserParams{Email: "alice@example.com"})
+					if !errors.Is(err, ErrUniqueViolation) {
+						return nil
+					}
+					return err
+				},
+				true,
+			},
+			{
+				"Duplicate email on update",
+				func() error {
+					_, err := q.UpdateUser(ctx, database.UpdateUserParams{ID: bob.ID, Email: "alice@example.com"})
+					if !errors.Is(err, ErrUniqueViolation) {
+						return nil
+					}
+					return err
+				},
+				true,
+			},
+			{
+				"Update keeps own email",
+				func() error {
+					_, err := q.UpdateUser(ctx, database.UpdateUserParams{ID: alice.ID, Email: "alice@example.com"})
+					return err
+				},
+				false,
+			},
+			{
+				"Unknown email",
+				func() error {
+					_, err := q.GetUserByEmail(ctx, "carol@example.com")
+					if !errors.Is(err, sql.ErrNoRows) {
+						return nil
+					}
+					return err
+				},
+				true,
+			},
+			{
+				"Chirp for unknown user",
+				func() error {
+					_, err := q.CreateChirp(ctx, database.CreateChirpParams{Body: "hi", UserID: uuid.New()})
+					return err
+				},
+				true,
+			},
+			{
+				"Upgrade to Chirpy Red",
+				func() error {
+					user, err := q.UpdateUserToChirpyRed(ctx, bob.ID)
+					if err == nil && !user.IsChirpyRed {
+						return errors.New("user was not upgraded")
+					}
+					return err
+				},
+				false,
+			},
+		}
+
+		for _, test := range tests {
+			t.Run(backend.name+"/"+test.name, func(t *testing.T) {
+				err := test.run()
+				if (err != nil) != test.wantErr {
+					t.Errorf("error = %v\nwantErr = %v", err, test.wantErr)
+				}
+			})
+		}
+	}
+}
+
+func TestRefreshTokens(t *testing.T) {
+	ctx := context.Background()
+
+	for _, backend := range testBackends(t) {
+		q := backend.q
+
+		user, _ := q.CreateUser(ctx, database.CreateUserParams{Email: "alice@example.com"})
+		q.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{TokenHash: "valid", UserID: user.ID})
+		q.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{TokenHash: "revoked", UserID: user.ID})
+		q.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{TokenHash: "expired", UserID: user.ID})
+		q.SetRevokedAt(ctx, "revoked")
+		backend.expire("expired")
+
+		tests := []struct {
+			name       string
+			token      string
+			wantUserId uuid.UUID
+			wantErr    bool
+		}{
+			{"Valid token", "valid", user.ID, false},
+			{"Revoked token", "revoked", uuid.Nil, true},
+			{"Expired token", "expired", uuid.Nil, true},
+			{"Unknown token", "unknown", uuid.Nil, true},
+		}
+
+		for _, test := range tests {
+			t.Run(backend.name+"/"+test.name, func(t *testing.T) {
+				userId, err := q.GetUserIdFromRefreshToken(ctx, test.token)
+				if (err != nil) != test.wantErr {
+					t.Errorf("GetUserIdFromRefreshToken()\nerror = %v\nwantErr = %v", err, test.wantErr)
+					return
+				}
+
+				if userId != test.wantUserId {
+					t.Errorf("GetUserIdFromRefreshToken()\nuserId = %v\nwantUserId = %v", userId, test.wantUserId)
+				}
+			})
+		}
+	}
+}
+
+func TestRefreshTokenFamilies(t *testing.T) {
+	ctx := context.Background()
+
+	for _, backend := range testBackends(t) {
+		t.Run(backend.name, func(t *testing.T) {
+			q := backend.q
+
+			user, _ := q.CreateUser(ctx, database.CreateUserParams{Email: "alice@example.com"})
+			family := uuid.New()
+			q.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{TokenHash: "first", UserID: user.ID, FamilyID: family})
+			q.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{TokenHash: "second", UserID: user.ID, FamilyID: family})
+			q.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{TokenHash: "other", UserID: user.ID, FamilyID: uuid.New()})
+
+			if _, err := q.RotateRefreshToken(ctx, database.RotateRefreshTokenParams{TokenHash: "first", NewTokenHash: "third"}); err != nil {
+				t.Errorf("RotateRefreshToken()\nerror = %v", err)
+			}
+			if _, err := q.RotateRefreshToken(ctx, database.RotateRefreshTokenParams{TokenHash: "first", NewTokenHash: "fourth"}); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("RotateRefreshToken() twice\nerror = %v\nwantErr = %v", err, sql.ErrNoRows)
+			}
+			if _, err := q.GetRefreshToken(ctx, "fourth"); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("GetRefreshToken() for the second rotation\nerror = %v\nwantErr = %v", err, sql.ErrNoRows)
+			}
+
+			stored, err := q.GetRefreshToken(ctx, "first")
+			if err != nil || !stored.RevokedAt.Valid || stored.FamilyID != family {
+				t.Errorf("GetRefreshToken()\ntoken = %+v\nerror = %v", stored, err)
+			}
+
+			revoked, err := q.RevokeRefreshTokenFamily(ctx, family)
+			if err != nil || revoked != 2 {
+				t.Errorf("RevokeRefreshTokenFamily()\nrevoked = %d\nwantRevoked = 2\nerror = %v", revoked, err)
+			}
+
+			if _, err := q.GetUserIdFromRefreshToken(ctx, "second"); err == nil {
+				t.Errorf("GetUserIdFromRefreshToken()\nerror = nil\nwantErr = true")
+			}
+			if _, err := q.GetUserIdFromRefreshToken(ctx, "other"); err != nil {
+				t.Errorf("GetUserIdFromRefreshToken()\nerror = %v\nwantErr = false", err)
+			}
+		})
+	}
+}
+
+func TestSessions(t *testing.T) {
+	ctx := context.Background()
+
+	for _, backend := range testBackends(t) {
+		t.Run(backend.name, func(t *testing.T) {
+			q := backend.q
+
+			alice, _ := q.CreateUser(ctx, database.CreateUserParams{Email: "alice@example.com"})
+			bob, _ := q.CreateUser(ctx, database.CreateUserParams{Email: "bob@example.com"})
+			laptop, phone := uuid.New(), uuid.New()
+			q.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{TokenHash: "laptop", UserID: alice.ID, FamilyID: laptop, UserAgent: "curl/8.0", IpAddress: "10.0.0.1"})
+			q.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{TokenHash: "phone", UserID: alice.ID, FamilyID: phone})
+			q.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{TokenHash: "bob", UserID: bob.ID, FamilyID: uuid.New()})
+
+			rotated, err := q.RotateRefreshToken(ctx, database.RotateRefreshTokenParams{NewTokenHash: "laptop-2", TokenHash: "laptop"})
+			if err != nil {
+				t.Fatalf("RotateRefreshToken()\nerror = %v", err)
+			}
+			if rotated.FamilyID != laptop || rotated.UserAgent != "curl/8.0" || rotated.IpAddress != "10.0.0.1" || !rotated.LastUsedAt.Valid {
+				t.Errorf("RotateRefreshToken()\ntoken = %+v\nwant the metadata of the laptop session", rotated)
+			}
+
+			sessions, _ := q.ListSessions(ctx, alice.ID)
+			if len(sessions) != 2 {
+				t.Fatalf("ListSessions()\nlen = %d\nwantLen = 2", len(sessions))
+			}
+
+			tests := []struct {
+				name        string
+				params      database.RevokeSessionParams
+				wantRevoked int64
+			}{
+				{"Other user's session", database.RevokeSessionParams{FamilyID: phone, UserID: bob.ID}, 0},
+				{"Own session", database.RevokeSessionParams{FamilyID: phone, UserID: alice.ID}, 1},
+				{"Already revoked", database.RevokeSessionParams{FamilyID: phone, UserID: alice.ID}, 0},
+			}
+
+			for _, test := range tests {
+				t.Run(test.name, func(t *testing.T) {
+					revoked, err := q.RevokeSession(ctx, test.params)
+					if err != nil || revoked != test.wantRevoked {
+						t.Errorf("RevokeSession()\nrevoked = %d\nwantRevoked = %d\nerror = %v", revoked, test.wantRevoked, err)
+					}
+				})
+			}
+
+			if revoked, _ := q.RevokeAllSessions(ctx, alice.ID); revoked != 1 {
+				t.Errorf("RevokeAllSessions()\nrevoked = %d\nwantRevoked = 1", revoked)
+			}
+			if sessions, _ := q.ListSessions(ctx, alice.ID); len(sessions) != 0 {
+				t.Errorf("ListSessions() after RevokeAllSessions()\nlen = %d\nwantLen = 0", len(sessions))
+			}
+			if sessions, _ := q.ListSessions(ctx, bob.ID); len(sessions) != 1 {
+				t.Errorf("ListSessions() for another user\nlen = %d\nwantLen = 1", len(sessions))
+			}
+		})
+	}
+}
+
+func TestUserProfiles(t *testing.T) {
+	ctx := context.Background()
+
+	for _, backend := range testBackends(t) {
+		t.Run(backend.name, func(t *testing.T) {
+			q := backend.q
+
+			alice, _ := q.CreateUser(ctx, database.CreateUserParams{Email: "alice@example.com", HashedPassword: "hash"})
+			bob, _ := q.CreateUser(ctx, database.CreateUserParams{Email: "bob@example.com"})
+			handle := func(s string) sql.NullString { return sql.NullString{String: s, Valid: true} }
+
+			updated, err := q.PatchUser(ctx, database.PatchUserParams{
+				ID:          alice.ID,
+				Handle:      handle("Alice"),
+				DisplayName: "Alice Liddell",
+				Bio:         "Down the rabbit hole",
+				AvatarUrl:   "https://example.com/alice.png",
+			})
+			if err != nil || updated.Handle != handle("Alice") || updated.DisplayName != "Alice Liddell" || updated.Email != alice.Email || updated.HashedPassword != "hash" {
+				t.Fatalf("PatchUser()\nuser = %+v\nerror = %v", updated, err)
+			}
+
+			tests := []struct {
+				name    string
+				run     func() error
+				wantErr bool
+			}{
+				{
+					"Handle taken in another case",
+					func() error {
+						_, err := q.PatchUser(ctx, database.PatchUserParams{ID: bob.ID, Handle: handle("ALICE")})
+						if !errors.Is(err, ErrUniqueViolation) {
+							return nil
+						}
+						return err
+					},
+					true,
+				},
+				{
+					"Email taken",
+					func() error {
+						_, err := q.PatchUser(ctx, database.PatchUserParams{ID: bob.ID, Email: sql.NullString{String: "alice@example.com", Valid: true}})
+						if !errors.Is(err, ErrUniqueViolation) {
+							return nil
+						}
+						return err
+					},
+					true,
+				},
+				{
+					"Users without a handle",
+					func() error {
+						_, err := q.PatchUser(ctx, database.PatchUserParams{ID: bob.ID, DisplayName: "Bob"})
+						return err
+					},
+					false,
+				},
+				{
+					"Lookup ignores case",
+					func() error {
+						user, err := q.GetUserByHandle(ctx, "aLiCe")
+						if err == nil && user.ID != alice.ID {
+							return errors.New("found another user")
+						}
+						return err
+					},
+					false,
+				},
+				{
+					"Unknown handle",
+					func() error {
+						_, err := q.GetUserByHandle(ctx, "carol")
+						return err
+					},
+					true,
+				},
+			}
+
+			for _, test := range tests {
+				if err := test.run(); (err != nil) != test.wantErr {
+					t.Errorf("%s\nerror = %v\nwantErr = %v", test.name, err, test.wantErr)
+				}
+			}
+
+			authors, err := q.ListChirpAuthors(ctx, []uuid.UUID{alice.ID, bob.ID, alice.ID, uuid.New()})
+			if err != nil || len(authors) != 2 {
+				t.Fatalf("ListChirpAuthors()\nauthors = %+v\nerror = %v", authors, err)
+			}
+			for _, author := range authors {
+				if author.ID == alice.ID && (author.Handle != handle("Alice") || author.AvatarUrl != "https://example.com/alice.png") {
+					t.Errorf("ListChirpAuthors()\nauthor = %+v\nwant Alice's profile", author)
+				}
+			}
+		})
+	}
+}
+
+func TestRevokeOtherSessions(t *testing.T) {
+	ctx := context.Background()
+
+	for _, backend := range testBackends(t) {
+		t.Run(backend.name, func(t *testing.T) {
+			q := backend.q
+
+			alice, _ := q.CreateUser(ctx, database.CreateUserParams{Email: "alice@example.com"})
+			bob, _ := q.CreateUser(ctx, database.CreateUserParams{Email: "bob@example.com"})
+			jti := func(s string) sql.NullString { return sql.NullString{String: s, Valid: true} }
+			q.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{TokenHash: "laptop", UserID: alice.ID, FamilyID: uuid.New(), AccessTokenJti: jti("laptop")})
+			q.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{TokenHash: "phone", UserID: alice.ID, FamilyID: uuid.New(), AccessTokenJti: jti("phone")})
+			q.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{TokenHash: "tablet", UserID: alice.ID, FamilyID: uuid.New()})
+			q.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{TokenHash: "bob", UserID: bob.ID, FamilyID: uuid.New(), AccessTokenJti: jti("bob")})
+
+			tests := []struct {
+				name         string
+				params       database.RevokeOtherSessionsParams
+				wantRevoked  int64
+				wantSessions int
+			}{
+				{"Keeps the current session", database.RevokeOtherSessionsParams{UserID: alice.ID, AccessTokenJti: "laptop"}, 2, 1},
+				{"Another user's access token", database.RevokeOtherSessionsParams{UserID: alice.ID, AccessTokenJti: "bob"}, 1, 0},
+			}
+
+			for _, test := range tests {
+				revoked, err := q.RevokeOtherSessions(ctx, test.params)
+				if err != nil || revoked != test.wantRevoked {
+					t.Errorf("RevokeOtherSessions() %s\nrevoked = %d\nwantRevoked = %d\nerror = %v", test.name, revoked, test.wantRevoked, err)
+				}
+				if sessions, _ := q.ListSessions(ctx, alice.ID); len(sessions) != test.wantSessions {
+					t.Errorf("ListSessions() after RevokeOtherSessions() %s\nlen = %d\nwantLen = %d", test.name, len(sessions), test.wantSessions)
+				}
+			}
+
+			if sessions, _ := q.ListSessions(ctx, bob.ID); len(sessions) != 1 {
+				t.Errorf("ListSessions() for another user\nlen = %d\nwantLen = 1", len(sessions))
+			}
+		})
+	}
+}
+
+func TestDeniedAccessTokens(t *testing.T) {
+	ctx := context.Background()
+
+	for _, backend := range testBackends(t) {
+		t.Run(backend.name, func(t *testing.T) {
+			q := backend.q
+
+			alice, _ := q.CreateUser(ctx, database.CreateUserParams{Email: "alice@example.com"})
+			laptop := uuid.New()
+			jti := func(id string) sql.NullString { return sql.NullString{String: id, Valid: true} }
+			q.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{TokenHash: "laptop", UserID: alice.ID, FamilyID: laptop, AccessTokenJti: jti("laptop-jti")})
+			q.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{TokenHash: "phone", UserID: alice.ID, FamilyID: uuid.New(), AccessTokenJti: jti("phone-jti")})
+			q.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{TokenHash: "tablet", UserID: alice.ID, FamilyID: uuid.New(), AccessTokenJti: jti("tablet-jti")})
+
+			hour := time.Hour.Seconds()
+			denied, err := q.DenyFamilyAccessTokens(ctx, database.DenyFamilyAccessTokensParams{
+				ExpiresInSeconds:    hour,
+				FamilyID:            laptop,
+				IssuedWithinSeconds: hour,
+			})
+			if err != nil || denied != 1 {
+				t.Errorf("DenyFamilyAccessTokens()\ndenied = %d\nwantDenied = 1\nerror = %v", denied, err)
+			}
+
+			denied, err = q.DenyUserAccessTokens(ctx, database.DenyUserAccessTokensParams{
+				ExpiresInSeconds:    hour,
+				UserID:              alice.ID,
+				IssuedWithinSeconds: hour,
+				ExceptJti:           "phone-jti",
+			})
+			if err != nil || denied != 1 {
+				t.Errorf("DenyUserAccessTokens()\ndenied = %d\nwantDenied = 1\nerror = %v", denied, err)
+			}
+
+			tests := []struct {
+				jti        string
+				wantDenied bool
+			}{
+				{"laptop-jti", true},
+				{"tablet-jti", true},
+				{"phone-jti", false},
+				{"unknown", false},
+			}
+
+			for _, test := range tests {
+				t.Run(test.jti, func(t *testing.T) {
+					denied, err := q.IsAccessTokenDenied(ctx, test.jti)
+					if err != nil || denied != test.wantDenied {
+						t.Errorf("IsAccessTokenDenied()\ndenied = %v\nwantDenied = %v\nerror = %v", denied, test.wantDenied, err)
+					}
+				})
+			}
+
+			// Entries that expired no longer deny anything and get purged.
+			q.DenyUserAccessTokens(ctx, database.DenyUserAccessTokensParams{
+				ExpiresInSeconds:    -time.Minute.Seconds(),
+				UserID:              alice.ID,
+				IssuedWithinSeconds: hour,
+			})
+			if denied, _ := q.IsAccessTokenDenied(ctx, "phone-jti"); denied {
+				t.Errorf("IsAccessTokenDenied() of an expired entry\ndenied = true\nwantDenied = false")
+			}
+			if purged, err := q.PurgeDeniedAccessTokens(ctx); err != nil || purged != 1 {
+				t.Errorf("PurgeDeniedAccessTokens()\npurged = %d\nwantPurged = 1\nerror = %v", purged, err)
+			}
+		})
+	}
+}
+
+// Postgres compares the timestamp columns on the session clock, so the
+// queries must not mix in times computed in Go, which are in UTC.
+func TestPostgresAwayFromUTC(t *testing.T) {
+	ctx := context.Background()
+
+	for _, timeZone := range []string{"Pacific/Kiritimati", "Etc/GMT+12"} {
+		t.Run(timeZone, func(t *testing.T) {
+			q := NewPostgres(newTestPostgres(t, timeZone))
+
+			alice, err := q.CreateUser(ctx, database.CreateUserParams{Email: "alice@example.com"})
+			if err != nil {
+				t.Fatalf("CreateUser()\nerror = %v", err)
+			}
+			laptop := uuid.New()
+			jti := func(id string) sql.NullString { return sql.NullString{String: id, Valid: true} }
+			q.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{TokenHash: "laptop", UserID: alice.ID, FamilyID: laptop, AccessTokenJti: jti("laptop-jti")})
+			q.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{TokenHash: "phone", UserID: alice.ID, FamilyID: uuid.New(), AccessTokenJti: jti("phone-jti")})
+
+			hour := time.Hour.Seconds()
+			denied, err := q.DenyFamilyAccessTokens(ctx, database.DenyFamilyAccessTokensParams{
+				ExpiresInSeconds:    hour,
+				FamilyID:            laptop,
+				IssuedWithinSeconds: hour,
+			})
+			if err != nil || denied != 1 {
+				t.Errorf("DenyFamilyAccessTokens()\ndenied = %d\nwantDenied = 1\nerror = %v", denied, err)
+			}
+			denied, err = q.DenyUserAccessTokens(ctx, database.DenyUserAccessTokensParams{
+				ExpiresInSeconds:    hour,
+				UserID:              alice.ID,
+				IssuedWithinSeconds: hour,
+			})
+			if err != nil || denied != 1 {
+				t.Errorf("DenyUserAccessTokens()\ndenied = %d\nwantDenied = 1\nerror = %v", denied, err)
+			}
+			for _, id := range []string{"laptop-jti", "phone-jti"} {
+				if denied, err := q.IsAccessTokenDenied(ctx, id); err != nil || !denied {
+					t.Errorf("IsAccessTokenDenied(%q)\ndenied = %v\nwantDenied = true\nerror = %v", id, denied, err)
+				}
+			}
+			if purged, err := q.PurgeDeniedAccessTokens(ctx); err != nil || purged != 0 {
+				t.Errorf("PurgeDeniedAccessTokens()\npurged = %d\nwantPurged = 0\nerror = %v", purged, err)
+			}
+
+			for _, test := range []struct {
+				name      string
+				expiresIn time.Duration
+				wantErr   bool
+			}{
+				{"valid", time.Hour, false},
+				{"expired", -time.Minute, true},
+			} {
+				q.CreatePersonalAccessToken(ctx, database.CreatePersonalAccessTokenParams{
+					UserID:    alice.ID,
+					Name:      test.name,
+					TokenHash: test.name,
+					Scopes:    "chirps:read",
+					ExpiresAt: sql.NullTime{Time: now().Add(test.expiresIn), Valid: true},
+				})
+				_, err := q.UsePersonalAccessToken(ctx, database.UsePersonalAccessTokenParams{Now: now(), TokenHash: test.name})
+				if (err != nil) != test.wantErr {
+					t.Errorf("UsePersonalAccessToken() of the %s token\nerror = %v\nwantErr = %v", test.name, err, test.wantErr)
+				}
+			}
+
+			q.CreateTwoFactorChallenge(ctx, database.CreateTwoFactorChallengeParams{ID: "live", UserID: alice.ID, ExpiresInSeconds: time.Minute.Seconds()})
+			q.CreateTwoFactorChallenge(ctx, database.CreateTwoFactorChallengeParams{ID: "expired", UserID: alice.ID, ExpiresInSeconds: -time.Minute.Seconds()})
+			for id, want := range map[string]int64{"live": 1, "expired": 0} {
+				attempted, err := q.AttemptTwoFactorChallenge(ctx, database.AttemptTwoFactorChallengeParams{ID: id, UserID: alice.ID, MaxAttempts: 5})
+				if err != nil || attempted != want {
+					t.Errorf("AttemptTwoFactorChallenge(%q)\nattempted = %d\nwantAttempted = %d\nerror = %v", id, attempted, want, err)
+				}
+			}
+
+			reset := database.CreatePasswordResetTokenParams{TokenHash: "reset", UserID: alice.ID, ExpiresInSeconds: hour, WindowSeconds: hour, MaxTokens: 1}
+			if _, err := q.CreatePasswordResetToken(ctx, reset); err != nil {
+				t.Errorf("CreatePasswordResetToken()\nerror = %v", err)
+			}
+			if _, err := q.GetPasswordResetTokenEmail(ctx, "reset"); err != nil {
+				t.Errorf("GetPasswordResetTokenEmail()\nerror = %v", err)
+			}
+			reset.TokenHash = "over the limit"
+			if _, err := q.CreatePasswordResetToken(ctx, reset); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("CreatePasswordResetToken() over the limit\nerror = %v\nwantErr = %v", err, sql.ErrNoRows)
+			}
+		})
+	}
+}
+
+func TestPersonalAccessTokens(t *testing.T) {
+	ctx := context.Background()
+
+	for _, backend := range testBackends(t) {
+		t.Run(backend.name, func(t *testing.T) {
+			q := backend.q
+
+			alice, _ := q.CreateUser(ctx, database.CreateUserParams{Email: "alice@example.com"})
+			bob, _ := q.CreateUser(ctx, database.CreateUserParams{Email: "bob@example.com"})
+
+			bot, err := q.CreatePersonalAccessToken(ctx, database.CreatePersonalAccessTokenParams{
+				UserID:    alice.ID,
+				Name:      "bot",
+				TokenHash: "bot",
+				Scopes:    "chirps:read chirps:write",
+			})
+			if err != nil || bot.Name != "bot" || bot.Scopes != "chirps:read chirps:write" || bot.ExpiresAt.Valid {
+				t.Fatalf("CreatePersonalAccessToken()\ntoken = %+v\nerror = %v", bot, err)
+			}
+			q.CreatePersonalAccessToken(ctx, database.CreatePersonalAccessTokenParams{
+				UserID:    alice.ID,
+				Name:      "expired",
+				TokenHash: "expired",
+				Scopes:    "chirps:read",
+				ExpiresAt: sql.NullTime{Time: now().Add(-time.Minute), Valid: true},
+			})
+
+			if _, err := q.CreatePersonalAccessToken(ctx, database.CreatePersonalAccessTokenParams{UserID: bob.ID, Name: "copy", TokenHash: "bot"}); err == nil {
+				t.Errorf("CreatePersonalAccessToken() with a duplicate hash\nerror = nil\nwantErr = true")
+			}
+
+			if tokens, _ := q.ListPersonalAccessTokens(ctx, alice.ID); len(tokens) != 2 {
+				t.Errorf("ListPersonalAccessTokens()\nlen = %d\nwantLen = 2", len(tokens))
+			}
+
+			used, err := q.UsePersonalAccessToken(ctx, database.UsePersonalAccessTokenParams{Now: now(), TokenHash: "bot"})
+			if err != nil || used.ID != bot.ID || !used.LastUsedAt.Valid {
+				t.Errorf("UsePersonalAccessToken()\ntoken = %+v\nerror = %v", used, err)
+			}
+			if _, err := q.UsePersonalAccessToken(ctx, database.UsePersonalAccessTokenParams{Now: now(), TokenHash: "expired"}); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("UsePersonalAccessToken() of an expired token\nerror = %v\nwantErr = %v", err, sql.ErrNoRows)
+			}
+
+			tests := []struct {
+				name        string
+				params      database.DeletePersonalAccessTokenParams
+				wantDeleted int64
+			}{
+				{"Other user's token", database.DeletePersonalAccessTokenParams{ID: bot.ID, UserID: bob.ID}, 0},
+				{"Own token", database.DeletePersonalAccessTokenParams{ID: bot.ID, UserID: alice.ID}, 1},
+				{"Already deleted", database.DeletePersonalAccessTokenParams{ID: bot.ID, UserID: alice.ID}, 0},
+			}
+
+			for _, test := range tests {
+				t.Run(test.name, func(t *testing.T) {
+					deleted, err := q.DeletePersonalAccessToken(ctx, test.params)
+					if err != nil || deleted != test.wantDeleted {
+						t.Errorf("DeletePersonalAccessToken()\ndeleted = %d\nwantDeleted = %d\nerror = %v", deleted, test.wantDeleted, err)
+					}
+				})
+			}
+
+			if _, err := q.UsePersonalAccessToken(ctx, database.UsePersonalAccessTokenParams{Now: now(), TokenHash: "bot"}); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("UsePersonalAccessToken() of a deleted token\nerror = %v\nwantErr = %v", err, sql.ErrNoRows)
+			}
+		})
+	}
+}
+
+func TestTwoFactor(t *testing.T) {
+	ctx := context.Background()
+
+	for _, backend := range testBackends(t) {
+		t.Run(backend.name, func(t *testing.T) {
+			q := backend.q
+
+			alice, _ := q.CreateUser(ctx, database.CreateUserParams{Email: "alice@example.com"})
+
+			if _, err := q.EnableUserTOTP(ctx, alice.ID); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("EnableUserTOTP() without a secret\nerror = %v\nwantErr = %v", err, sql.ErrNoRows)
+			}
+
+			user, err := q.SetUserTOTPSecret(ctx, database.SetUserTOTPSecretParams{
+				ID:         alice.ID,
+				TotpSecret: sql.NullString{String: "secret", Valid: true},
+			})
+			if err != nil || user.TotpSecret.String != "secret" || user.TotpEnabledAt.Valid {
+				t.Errorf("SetUserTOTPSecret()\nuser = %+v\nerror = %v", user, err)
+			}
+
+			user, err = q.EnableUserTOTP(ctx, alice.ID)
+			if err != nil || !user.TotpEnabledAt.Valid {
+				t.Errorf("EnableUserTOTP()\nuser = %+v\nerror = %v", user, err)
+			}
+
+			steps := []struct {
+				step     int64
+				wantUsed int64
+			}{
+				{100, 1},
+				{100, 0},
+				{99, 0},
+				{101, 1},
+			}
+			for _, test := range steps {
+				used, err := q.UseTOTPStep(ctx, database.UseTOTPStepParams{Step: test.step, ID: alice.ID})
+				if err != nil || used != test.wantUsed {
+					t.Errorf("UseTOTPStep(%d)\nused = %d\nwantUsed = %d\nerror = %v", test.step, used, test.wantUsed, err)
+				}
+			}
+
+			q.CreateRecoveryCode(ctx, database.CreateRecoveryCodeParams{UserID: alice.ID, CodeHash: "first"})
+			q.CreateRecoveryCode(ctx, database.CreateRecoveryCodeParams{UserID: alice.ID, CodeHash: "second"})
+
+			codes := []struct {
+				codeHash string
+				wantUsed int64
+			}{
+				{"first", 1},
+				{"first", 0},
+				{"unknown", 0},
+			}
+			for _, test := range codes {
+				used, err := q.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{UserID: alice.ID, CodeHash: test.codeHash})
+				if err != nil || used != test.wantUsed {
+					t.Errorf("UseRecoveryCode(%s)\nused = %d\nwantUsed = %d\nerror = %v", test.codeHash, used, test.wantUsed, err)
+				}
+			}
+
+			q.DeleteRecoveryCodes(ctx, alice.ID)
+			if used, _ := q.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{UserID: alice.ID, CodeHash: "second"}); used != 0 {
+				t.Errorf("UseRecoveryCode() after DeleteRecoveryCodes()\nused = %d\nwantUsed = 0", used)
+			}
+		})
+	}
+}
+
+func TestTwoFactorChallenges(t *testing.T) {
+	ctx := context.Background()
+
+	for _, backend := range testBackends(t) {
+		t.Run(backend.name, func(t *testing.T) {
+			q := backend.q
+
+			alice, _ := q.CreateUser(ctx, database.CreateUserParams{Email: "alice@example.com"})
+			q.CreateTwoFactorChallenge(ctx, database.CreateTwoFactorChallengeParams{ID: "live", UserID: alice.ID, ExpiresInSeconds: time.Minute.Seconds()})
+			q.CreateTwoFactorChallenge(ctx, database.CreateTwoFactorChallengeParams{ID: "expired", UserID: alice.ID, ExpiresInSeconds: -time.Minute.Seconds()})
+
+			attempts := []struct {
+				id            string
+				userID        uuid.UUID
+				wantAttempted int64
+			}{
+				{"live", alice.ID, 1},
+				{"live", uuid.New(), 0},
+				{"live", alice.ID, 1},
+				{"live", alice.ID, 0},
+				{"expired", alice.ID, 0},
+				{"unknown", alice.ID, 0},
+			}
+			for _, test := range attempts {
+				attempted, err := q.AttemptTwoFactorChallenge(ctx, database.AttemptTwoFactorChallengeParams{ID: test.id, UserID: test.userID, MaxAttempts: 2})
+				if err != nil || attempted != test.wantAttempted {
+					t.Errorf("AttemptTwoFactorChallenge(%s)\nattempted = %d\nwantAttempted = %d\nerror = %v", test.id, attempted, test.wantAttempted, err)
+				}
+			}
+
+			if purged, err := q.PurgeTwoFactorChallenges(ctx); err != nil || purged != 1 {
+				t.Errorf("PurgeTwoFactorChallenges()\npurged = %d\nwantPurged = 1\nerror = %v", purged, err)
+			}
+			if deleted, err := q.DeleteTwoFactorChallenge(ctx, "live"); err != nil || deleted != 1 {
+				t.Errorf("DeleteTwoFactorChallenge()\ndeleted = %d\nwantDeleted = 1\nerror = %v", deleted, err)
+			}
+			if deleted, _ := q.DeleteTwoFactorChallenge(ctx, "live"); deleted != 0 {
+				t.Errorf("DeleteTwoFactorChallenge() twice\ndeleted = %d\nwantDeleted = 0", deleted)
+			}
+		})
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	ctx := context.Background()
+
+	for _, backend := range testBackends(t) {
+		t.Run(backend.name, func(t *testing.T) {
+			q := backend.q
+
+			alice, _ := q.CreateUser(ctx, database.CreateUserParams{Email: "alice@example.com"})
+			if alice.EmailVerifiedAt.Valid {
+				t.Errorf("CreateUser()\nemailVerifiedAt = %v\nwant unverified", alice.EmailVerifiedAt)
+			}
+
+			_, err := q.VerifyUserEmail(ctx, database.VerifyUserEmailParams{ID: alice.ID, Email: "old@example.com"})
+			if !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("VerifyUserEmail() with another email\nerror = %v\nwantErr = %v", err, sql.ErrNoRows)
+			}
+
+			user, err := q.VerifyUserEmail(ctx, database.VerifyUserEmailParams{ID: alice.ID, Email: "alice@example.com"})
+			if err != nil || !user.EmailVerifiedAt.Valid {
+				t.Fatalf("VerifyUserEmail()\nuser = %+v\nerror = %v", user, err)
+			}
+
+			again, err := q.VerifyUserEmail(ctx, database.VerifyUserEmailParams{ID: alice.ID, Email: "alice@example.com"})
+			if err != nil || !again.EmailVerifiedAt.Time.Equal(user.EmailVerifiedAt.Time) {
+				t.Errorf("VerifyUserEmail() twice\nemailVerifiedAt = %v\nwantEmailVerifiedAt = %v\nerror = %v", again.EmailVerifiedAt, user.EmailVerifiedAt, err)
+			}
+
+			user, _ = q.UpdateUser(ctx, database.UpdateUserParams{Email: "alice@example.com", HashedPassword: "new", ID: alice.ID})
+			if !user.EmailVerifiedAt.Valid {
+				t.Errorf("UpdateUser() with the same email\nemailVerifiedAt = %v\nwant verified", user.EmailVerifiedAt)
+			}
+
+			user, _ = q.UpdateUser(ctx, database.UpdateUserParams{Email: "alice@example.org", HashedPassword: "new", ID: alice.ID})
+			if user.EmailVerifiedAt.Valid {
+				t.Errorf("UpdateUser() with a new email\nemailVerifiedAt = %v\nwant unverified", user.EmailVerifiedAt)
+			}
+
+			user, _ = q.VerifyUserEmail(ctx, database.VerifyUserEmailParams{ID: alice.ID, Email: "alice@example.org"})
+			user, _ = q.PatchUser(ctx, database.PatchUserParams{HashedPassword: sql.NullString{String: "newer", Valid: true}, ID: alice.ID})
+			if !user.EmailVerifiedAt.Valid || user.Email != "alice@example.org" || user.HashedPassword != "newer" {
+				t.Errorf("PatchUser() without an email\nuser = %+v\nwant the email kept and verified", user)
+			}
+
+			user, _ = q.PatchUser(ctx, database.PatchUserParams{Email: sql.NullString{String: "alice@example.net", Valid: true}, ID: alice.ID})
+			if user.EmailVerifiedAt.Valid || user.Email != "alice@example.net" || user.HashedPassword != "newer" {
+				t.Errorf("PatchUser() with a new email\nuser = %+v\nwant the new email unverified", user)
+			}
+		})
+	}
+}
+
+func TestPasswordResetTokens(t *testing.T) {
+	ctx := context.Background()
+
+	for _, backend := range testBackends(t) {
+		t.Run(backend.name, func(t *testing.T) {
+			q := backend.q
+
+			alice, _ := q.CreateUser(ctx, database.CreateUserParams{Email: "alice@example.com"})
+			create := func(tokenHash string, userID uuid.UUID, expiresIn time.Duration) error {
+				_, err := q.CreatePasswordResetToken(ctx, database.CreatePasswordResetTokenParams{
+					TokenHash:        tokenHash,
+					UserID:           userID,
+					ExpiresInSeconds: expiresIn.Seconds(),
+					WindowSeconds:    time.Hour.Seconds(),
+					MaxTokens:        2,
+				})
+				return err
+			}
+
+			create("valid", alice.ID, time.Hour)
+			create("expired", alice.ID, -time.Minute)
+
+			if err := create("orphan", uuid.New(), time.Hour); err == nil {
+				t.Errorf("CreatePasswordResetToken() for a missing user\nerror = %v\nwant an error", err)
+			}
+			if err := create("limited", alice.ID, time.Hour); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("CreatePasswordResetToken() over the limit\nerror = %v\nwantErr = %v", err, sql.ErrNoRows)
+			}
+
+			if email, err := q.GetPasswordResetTokenEmail(ctx, "valid"); err != nil || email != alice.Email {
+				t.Errorf("GetPasswordResetTokenEmail()\nemail = %v\nwantEmail = %v\nerror = %v", email, alice.Email, err)
+			}
+			if _, err := q.GetPasswordResetTokenEmail(ctx, "expired"); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("GetPasswordResetTokenEmail() of an expired token\nerror = %v\nwantErr = %v", err, sql.ErrNoRows)
+			}
+
+			tests := []struct {
+				tokenHash  string
+				wantUserID uuid.UUID
+				wantErr    error
+			}{
+				{"valid", alice.ID, nil},
+				{"valid", uuid.Nil, sql.ErrNoRows},
+				{"expired", uuid.Nil, sql.ErrNoRows},
+				{"unknown", uuid.Nil, sql.ErrNoRows},
+			}
+			for _, test := range tests {
+				userID, err := q.UsePasswordResetToken(ctx, test.tokenHash)
+				if !errors.Is(err, test.wantErr) || (err == nil && userID != test.wantUserID) {
+					t.Errorf("UsePasswordResetToken(%s)\nuserID = %v\nwantUserID = %v\nerror = %v\nwantErr = %v", test.tokenHash, userID, test.wantUserID, err, test.wantErr)
+				}
+			}
+			if _, err := q.GetPasswordResetTokenEmail(ctx, "valid"); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("GetPasswordResetTokenEmail() of a used token\nerror = %v\nwantErr = %v", err, sql.ErrNoRows)
+			}
+
+			q.DeletePasswordResetTokens(ctx, alice.ID)
+			if err := create("after delete", alice.ID, time.Hour); err != nil {
+				t.Errorf("CreatePasswordResetToken() after DeletePasswordResetTokens()\nerror = %v", err)
+			}
+
+			// Concurrent requests take the one token left under the limit.
+			var created atomic.Int32
+			var wg sync.WaitGroup
+			for i := range 5 {
+				wg.Go(func() {
+					if create(fmt.Sprintf("concurrent %d", i), alice.ID, time.Hour) == nil {
+						created.Add(1)
+					}
+				})
+			}
+			wg.Wait()
+			if created.Load() != 1 {
+				t.Errorf("CreatePasswordResetToken() concurrently\ncreated = %d\nwantCreated = 1", created.Load())
+			}
+
+			if err := q.UpdateUserPassword(ctx, database.UpdateUserPasswordParams{ID: alice.ID, HashedPassword: "new"}); err != nil {
+				t.Errorf("UpdateUserPassword()\nerror = %v", err)
+			}
+			if user, _ := q.GetUserById(ctx, alice.ID); user.HashedPassword != "new" {
+				t.Errorf("UpdateUserPassword()\nhashedPassword = %v\nwantHashedPassword = new", user.HashedPassword)
+			}
+		})
+	}
+}
+
+func TestRehashUserPassword(t *testing.T) {
+	ctx := context.Background()
+
+	for _, backend := range testBackends(t) {
+		t.Run(backend.name, func(t *testing.T) {
+			q := backend.q
+
+			alice, _ := q.CreateUser(ctx, database.CreateUserParams{Email: "alice@example.com", HashedPassword: "old"})
+
+			tests := []struct {
+				name         string
+				oldHash      string
+				newHash      string
+				wantPassword string
+			}{
+				{"Stale old hash", "other", "rehashed", "old"},
+				{"Matching old hash", "old", "rehashed", "rehashed"},
+			}
+			for _, test := range tests {
+				err := q.RehashUserPassword(ctx, database.RehashUserPasswordParams{
+					HashedPassword:    test.newHash,
+					ID:                alice.ID,
+					OldHashedPassword: test.oldHash,
+				})
+				user, _ := q.GetUserById(ctx, alice.ID)
+				if err != nil || user.HashedPassword != test.wantPassword {
+					t.Errorf("RehashUserPassword() %s\nhashedPassword = %v\nwantHashedPassword = %v\nerror = %v", test.name, user.HashedPassword, test.wantPassword, err)
+				}
+				if !user.UpdatedAt.Equal(alice.UpdatedAt) {
+					t.Errorf("RehashUserPassword() %s\nupdatedAt = %v\nwantUpdatedAt = %v", test.name, user.UpdatedAt, alice.UpdatedAt)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteUsersCascades(t *testing.T) {
+	ctx := context.Background()
+
+	for _, backend := range testBackends(t) {
+		t.Run(backend.name, func(t *testing.T) {
+			q := backend.q
+
+			user, _ := q.CreateUser(ctx, database.CreateUserParams{Email: "alice@example.com"})
+			q.CreateChirp(ctx, database.CreateChirpParams{Body: "hi", UserID: user.ID})
+			q.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{TokenHash: "token", UserID: user.ID})
+
+			if err := q.DeleteUsers(ctx); err != nil {
+				t.Fatalf("DeleteUsers()\nerror = %v", err)
+			}
+
+			chirps, _ := q.ListChirps(ctx, database.ListChirpsParams{PageSize: 10})
+			if len(chirps) != 0 {
+				t.Errorf("ListChirps()\nlen = %d\nwantLen = 0", len(chirps))
+			}
+			if _, err := q.GetUserIdFromRefreshToken(ctx, "token"); err == nil {
+				t.Errorf("GetUserIdFromRefreshToken()\nerror = nil\nwantErr = true")
+			}
+		})
+	}
+}
+
+func TestSQLiteMigrationsRollBack(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	migrator, _ := migrate.New(db, migrate.SQLite, os.DirFS("../../sql/sqlite/schema"))
+	for range migrator.Migrations() {
+		if _, err := migrator.Down(ctx); err != nil {
+			t.Fatalf("Down()\nerror = %v", err)
+		}
+	}
+
+	pending, err := migrator.Pending(ctx)
+	if err != nil || pending != len(migrator.Migrations()) {
+		t.Fatalf("Pending()\npending = %d\nerror = %v", pending, err)
+	}
+
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("Up()\nerror = %v", err)
+	}
+}
+
+func TestSQLiteRefreshTokenHashMigration(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+	q := NewSQLite(db)
+	user, _ := q.CreateUser(ctx, database.CreateUserParams{Email: "alice@example.com"})
+
+	// Roll back to just before 013_refresh_token_hashes.
+	migrator, _ := migrate.New(db, migrate.SQLite, os.DirFS("../../sql/sqlite/schema"))
+	for {
+		migration, err := migrator.Down(ctx)
+		if err != nil {
+			t.Fatalf("Down()\nerror = %v", err)
+		}
+		if migration.Version == 13 {
+			break
+		}
+	}
+
+	token := auth.MakeRefreshToken()
+	_, err := db.Exec(
+		"INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, family_id) VALUES (?1, ?2, ?2, ?3, ?4, ?5)",
+		token, now(), user.ID, now().Add(time.Hour), uuid.New(),
+	)
+	if err != nil {
+		t.Fatalf("INSERT INTO refresh_tokens\nerror = %v", err)
+	}
+
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("Up()\nerror = %v", err)
+	}
+
+	userId, err := q.GetUserIdFromRefreshToken(ctx, auth.HashRefreshToken(token))
+	if err != nil || userId != user.ID {
+		t.Errorf("GetUserIdFromRefreshToken()\nuserId = %v\nwantUserId = %v\nerror = %v", userId, user.ID, err)
+	}
+	if _, err := q.GetUserIdFromRefreshToken(ctx, token); err == nil {
+		t.Errorf("GetUserIdFromRefreshToken() with the raw token\nerror = nil\nwantErr = true")
+	}
+}
+
+func TestListChirps(t *testing.T) {
+	ctx := context.Background()
+
+	for _, backend := range testBackends(t) {
+		t.Run(backend.name, func(t *testing.T) {
+			q := backend.q
+
+			user, _ := q.CreateUser(ctx, database.CreateUserParams{Email: "alice@example.com"})
+			for range 5 {
+				q.CreateChirp(ctx, database.CreateChirpParams{Body: "hi", UserID: user.ID})
+			}
+
+			all, _ := q.ListChirps(ctx, database.ListChirpsParams{PageSize: 10})
+			seen := []database.Chirp{}
+			params := database.ListChirpsParams{PageSize: 2}
+			for {
+				page, err := q.ListChirps(ctx, params)
+				if err != nil {
+					t.Fatalf("ListChirps()\nerror = %v", err)
+				}
+				if len(page) == 0 {
+					break
+				}
+				seen = append(seen, page...)
+
+				last := page[len(page)-1]
+				params.AfterCreatedAt = sql.NullTime{Time: last.CreatedAt, Valid: true}
+				params.AfterID = uuid.NullUUID{UUID: last.ID, Valid: true}
+			}
+
+			if len(seen) != len(all) {
+				t.Fatalf("ListChirps()\nlen = %d\nwantLen = %d", len(seen), len(all))
+			}
+			for i := range all {
+				if seen[i].ID != all[i].ID {
+					t.Errorf("ListChirps()\nid[%d] = %v\nwantId = %v", i, seen[i].ID, all[i].ID)
+				}
+			}
+
+			desc, err := q.ListChirpsDesc(ctx, database.ListChirpsDescParams{
+				AfterCreatedAt: sql.NullTime{Time: all[4].CreatedAt, Valid: true},
+				AfterID:        uuid.NullUUID{UUID: all[4].ID, Valid: true},
+				AuthorID:       uuid.NullUUID{UUID: user.ID, Valid: true},
+				Since:          sql.NullTime{Time: all[1].CreatedAt, Valid: true},
+				PageSize:       10,
+			})
+			if err != nil {
+				t.Fatalf("ListChirpsDesc()\nerror = %v", err)
+			}
+			if len(desc) != 3 || desc[0].ID != all[3].ID || desc[2].ID != all[1].ID {
+				t.Errorf("ListChirpsDesc()\nchirps = %v\nwant all[3] to all[1]", desc)
+			}
+		})
+	}
+}
+
+func TestSearchChirps(t *testing.T) {
+	ctx := context.Background()
+
+	for _, backend := range testBackends(t) {
+		t.Run(backend.name, func(t *testing.T) {
+			q := backend.q
+
+			alice, _ := q.CreateUser(ctx, database.CreateUserParams{Email: "alice@example.com"})
+			bob, _ := q.CreateUser(ctx, database.CreateUserParams{Email: "bob@example.com"})
+			q.CreateChirp(ctx, database.CreateChirpParams{Body: "hello world", UserID: alice.ID})
+			q.CreateChirp(ctx, database.CreateChirpParams{Body: "goodbye world", UserID: bob.ID})
+			q.CreateChirp(ctx, database.CreateChirpParams{Body: "world of chirps", UserID: alice.ID})
+			q.CreateChirp(ctx, database.CreateChirpParams{Body: "nothing to see", UserID: alice.ID})
+
+			tests := []struct {
+				name      string
+				params    database.SearchChirpsParams
+				wantCount int
+			}{
+				{"Word", database.SearchChirpsParams{Query: "world"}, 3},
+				{"Prefix", database.SearchChirpsParams{Query: "chir*"}, 1},
+				{"Phrase", database.SearchChirpsParams{Query: `"hello world"`}, 1},
+				{"By author", database.SearchChirpsParams{Query: "world", AuthorID: uuid.NullUUID{UUID: bob.ID, Valid: true}}, 1},
+				{"No match", database.SearchChirpsParams{Query: "moon"}, 0},
+			}
+
+			for _, test := range tests {
+				t.Run(test.name, func(t *testing.T) {
+					test.params.PageSize = 10
+					rows, err := q.SearchChirps(ctx, test.params)
+					if err != nil {
+						t.Fatalf("SearchChirps()\nerror = %v", err)
+					}
+					if len(rows) != test.wantCount {
+						t.Errorf("SearchChirps()\nlen = %d\nwantLen = %d", len(rows), test.wantCount)
+					}
+				})
+			}
+
+			seen := map[uuid.UUID]struct{}{}
+			params := database.SearchChirpsParams{Query: "world", PageSize: 1}
+			for {
+				rows, err := q.SearchChirps(ctx, params)
+				if err != nil {
+					t.Fatalf("SearchChirps()\nerror = %v", err)
+				}
+				if len(rows) == 0 {
+					break
+				}
+
+				last := rows[0]
+				if _, ok := seen[last.ID]; ok {
+					t.Fatalf("SearchChirps()\nchirp %v returned twice", last.ID)
+				}
+				seen[last.ID] = struct{}{}
+
+				params.AfterRank = sql.NullFloat64{Float64: last.Rank, Valid: true}
+				params.AfterCreatedAt = sql.NullTime{Time: last.CreatedAt, Valid: true}
+				params.AfterID = uuid.NullUUID{UUID: last.ID, Valid: true}
+			}
+			if len(seen) != 3 {
+				t.Errorf("SearchChirps()\npaged = %d\nwantPaged = 3", len(seen))
+			}
+
+			// Every backend escapes the chirp before highlighting it.
+			q.CreateChirp(ctx, database.CreateChirpParams{Body: `<img src=x onerror="alert(1)"> markup`, UserID: bob.ID})
+			rows, err := q.SearchChirps(ctx, database.SearchChirpsParams{Query: "markup", PageSize: 10})
+			want := "&lt;img src=x onerror=&#34;alert(1)&#34;&gt; <mark>markup</mark>"
+			if err != nil || len(rows) != 1 || rows[0].Snippet != want {
+				t.Errorf("SearchChirps() with markup\nrows = %+v\nwantSnippet = %q\nerror = %v", rows, want, err)
+			}
+		})
+	}
+}
+
+// VACUUM may renumber the implicit rowids of chirps, which the search index
+// must not depend on.
+func TestSQLiteSearchSurvivesRowidChanges(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+	q := NewSQLite(db)
+
+	user, _ := q.CreateUser(ctx, database.CreateUserParams{Email: "alice@example.com"})
+	hello, _ := q.CreateChirp(ctx, database.CreateChirpParams{Body: "hello world", UserID: user.ID})
+	goodbye, _ := q.CreateChirp(ctx, database.CreateChirpParams{Body: "goodbye world", UserID: user.ID})
+
+	_, err := db.Exec("UPDATE chirps SET rowid = rowid + 100 WHERE id = ?1", hello.ID)
+	if err != nil {
+		t.Fatalf("UPDATE chirps\nerror = %v", err)
+	}
+	q.UpdateChirpBody(ctx, database.UpdateChirpBodyParams{ID: goodbye.ID, Body: "farewell"})
+
+	rows, err := q.SearchChirps(ctx, database.SearchChirpsParams{Query: "hello", PageSize: 10})
+	if err != nil || len(rows) != 1 || rows[0].ID != hello.ID {
+		t.Errorf("SearchChirps() after the rowid changed\nrows = %+v\nerror = %v", rows, err)
+	}
+	rows, err = q.SearchChirps(ctx, database.SearchChirpsParams{Query: "world", PageSize: 10})
+	if err != nil || len(rows) != 1 || rows[0].ID != hello.ID {
+		t.Errorf("SearchChirps() after an edit\nrows = %+v\nerror = %v", rows, err)
+	}
+}
+
+func TestSoftDeleteChirps(t *testing.T) {
+	ctx := context.Background()
+
+	for _, backend := range testBackends(t) {
+		t.Run(backend.name, func(t *testing.T) {
+			q := backend.q
+
+			user, _ := q.CreateUser(ctx, database.CreateUserParams{Email: "alice@example.com"})
+			chirp, _ := q.CreateChirp(ctx, database.CreateChirpParams{Body: "hello world", UserID: user.ID})
+
+			deleted, err := q.SoftDeleteChirpById(ctx, chirp.ID)
+			if err != nil || deleted != 1 {
+				t.Fatalf("SoftDeleteChirpById()\ndeleted = %d\nerror = %v", deleted, err)
+			}
+			if deleted, _ := q.SoftDeleteChirpById(ctx, chirp.ID); deleted != 0 {
+				t.Errorf("SoftDeleteChirpById() twice\ndeleted = %d\nwantDeleted = 0", deleted)
+			}
+
+			if _, err := q.GetOneChirp(ctx, chirp.ID); err == nil {
+				t.Errorf("GetOneChirp()\nerror = nil\nwantErr = true")
+			}
+			if chirps, _ := q.ListChirps(ctx, database.ListChirpsParams{PageSize: 10}); len(chirps) != 0 {
+				t.Errorf("ListChirps()\nlen = %d\nwantLen = 0", len(chirps))
+			}
+			if rows, _ := q.SearchChirps(ctx, database.SearchChirpsParams{Query: "hello", PageSize: 10}); len(rows) != 0 {
+				t.Errorf("SearchChirps()\nlen = %d\nwantLen = 0", len(rows))
+			}
+
+			if _, err := q.RestoreChirp(ctx, database.RestoreChirpParams{ID: chirp.ID}); err == nil {
+				t.Errorf("RestoreChirp() after the window\nerror = nil\nwantErr = true")
+			}
+			restored, err := q.RestoreChirp(ctx, database.RestoreChirpParams{ID: chirp.ID, WindowSeconds: time.Hour.Seconds()})
+			if err != nil || restored.DeletedAt.Valid {
+				t.Fatalf("RestoreChirp()\nchirp = %v\nerror = %v", restored, err)
+			}
+
+			q.SoftDeleteChirpById(ctx, chirp.ID)
+			if purged, _ := q.PurgeDeletedChirps(ctx, time.Hour.Seconds()); purged != 0 {
+				t.Errorf("PurgeDeletedChirps() before retention\npurged = %d\nwantPurged = 0", purged)
+			}
+			if purged, _ := q.PurgeDeletedChirps(ctx, -time.Hour.Seconds()); purged != 1 {
+				t.Errorf("PurgeDeletedChirps() after retention\npurged = %d\nwantPurged = 1", purged)
+			}
+			if _, err := q.GetChirpIncludingDeleted(ctx, chirp.ID); err == nil {
+				t.Errorf("GetChirpIncludingDeleted() after purge\nerror = nil\nwantErr = true")
+			}
+		})
+	}
+}
+
+func TestUpdateChirpBody(t *testing.T) {
+	ctx := context.Background()
+
+	for _, backend := range testBackends(t) {
+		t.Run(backend.name, func(t *testing.T) {
+			q := backend.q
+
+			user, _ := q.CreateUser(ctx, database.CreateUserParams{Email: "alice@example.com"})
+			chirp, _ := q.CreateChirp(ctx, database.CreateChirpParams{Body: "first", UserID: user.ID})
+
+			for _, body := range []string{"second", "third"} {
+				updated, err := q.UpdateChirpBody(ctx, database.UpdateChirpBodyParams{ID: chirp.ID, Body: body})
+				if err != nil {
+					t.Fatalf("UpdateChirpBody()\nerror = %v", err)
+				}
+				if updated.Body != body || !updated.EditedAt.Valid {
+					t.Errorf("UpdateChirpBody()\nchirp = %v\nwantBody = %s", updated, body)
+				}
+			}
+
+			revisions, err := q.ListChirpRevisions(ctx, chirp.ID)
+			if err != nil {
+				t.Fatalf("ListChirpRevisions()\nerror = %v", err)
+			}
+			if len(revisions) != 2 || revisions[0].Body != "second" || revisions[1].Body != "first" {
+				t.Errorf("ListChirpRevisions()\nrevisions = %v\nwantBodies = second, first", revisions)
+			}
+
+			if rows, _ := q.SearchChirps(ctx, database.SearchChirpsParams{Query: "third", PageSize: 10}); len(rows) != 1 {
+				t.Errorf("SearchChirps() after edit\nlen = %d\nwantLen = 1", len(rows))
+			}
+
+			// Concurrent edits each save the body they replaced.
+			var wg sync.WaitGroup
+			for i := range 5 {
+				wg.Go(func() {
+					q.UpdateChirpBody(ctx, database.UpdateChirpBodyParams{ID: chirp.ID, Body: fmt.Sprintf("edit %d", i)})
+				})
+			}
+			wg.Wait()
+
+			revisions, _ = q.ListChirpRevisions(ctx, chirp.ID)
+			latest, _ := q.GetOneChirp(ctx, chirp.ID)
+			bodies := map[string]bool{latest.Body: true}
+			for _, revision := range revisions {
+				bodies[revision.Body] = true
+			}
+			if len(revisions) != 7 || len(bodies) != 8 {
+				t.Errorf("UpdateChirpBody() concurrently\nrevisions = %v\nwant every replaced body once", revisions)
+			}
+
+			q.SoftDeleteChirpById(ctx, chirp.ID)
+			if _, err := q.UpdateChirpBody(ctx, database.UpdateChirpBodyParams{ID: chirp.ID, Body: "deleted"}); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("UpdateChirpBody() on deleted chirp\nerror = %v\nwantErr = %v", err, sql.ErrNoRows)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+// Package throttle slows down repeated failures, like wrong passwords, by
+// making each key wait longer after every failure and locking it out for a
+// while once it fails too often.
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// Policy decides how long a key waits after its failures.
+type Policy struct {
+	// FreeFailures is how many failures are allowed before any delay.
+	FreeFailures int
+	// BaseDelay is the delay after the first failure past FreeFailures. It
+	// doubles with every further failure, up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutFailures is how many failures lock the key for LockoutDuration.
+	LockoutFailures int
+	LockoutDuration time.Duration
+	// ResetAfter is how long a key must go without failures for them to be
+	// forgotten.
+	ResetAfter time.Duration
+}
+
+type entry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+	// pending counts the reserved attempts that haven't been settled, the
+	// last of them reserved at lastReserved.
+	pending      int
+	lastReserved time.Time
+}
+
+// Limiter tracks the failures of each key in memory. The state is lost on
+// restart and not shared between servers, which is fine for slowing down
+// guessing but means a lockout only holds on the server that saw it.
+type Limiter struct {
+	policy  Policy
+	now     func() time.Time
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+func New(policy Policy) *Limiter {
+	return &Limiter{
+		policy:  policy,
+		now:     time.Now,
+		entries: map[string]*entry{},
+	}
+}
+
+// Reservation is an attempt reserved with Reserve. It must be settled with
+// Fail or Release once the outcome is known.
+type Reservation struct {
+	limiter *Limiter
+	keys    []string
+	entries []*entry
+	settled bool
+}
+
+// Reserve checks keys and records an attempt against them in one step, so
+// requests made in parallel can't all pass the check before any of them
+// fails. When one of keys has to wait it returns nil and how long. Until it
+// is settled the attempt counts as a failure for the next callers.
+func (l *Limiter) Reserve(keys ...string) (*Reservation, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	t := l.now()
+	if wait := l.wait(keys, t); wait > 0 {
+		return nil, wait
+	}
+
+	r := &Reservation{limiter: l, keys: keys}
+	for _, key := range keys {
+		e := l.create(key, t)
+		e.pending++
+		e.lastReserved = t
+		r.entries = append(r.entries, e)
+	}
+
+	return r, 0
+}
+
+// Fail records the attempt as a failure.
+func (r *Reservation) Fail() {
+	r.settle(true)
+}
+
+// Release gives the attempt back without counting it as a failure.
+func (r *Reservation) Release() {
+	r.settle(false)
+}
+
+func (r *Reservation) settle(failed bool) {
+	l := r.limiter
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if r.settled {
+		return
+	}
+	r.settled = true
+
+	t := l.now()
+	for i, key := range r.keys {
+		e := r.entries[i]
+		if l.entries[key] == e {
+			e.pending--
+		} else if failed {
+			// The key was reset in the meantime, the failure starts over.
+			e = l.create(key, t)
+		}
+
+		if failed {
+			l.fail(e, t)
+		}
+	}
+}
+
+// Reset forgets the failures of each of keys, lifting any lockout.
+func (l *Limiter) Reset(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		delete(l.entries, key)
+	}
+}
+
+// Prune forgets the keys whose failures are old enough to be ignored and
+// returns how many there were.
+func (l *Limiter) Prune() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	t := l.now()
+	pruned := 0
+	for key, e := range l.entries {
+		if l.expired(e, t) {
+			delete(l.entries, key)
+			pruned++
+		}
+	}
+
+	return pruned
+}
+
+// wait returns how long the caller must wait for any of keys. Pending
+// attempts count as failures made when they were reserved. It must be called
+// with the lock held.
+func (l *Limiter) wait(keys []string, t time.Time) time.Duration {
+	var wait time.Duration
+	for _, key := range keys {
+		e := l.entry(key, t)
+		if e == nil {
+			continue
+		}
+
+		last := e.lastFailure
+		if e.pending > 0 && e.lastReserved.After(last) {
+			last = e.lastReserved
+		}
+		wait = max(wait, e.lockedUntil.Sub(t), last.Add(l.delay(e.failures+e.pending)).Sub(t))
+
+		// The lockout has to be waited for too if the pending attempts
+		// would set it off.
+		if e.pending > 0 && l.policy.LockoutFailures > 0 && e.failures+e.pending >= l.policy.LockoutFailures {
+			wait = max(wait, last.Add(l.policy.LockoutDuration).Sub(t))
+		}
+	}
+
+	return wait
+}
+
+// fail records a failure at t in e. It must be called with the lock held.
+func (l *Limiter) fail(e *entry, t time.Time) {
+	e.failures++
+	e.lastFailure = t
+	if l.policy.LockoutFailures > 0 && e.failures >= l.policy.LockoutFailures {
+		e.lockedUntil = t.Add(l.policy.LockoutDuration)
+		e.failures = 0
+	}
+}
+
+// create returns the state of key, adding it when there is none. It must be
+// called with the lock held.
+func (l *Limiter) create(key string, t time.Time) *entry {
+	e := l.entry(key, t)
+	if e == nil {
+		e = &entry{}
+		l.entries[key] = e
+	}
+	return e
+}
+
+// entry returns the state of key, or nil when it has none worth keeping. It
+// must be called with the lock held.
+func (l *Limiter) entry(key string, t time.Time) *entry {
+	e, ok := l.entries[key]
+	if !ok {
+		return nil
+	}
+	if l.expired(e, t) {
+		delete(l.entries, key)
+		return nil
+	}
+	return e
+}
+
+func (l *Limiter) expired(e *entry, t time.Time) bool {
+	return e.pending == 0 && !e.lockedUntil.After(t) && t.Sub(e.lastFailure) >= l.policy.ResetAfter
+}
+
+// delay returns how long to wait after the last of failures.
+func (l *Limiter) delay(failures int) time.Duration {
+	extra := failures - l.policy.FreeFailures
+	if extra <= 0 {
+		return 0
+	}
+
+	delay := l.policy.BaseDelay
+	for i := 1; i < extra && delay < l.policy.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, l.policy.MaxDelay)
+}
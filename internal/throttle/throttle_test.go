@@ -0,0 +1,146 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+// waitFor returns how long the caller must wait before an attempt for any of
+// keys, without reserving one.
+func waitFor(l *Limiter, keys ...string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.wait(keys, l.now())
+}
+
+// failFor records a failure for each of keys, like a failed reservation.
+func failFor(l *Limiter, keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	t := l.now()
+	for _, key := range keys {
+		l.fail(l.create(key, t), t)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	policy := Policy{
+		FreeFailures:    2,
+		BaseDelay:       time.Second,
+		MaxDelay:        4 * time.Second,
+		LockoutFailures: 6,
+		LockoutDuration: time.Minute,
+		ResetAfter:      time.Hour,
+	}
+
+	tests := []struct {
+		name     string
+		failures int
+		elapsed  time.Duration
+		wantWait time.Duration
+	}{
+		{"No failures", 0, 0, 0},
+		{"Free failures", 2, 0, 0},
+		{"First delay", 3, 0, time.Second},
+		{"Doubled delay", 4, 0, 2 * time.Second},
+		{"Maximum delay", 5, 0, 4 * time.Second},
+		{"Delay waited out", 5, 4 * time.Second, 0},
+		{"Locked out", 6, 0, time.Minute},
+		{"Locked out after waiting", 6, 10 * time.Second, 50 * time.Second},
+		{"Lockout over", 6, time.Minute, 0},
+		{"Failures forgotten", 5, time.Hour, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			limiter := New(policy)
+			limiter.now = func() time.Time { return t0 }
+
+			for range test.failures {
+				failFor(limiter, "alice")
+			}
+			limiter.now = func() time.Time { return t0.Add(test.elapsed) }
+
+			if wait := waitFor(limiter, "alice"); wait != test.wantWait {
+				t.Errorf("waitFor()\nwait = %v\nwantWait = %v", wait, test.wantWait)
+			}
+			if wait := waitFor(limiter, "bob"); wait != 0 {
+				t.Errorf("waitFor() for another key\nwait = %v\nwantWait = 0", wait)
+			}
+		})
+	}
+}
+
+func TestLimiterKeys(t *testing.T) {
+	limiter := New(Policy{BaseDelay: time.Second, MaxDelay: time.Second, ResetAfter: time.Hour})
+
+	failFor(limiter, "alice", "127.0.0.1")
+	if wait := waitFor(limiter, "bob", "127.0.0.1"); wait == 0 {
+		t.Errorf("waitFor() with a failed key\nwait = %v\nwant a delay", wait)
+	}
+
+	limiter.Reset("127.0.0.1")
+	if wait := waitFor(limiter, "bob", "127.0.0.1"); wait != 0 {
+		t.Errorf("waitFor() after Reset()\nwait = %v\nwantWait = 0", wait)
+	}
+	if wait := waitFor(limiter, "alice"); wait == 0 {
+		t.Errorf("waitFor() for a key that wasn't reset\nwait = %v\nwant a delay", wait)
+	}
+
+	limiter.now = func() time.Time { return time.Now().Add(time.Hour) }
+	if pruned := limiter.Prune(); pruned != 1 {
+		t.Errorf("Prune()\npruned = %d\nwantPruned = 1", pruned)
+	}
+}
+
+func TestLimiterReserve(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := New(Policy{FreeFailures: 2, BaseDelay: time.Second, MaxDelay: time.Second, ResetAfter: time.Hour})
+	limiter.now = func() time.Time { return t0 }
+
+	// Parallel attempts get no more tries than ones made one after another.
+	reservations := []*Reservation{}
+	for range 3 {
+		r, wait := limiter.Reserve("alice")
+		if r == nil || wait != 0 {
+			t.Fatalf("Reserve()\nwait = %v\nwantWait = 0", wait)
+		}
+		reservations = append(reservations, r)
+	}
+	if r, wait := limiter.Reserve("alice"); r != nil || wait == 0 {
+		t.Errorf("Reserve() with every try pending\nwait = %v\nwant a delay", wait)
+	}
+
+	reservations[0].Release()
+	r, wait := limiter.Reserve("alice")
+	if r == nil || wait != 0 {
+		t.Fatalf("Reserve() after Release()\nwait = %v\nwantWait = 0", wait)
+	}
+	reservations[0] = r
+
+	for _, r := range reservations {
+		r.Fail()
+		r.Fail()
+	}
+	if wait := waitFor(limiter, "alice"); wait != time.Second {
+		t.Errorf("waitFor() after three failures\nwait = %v\nwantWait = %v", wait, time.Second)
+	}
+
+	limiter.Reset("alice")
+	if wait := waitFor(limiter, "alice"); wait != 0 {
+		t.Errorf("waitFor() after Reset()\nwait = %v\nwantWait = 0", wait)
+	}
+}
+
+func TestLimiterReserveLockout(t *testing.T) {
+	limiter := New(Policy{LockoutFailures: 2, LockoutDuration: time.Minute, ResetAfter: time.Hour})
+
+	limiter.Reserve("alice")
+	limiter.Reserve("alice")
+	if r, wait := limiter.Reserve("alice"); r != nil || wait == 0 {
+		t.Errorf("Reserve() when the pending attempts would lock the key\nwait = %v\nwant a delay", wait)
+	}
+}
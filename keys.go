@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"time"
+
+	"github.com/fernando8franco/http-server-golang/internal/auth"
+)
+
+const accessTokenExpiration = time.Hour
+
+// keyringReloadInterval is how often servers reload JWT_KEYRING. A rotated key
+// only starts signing once that long has passed, so every server knows it by
+// then.
+const keyringReloadInterval = time.Minute
+
+// maxSignedTokenLifetime is the longest lifetime of the tokens the keyring
+// signs. Retired keys stay valid for that long after the new key takes over.
+const maxSignedTokenLifetime = max(accessTokenExpiration, twoFactorChallengeExpiration, emailVerificationExpiration)
+
+// loadKeyring reads the keyring at path. Without one, access tokens are
+// signed with a single HS256 key made from secret.
+func loadKeyring(path, secret string) (*auth.Keyring, error) {
+	if path != "" {
+		keyring, err := auth.LoadKeyring(path)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't load JWT_KEYRING: %w", err)
+		}
+		return keyring, nil
+	}
+
+	if secret == "" {
+		return nil, errors.New("SECRET or JWT_KEYRING must be set")
+	}
+	return auth.NewKeyring(auth.SecretSigningKey(secret)), nil
+}
+
+func runKeys(path, secret string, args []string) error {
+	usage := fmt.Errorf("usage: %s admin keys list|rotate [HS256|RS256|EdDSA]", os.Args[0])
+	if len(args) == 0 {
+		return usage
+	}
+	if path == "" {
+		return errors.New("JWT_KEYRING must be set")
+	}
+
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		keyring, err := auth.LoadKeyring(path)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keyring.Keys() {
+			status := "active"
+			if key.ActivatesAt.After(time.Now()) {
+				status = "pending, signs from " + key.ActivatesAt.Format(time.RFC3339)
+			}
+			if !key.ExpiresAt.IsZero() {
+				status = "retired, valid until " + key.ExpiresAt.Format(time.RFC3339)
+			}
+			log.Printf("%s %s %s", key.ID, key.Algorithm, status)
+		}
+		return nil
+
+	case args[0] == "rotate" && len(args) <= 2:
+		algorithm := auth.AlgorithmEdDSA
+		if len(args) == 2 {
+			algorithm = args[1]
+		}
+
+		keyring, err := auth.LoadKeyring(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			// The first rotation takes over from SECRET, which keeps
+			// validating the tokens it signed until they expire.
+			keyring = auth.NewKeyring()
+			if secret != "" {
+				keyring = auth.NewKeyring(auth.SecretSigningKey(secret))
+			}
+		} else if err != nil {
+			return err
+		}
+
+		key, err := keyring.Rotate(algorithm, keyringReloadInterval, maxSignedTokenLifetime)
+		if err != nil {
+			return err
+		}
+		if err := keyring.Save(path); err != nil {
+			return err
+		}
+
+		log.Printf("Signing new tokens with key %s (%s) from %s", key.ID, key.Algorithm, key.ActivatesAt.Format(time.RFC3339))
+		return nil
+	}
+
+	return usage
+}
+
+// reloadKeyring reloads the keyring from path every interval until ctx is
+// done, so rotations reach running servers without a restart.
+func (ac *apiConfig) reloadKeyring(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := ac.keyring.Reload(path); err != nil {
+			log.Printf("Couldn't reload the keyring: %s", err)
+		}
+	}
+}
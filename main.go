@@ -1,26 +1,62 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/fernando8franco/http-server-golang/internal/auth"
+	"github.com/fernando8franco/http-server-golang/internal/authz"
 	"github.com/fernando8franco/http-server-golang/internal/database"
+	"github.com/fernando8franco/http-server-golang/internal/mail"
+	"github.com/fernando8franco/http-server-golang/internal/store"
+	"github.com/fernando8franco/http-server-golang/internal/throttle"
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
 )
 
+// shutdownTimeout is how long the requests in flight get to finish when the
+// server is stopped.
+const shutdownTimeout = 30 * time.Second
+
 type apiConfig struct {
 	fileserverHits atomic.Int32
-	db             *database.Queries
+	db             database.Querier
 	platform       string
-	secret         string
+	keyring        *auth.Keyring
 	expirationTime time.Duration
 	polkaKey       string
+	restoreWindow  time.Duration
+	chirpRetention time.Duration
+	mailer         mail.Mailer
+	baseURL        string
+	// requireVerifiedEmail keeps users from chirping until they verify
+	// their email address.
+	requireVerifiedEmail bool
+	accountThrottle      *throttle.Limiter
+	ipThrottle           *throttle.Limiter
+	passwordPolicy       auth.PasswordPolicy
+	passwordParams       auth.PasswordParams
+	hashPool             *auth.HashPool
+	// trustedProxyHeader names the header a reverse proxy puts the client
+	// address in. When it is empty the server must not run behind a proxy,
+	// or every client shares the login throttle of its address.
+	trustedProxyHeader string
+	// passwordResets tracks the reset emails still being sent after
+	// forgotPassword responded, and passwordResetSlots bounds how many there
+	// are.
+	passwordResets     sync.WaitGroup
+	passwordResetSlots chan struct{}
 }
 
 type ErrorMessage struct {
@@ -33,67 +69,286 @@ func main() {
 	if dbURL == "" {
 		log.Fatal("DB_URL must be set")
 	}
+
+	backend, err := store.Backend(dbURL)
+	if err != nil {
+		log.Fatalf("Error opening database: %s", err)
+	}
+	dbQueries, db, err := store.Open(dbURL)
+	if err != nil {
+		log.Fatalf("Error opening database: %s", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if db == nil {
+			log.Fatal("The in-memory store doesn't need migrations")
+		}
+		err := runMigrate(db, backend, os.Args[2:])
+		if err != nil {
+			log.Fatalf("Error running migrations: %s", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		err := runAdmin(dbQueries, os.Args[2:])
+		if err != nil {
+			log.Fatalf("Error running admin command: %s", err)
+		}
+		return
+	}
+
+	if os.Getenv("AUTO_MIGRATE") == "true" && db != nil {
+		err := autoMigrate(db, backend)
+		if err != nil {
+			log.Fatalf("Error running migrations: %s", err)
+		}
+	}
+
 	platform := os.Getenv("PLATFORM")
 	if platform == "" {
 		log.Fatal("PLATFORM must be set")
 	}
-	secret := os.Getenv("SECRET")
-	if secret == "" {
-		log.Fatal("SECRET must be set")
+	keyring, err := loadKeyring(os.Getenv("JWT_KEYRING"), os.Getenv("SECRET"))
+	if err != nil {
+		log.Fatal(err)
 	}
 	polkaKey := os.Getenv("POLKA_KEY")
 	if polkaKey == "" {
 		log.Fatal("POLKA_KEY must be set")
 	}
-
-	db, err := sql.Open("postgres", dbURL)
+	restoreWindow, err := durationEnv("CHIRP_RESTORE_WINDOW", 24*time.Hour)
 	if err != nil {
-		log.Fatalf("Error opening database: %s", err)
+		log.Fatal(err)
+	}
+	chirpRetention, err := durationEnv("CHIRP_RETENTION", 30*24*time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if chirpRetention < restoreWindow {
+		log.Fatal("CHIRP_RETENTION must not be shorter than CHIRP_RESTORE_WINDOW")
+	}
+	mailURL := os.Getenv("MAIL_URL")
+	if mailURL == "" {
+		mailURL = "file://outbox"
+	}
+	mailer, err := mail.Open(mailURL)
+	if err != nil {
+		log.Fatalf("Error opening the mailer: %s", err)
+	}
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	passwordPolicy := auth.DefaultPasswordPolicy
+	passwordPolicy.MinLength, err = intEnv("PASSWORD_MIN_LENGTH", passwordPolicy.MinLength)
+	if err != nil {
+		log.Fatal(err)
+	}
+	passwordPolicy.MinClasses, err = intEnv("PASSWORD_MIN_CLASSES", passwordPolicy.MinClasses)
+	if err != nil {
+		log.Fatal(err)
+	}
+	passwordParams, err := passwordParamsEnv(auth.DefaultPasswordParams)
+	if err != nil {
+		log.Fatal(err)
+	}
+	hashWorkers, err := intEnv("HASH_WORKERS", 4)
+	if err != nil || hashWorkers < 1 {
+		log.Fatal("HASH_WORKERS must be a positive integer")
+	}
+	hashQueue, err := intEnv("HASH_QUEUE", 64)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		passwordPolicy.Breached, err = auth.LoadBreachedPasswords(path)
+		if err != nil {
+			log.Fatalf("Error loading the breached passwords: %s", err)
+		}
 	}
-	dbQueries := database.New(db)
 
 	apiCfg := &apiConfig{
 		fileserverHits: atomic.Int32{},
 		db:             dbQueries,
 		platform:       platform,
-		secret:         secret,
-		expirationTime: time.Hour,
+		keyring:        keyring,
+		expirationTime: accessTokenExpiration,
 		polkaKey:       polkaKey,
+		restoreWindow:  restoreWindow,
+		chirpRetention: chirpRetention,
+		mailer:         mailer,
+		baseURL:        strings.TrimSuffix(baseURL, "/"),
+
+		requireVerifiedEmail: os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true",
+		accountThrottle:      throttle.New(accountLoginPolicy),
+		ipThrottle:           throttle.New(ipLoginPolicy),
+		passwordPolicy:       passwordPolicy,
+		passwordParams:       passwordParams,
+		hashPool:             auth.NewHashPool(hashWorkers, hashQueue),
+		passwordResetSlots:   make(chan struct{}, passwordResetWorkers),
+		trustedProxyHeader:   os.Getenv("TRUSTED_PROXY_HEADER"),
+	}
+
+	go apiCfg.purgeDeletedChirps(context.Background(), time.Hour)
+	go apiCfg.purgeDeniedAccessTokens(context.Background(), time.Hour)
+	go apiCfg.pruneLoginThrottles(context.Background(), time.Hour)
+	go apiCfg.purgeTwoFactorChallenges(context.Background(), time.Hour)
+	if path := os.Getenv("JWT_KEYRING"); path != "" {
+		go apiCfg.reloadKeyring(context.Background(), path, keyringReloadInterval)
+	}
+
+	server := http.Server{
+		Handler: apiCfg.routes(),
+		Addr:    ":8080",
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		err := server.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Error serving: %s", err)
+		}
+	}()
+	<-ctx.Done()
+
+	// The reset emails outlive the requests that started them, so they are
+	// waited for once no new ones can come in.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Printf("Error shutting down: %s", err)
 	}
+	apiCfg.passwordResets.Wait()
+}
 
+func (ac *apiConfig) routes() http.Handler {
 	serverMux := http.NewServeMux()
+	authenticator := auth.NewAuthenticator(ac.keyring, ac.db, ac.loadPrincipal, ac.loadPersonalAccessToken)
+	required := func(h http.HandlerFunc, scopes ...authz.Scope) http.Handler {
+		return authenticator.Required(h, scopes...)
+	}
+	optional := func(h http.HandlerFunc, scopes ...authz.Scope) http.Handler {
+		return authenticator.Optional(h, scopes...)
+	}
 
 	handler := http.StripPrefix("/app", http.FileServer(http.Dir(".")))
-	serverMux.Handle("GET /app/", apiCfg.middlewareMetricsInc(handler))
+	serverMux.Handle("GET /app/", ac.middlewareMetricsInc(handler))
 
 	serverMux.HandleFunc("GET /api/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
-	serverMux.HandleFunc("POST /api/users", apiCfg.createUser)
-	serverMux.HandleFunc("PUT /api/users", apiCfg.updateUser)
-	serverMux.HandleFunc("POST /api/login", apiCfg.loginUser)
+	serverMux.HandleFunc("GET /.well-known/jwks.json", ac.getJWKS)
+	serverMux.HandleFunc("POST /api/users", ac.createUser)
+	serverMux.Handle("PUT /api/users", required(ac.updateUser, authz.ScopeProfileWrite))
+	serverMux.Handle("PATCH /api/users", required(ac.patchUser, authz.ScopeProfileWrite))
+	serverMux.HandleFunc("POST /api/login", ac.loginUser)
+	serverMux.HandleFunc("POST /api/login/2fa", ac.loginTwoFactor)
+	serverMux.HandleFunc("POST /api/password/forgot", ac.forgotPassword)
+	serverMux.HandleFunc("POST /api/password/reset", ac.resetPassword)
+	serverMux.HandleFunc("GET /api/users/verify", ac.verifyEmail)
+	serverMux.Handle("POST /api/users/verify/resend", required(ac.resendVerificationEmail))
+	serverMux.Handle("POST /api/users/2fa/enroll", required(ac.enrollTwoFactor))
+	serverMux.Handle("POST /api/users/2fa/verify", required(ac.verifyTwoFactor))
+	serverMux.HandleFunc("GET /api/users/handles/{handle}", ac.checkHandle)
+	serverMux.HandleFunc("GET /api/users/{idOrHandle}", ac.getUserProfile)
 
-	serverMux.HandleFunc("POST /api/refresh", apiCfg.refreshToken)
-	serverMux.HandleFunc("POST /api/revoke", apiCfg.revokeToken)
+	serverMux.HandleFunc("POST /api/refresh", ac.refreshToken)
+	serverMux.HandleFunc("POST /api/revoke", ac.revokeToken)
 
-	serverMux.HandleFunc("POST /api/chirps", apiCfg.createChirp)
-	serverMux.HandleFunc("GET /api/chirps", apiCfg.getAllChirps)
-	serverMux.HandleFunc("GET /api/chirps/{chirpId}", apiCfg.getOneChirp)
-	serverMux.HandleFunc("DELETE /api/chirps/{chirpId}", apiCfg.deleteChirp)
+	serverMux.Handle("GET /api/sessions", required(ac.getSessions))
+	serverMux.Handle("DELETE /api/sessions/{sessionId}", required(ac.revokeSession))
+	serverMux.Handle("POST /api/sessions/revoke-all", required(ac.revokeAllSessions))
 
-	serverMux.HandleFunc("POST /api/polka/webhooks", apiCfg.polkaWebhook)
+	serverMux.Handle("POST /api/tokens", required(ac.createPersonalAccessToken))
+	serverMux.Handle("GET /api/tokens", required(ac.getPersonalAccessTokens))
+	serverMux.Handle("DELETE /api/tokens/{tokenId}", required(ac.deletePersonalAccessToken))
 
-	serverMux.HandleFunc("GET /admin/metrics", apiCfg.metrics)
-	serverMux.HandleFunc("POST /admin/reset", apiCfg.reset)
+	serverMux.Handle("POST /api/chirps", required(ac.createChirp, authz.ScopeChirpsWrite))
+	serverMux.Handle("GET /api/chirps", optional(ac.getAllChirps, authz.ScopeChirpsRead))
+	serverMux.Handle("GET /api/chirps/search", optional(ac.searchChirps, authz.ScopeChirpsRead))
+	serverMux.Handle("GET /api/chirps/{chirpId}", optional(ac.getOneChirp, authz.ScopeChirpsRead))
+	serverMux.Handle("PUT /api/chirps/{chirpId}", required(ac.updateChirp, authz.ScopeChirpsWrite))
+	serverMux.Handle("DELETE /api/chirps/{chirpId}", required(ac.deleteChirp, authz.ScopeChirpsWrite))
+	serverMux.Handle("GET /api/chirps/{chirpId}/revisions", optional(ac.getChirpRevisions, authz.ScopeChirpsRead))
+	serverMux.Handle("POST /api/chirps/{chirpId}/restore", required(ac.restoreChirp, authz.ScopeChirpsWrite))
 
-	server := http.Server{
-		Handler: serverMux,
-		Addr:    ":8080",
+	serverMux.HandleFunc("POST /api/polka/webhooks", ac.polkaWebhook)
+
+	serverMux.HandleFunc("GET /admin/metrics", ac.metrics)
+	serverMux.HandleFunc("POST /admin/reset", ac.reset)
+	serverMux.Handle("POST /admin/users/unlock", required(ac.unlockUser))
+
+	return serverMux
+}
+
+// durationEnv reads a duration like "72h" from the environment, falling back
+// to def when the variable is unset.
+func durationEnv(key string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration like 24h", key)
+	}
+
+	return d, nil
+}
+
+// intEnv reads a non-negative integer from the environment, falling back to
+// def when the variable is unset.
+func intEnv(key string, def int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", key)
+	}
+
+	return n, nil
+}
+
+// passwordParamsEnv reads the Argon2id costs of new password hashes from
+// ARGON2_MEMORY, in KiB, ARGON2_ITERATIONS and ARGON2_PARALLELISM, falling
+// back to def for the unset ones.
+func passwordParamsEnv(def auth.PasswordParams) (auth.PasswordParams, error) {
+	memory, err := intEnv("ARGON2_MEMORY", int(def.Memory))
+	if err != nil {
+		return auth.PasswordParams{}, err
+	}
+	iterations, err := intEnv("ARGON2_ITERATIONS", int(def.Iterations))
+	if err != nil {
+		return auth.PasswordParams{}, err
+	}
+	parallelism, err := intEnv("ARGON2_PARALLELISM", int(def.Parallelism))
+	if err != nil {
+		return auth.PasswordParams{}, err
+	}
+
+	if iterations < 1 || iterations > math.MaxUint32 || parallelism < 1 || parallelism > math.MaxUint8 {
+		return auth.PasswordParams{}, fmt.Errorf("ARGON2_ITERATIONS must be at least 1 and ARGON2_PARALLELISM between 1 and %d", math.MaxUint8)
+	}
+	// Argon2 needs 8 KiB for each lane.
+	if memory < 8*parallelism || memory > math.MaxUint32 {
+		return auth.PasswordParams{}, fmt.Errorf("ARGON2_MEMORY must be at least 8 KiB per lane")
 	}
 
-	server.ListenAndServe()
+	return auth.PasswordParams{
+		Memory:      uint32(memory),
+		Iterations:  uint32(iterations),
+		Parallelism: uint8(parallelism),
+	}, nil
 }
 
 func (ac *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
@@ -0,0 +1,1604 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fernando8franco/http-server-golang/internal/auth"
+	"github.com/fernando8franco/http-server-golang/internal/database"
+	"github.com/fernando8franco/http-server-golang/internal/mail"
+	"github.com/fernando8franco/http-server-golang/internal/store"
+	"github.com/fernando8franco/http-server-golang/internal/throttle"
+	"github.com/google/uuid"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server, _ := newTestServerWithConfig(t)
+	return server
+}
+
+func newTestServerWithConfig(t *testing.T) (*httptest.Server, *apiConfig) {
+	t.Helper()
+
+	hashPool := auth.NewHashPool(2, 16)
+	t.Cleanup(hashPool.Close)
+
+	apiCfg := &apiConfig{
+		db:             store.NewMemory(),
+		platform:       "dev",
+		keyring:        auth.NewKeyring(auth.SecretSigningKey("secret")),
+		expirationTime: time.Hour,
+		polkaKey:       "polka",
+		restoreWindow:  time.Hour,
+		chirpRetention: 24 * time.Hour,
+		mailer:         &testMailer{},
+		baseURL:        "http://chirpy.test",
+
+		accountThrottle: throttle.New(accountLoginPolicy),
+		ipThrottle:      throttle.New(ipLoginPolicy),
+		passwordPolicy:  auth.PasswordPolicy{MinLength: 8},
+		passwordParams:  auth.PasswordParams{Memory: 1024, Iterations: 1, Parallelism: 1},
+		hashPool:        hashPool,
+
+		passwordResetSlots: make(chan struct{}, passwordResetWorkers),
+	}
+
+	server := httptest.NewServer(apiCfg.routes())
+	t.Cleanup(server.Close)
+
+	return server, apiCfg
+}
+
+// testMailer keeps the messages it is asked to send.
+type testMailer struct {
+	mu       sync.Mutex
+	messages []mail.Message
+}
+
+func (m *testMailer) Send(ctx context.Context, msg mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// lastMessage returns the last message sent to address.
+func (m *testMailer) lastMessage(t *testing.T, address string) mail.Message {
+	t.Helper()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To == address {
+			return m.messages[i]
+		}
+	}
+
+	t.Fatalf("no message sent to %s", address)
+	return mail.Message{}
+}
+
+// sentTo counts the messages sent to address.
+func (m *testMailer) sentTo(address string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sent := 0
+	for _, msg := range m.messages {
+		if msg.To == address {
+			sent++
+		}
+	}
+	return sent
+}
+
+// lastVerificationLink returns the path of the verification link in the last
+// message sent to address.
+func (m *testMailer) lastVerificationLink(t *testing.T, address string) string {
+	t.Helper()
+
+	for _, field := range strings.Fields(m.lastMessage(t, address).Body) {
+		if link, ok := strings.CutPrefix(field, "http://chirpy.test/api/users/verify"); ok {
+			return "/api/users/verify" + link
+		}
+	}
+
+	t.Fatalf("no verification link sent to %s", address)
+	return ""
+}
+
+// lastResetToken returns the password reset token in the last message sent
+// to address.
+func (m *testMailer) lastResetToken(t *testing.T, address string) string {
+	t.Helper()
+
+	lines := strings.Split(m.lastMessage(t, address).Body, "\n")
+	if len(lines) < 3 || lines[2] == "" {
+		t.Fatalf("no reset token sent to %s", address)
+	}
+	return lines[2]
+}
+
+func doRequest(t *testing.T, server *httptest.Server, method, path, token string, body any, out any) int {
+	t.Helper()
+
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			t.Fatalf("encoding body: %v", err)
+		}
+	}
+
+	req, err := http.NewRequest(method, server.URL+path, &reqBody)
+	if err != nil {
+		t.Fatalf("creating request: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("sending request: %v", err)
+	}
+	defer res.Body.Close()
+
+	if out != nil {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+	}
+
+	return res.StatusCode
+}
+
+type testLogin struct {
+	User
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func createTestUser(t *testing.T, server *httptest.Server, email string) testLogin {
+	t.Helper()
+
+	credentials := map[string]string{"email": email, "password": "password"}
+	if code := doRequest(t, server, "POST", "/api/users", "", credentials, nil); code != http.StatusCreated {
+		t.Fatalf("POST /api/users\ncode = %d\nwantCode = %d", code, http.StatusCreated)
+	}
+
+	login := testLogin{}
+	if code := doRequest(t, server, "POST", "/api/login", "", credentials, &login); code != http.StatusOK {
+		t.Fatalf("POST /api/login\ncode = %d\nwantCode = %d", code, http.StatusOK)
+	}
+
+	return login
+}
+
+func TestChirpFlow(t *testing.T) {
+	server := newTestServer(t)
+	alice := createTestUser(t, server, "alice@example.com")
+
+	created := Chirp{}
+	code := doRequest(t, server, "POST", "/api/chirps", alice.AccessToken, map[string]string{"body": "I hear Mastodon is better than Chirpy. sharbert I need to migrate"}, &created)
+	if code != http.StatusCreated {
+		t.Fatalf("POST /api/chirps\ncode = %d\nwantCode = %d", code, http.StatusCreated)
+	}
+	if created.Body != "I hear Mastodon is better than Chirpy. **** I need to migrate" {
+		t.Errorf("POST /api/chirps\nbody = %q", created.Body)
+	}
+
+	chirps := []Chirp{}
+	doRequest(t, server, "GET", "/api/chirps", "", nil, &chirps)
+	if len(chirps) != 1 || chirps[0].Id != created.Id {
+		t.Errorf("GET /api/chirps\nchirps = %v\nwantId = %v", chirps, created.Id)
+	}
+
+	refreshed := struct {
+		AccessToken string `json:"token"`
+	}{}
+	code = doRequest(t, server, "POST", "/api/refresh", alice.RefreshToken, nil, &refreshed)
+	if code != http.StatusOK || refreshed.AccessToken == "" {
+		t.Errorf("POST /api/refresh\ncode = %d\nwantCode = %d", code, http.StatusOK)
+	}
+
+	code = doRequest(t, server, "DELETE", "/api/chirps/"+created.Id.String(), alice.AccessToken, nil, nil)
+	if code != http.StatusNoContent {
+		t.Errorf("DELETE /api/chirps\ncode = %d\nwantCode = %d", code, http.StatusNoContent)
+	}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	server := newTestServer(t)
+	alice := createTestUser(t, server, "alice@example.com")
+
+	type refreshResponse struct {
+		AccessToken  string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	rotated := refreshResponse{}
+	code := doRequest(t, server, "POST", "/api/refresh", alice.RefreshToken, nil, &rotated)
+	if code != http.StatusOK {
+		t.Fatalf("POST /api/refresh\ncode = %d\nwantCode = %d", code, http.StatusOK)
+	}
+	if rotated.RefreshToken == "" || rotated.RefreshToken == alice.RefreshToken {
+		t.Fatalf("POST /api/refresh\nrefreshToken = %q\nwant a new token", rotated.RefreshToken)
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		wantCode int
+	}{
+		{"Rotated token", rotated.RefreshToken, http.StatusOK},
+		{"Reused token", alice.RefreshToken, http.StatusUnauthorized},
+		{"Token from the revoked family", rotated.RefreshToken, http.StatusUnauthorized},
+		{"Unknown token", "unknown", http.StatusUnauthorized},
+	}
+
+	// The first case rotates the token again, so later cases depend on the
+	// earlier ones and run in order.
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := refreshResponse{}
+			code := doRequest(t, server, "POST", "/api/refresh", test.token, nil, &resp)
+			if code != test.wantCode {
+				t.Errorf("POST /api/refresh\ncode = %d\nwantCode = %d", code, test.wantCode)
+			}
+			if test.wantCode == http.StatusOK {
+				rotated = resp
+			}
+		})
+	}
+
+	code = doRequest(t, server, "POST", "/api/refresh", rotated.RefreshToken, nil, nil)
+	if code != http.StatusUnauthorized {
+		t.Errorf("POST /api/refresh\ncode = %d\nwantCode = %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	server := newTestServer(t)
+	alice := createTestUser(t, server, "alice@example.com")
+	bob := createTestUser(t, server, "bob@example.com")
+
+	second := testLogin{}
+	doRequest(t, server, "POST", "/api/login", "", map[string]string{"email": "alice@example.com", "password": "password"}, &second)
+
+	sessions := []Session{}
+	code := doRequest(t, server, "GET", "/api/sessions", alice.AccessToken, nil, &sessions)
+	if code != http.StatusOK || len(sessions) != 2 {
+		t.Fatalf("GET /api/sessions\ncode = %d\nlen = %d\nwantLen = 2", code, len(sessions))
+	}
+	if sessions[0].IpAddress == "" || sessions[0].UserAgent == "" {
+		t.Errorf("GET /api/sessions\nsession = %+v\nwant the login metadata", sessions[0])
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		id       string
+		wantCode int
+	}{
+		{"Missing token", "", sessions[0].Id.String(), http.StatusUnauthorized},
+		{"Invalid id", alice.AccessToken, "not-a-uuid", http.StatusBadRequest},
+		{"Other user's session", bob.AccessToken, sessions[0].Id.String(), http.StatusNotFound},
+		{"Own session", alice.AccessToken, sessions[0].Id.String(), http.StatusNoContent},
+		{"Already revoked", alice.AccessToken, sessions[0].Id.String(), http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code := doRequest(t, server, "DELETE", "/api/sessions/"+test.id, test.token, nil, nil)
+			if code != test.wantCode {
+				t.Errorf("DELETE /api/sessions\ncode = %d\nwantCode = %d", code, test.wantCode)
+			}
+		})
+	}
+
+	code = doRequest(t, server, "POST", "/api/sessions/revoke-all", alice.AccessToken, nil, nil)
+	if code != http.StatusNoContent {
+		t.Errorf("POST /api/sessions/revoke-all\ncode = %d\nwantCode = %d", code, http.StatusNoContent)
+	}
+
+	for _, refreshToken := range []string{alice.RefreshToken, second.RefreshToken} {
+		if code := doRequest(t, server, "POST", "/api/refresh", refreshToken, nil, nil); code != http.StatusUnauthorized {
+			t.Errorf("POST /api/refresh after revoke-all\ncode = %d\nwantCode = %d", code, http.StatusUnauthorized)
+		}
+	}
+	if code := doRequest(t, server, "POST", "/api/refresh", bob.RefreshToken, nil, nil); code != http.StatusOK {
+		t.Errorf("POST /api/refresh for another user\ncode = %d\nwantCode = %d", code, http.StatusOK)
+	}
+}
+
+func TestAccessTokenRevocation(t *testing.T) {
+	credentials := map[string]string{"email": "alice@example.com", "password": "password"}
+
+	tests := []struct {
+		name   string
+		revoke func(t *testing.T, server *httptest.Server, apiCfg *apiConfig, alice testLogin)
+	}{
+		{
+			"Logout",
+			func(t *testing.T, server *httptest.Server, apiCfg *apiConfig, alice testLogin) {
+				doRequest(t, server, "POST", "/api/revoke", alice.RefreshToken, nil, nil)
+			},
+		},
+		{
+			"Revoke all sessions",
+			func(t *testing.T, server *httptest.Server, apiCfg *apiConfig, alice testLogin) {
+				second := testLogin{}
+				doRequest(t, server, "POST", "/api/login", "", credentials, &second)
+				doRequest(t, server, "POST", "/api/sessions/revoke-all", second.AccessToken, nil, nil)
+			},
+		},
+		{
+			"Password change from another login",
+			func(t *testing.T, server *httptest.Server, apiCfg *apiConfig, alice testLogin) {
+				second := testLogin{}
+				doRequest(t, server, "POST", "/api/login", "", credentials, &second)
+				doRequest(t, server, "PUT", "/api/users", second.AccessToken, map[string]string{"email": "alice@example.com", "password": "new-password"}, nil)
+
+				if code := doRequest(t, server, "POST", "/api/chirps", second.AccessToken, map[string]string{"body": "hello"}, nil); code != http.StatusCreated {
+					t.Errorf("POST /api/chirps with the token that changed the password\ncode = %d\nwantCode = %d", code, http.StatusCreated)
+				}
+				if code := doRequest(t, server, "POST", "/api/refresh", alice.RefreshToken, nil, nil); code != http.StatusUnauthorized {
+					t.Errorf("POST /api/refresh for another session after a password change\ncode = %d\nwantCode = %d", code, http.StatusUnauthorized)
+				}
+				if code := doRequest(t, server, "POST", "/api/refresh", second.RefreshToken, nil, nil); code != http.StatusOK {
+					t.Errorf("POST /api/refresh for the session that changed the password\ncode = %d\nwantCode = %d", code, http.StatusOK)
+				}
+			},
+		},
+		{
+			"Suspension",
+			func(t *testing.T, server *httptest.Server, apiCfg *apiConfig, alice testLogin) {
+				if err := runAdmin(apiCfg.db, []string{"suspend", "alice@example.com"}); err != nil {
+					t.Fatalf("runAdmin()\nerror = %v", err)
+				}
+				if code := doRequest(t, server, "POST", "/api/login", "", credentials, nil); code != http.StatusForbidden {
+					t.Errorf("POST /api/login while suspended\ncode = %d\nwantCode = %d", code, http.StatusForbidden)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, apiCfg := newTestServerWithConfig(t)
+			alice := createTestUser(t, server, "alice@example.com")
+			bob := createTestUser(t, server, "bob@example.com")
+
+			test.revoke(t, server, apiCfg, alice)
+
+			if code := doRequest(t, server, "POST", "/api/chirps", alice.AccessToken, map[string]string{"body": "hello"}, nil); code != http.StatusUnauthorized {
+				t.Errorf("POST /api/chirps with a revoked token\ncode = %d\nwantCode = %d", code, http.StatusUnauthorized)
+			}
+			if code := doRequest(t, server, "POST", "/api/chirps", bob.AccessToken, map[string]string{"body": "hello"}, nil); code != http.StatusCreated {
+				t.Errorf("POST /api/chirps for another user\ncode = %d\nwantCode = %d", code, http.StatusCreated)
+			}
+		})
+	}
+}
+
+func TestPersonalAccessTokens(t *testing.T) {
+	server := newTestServer(t)
+	alice := createTestUser(t, server, "alice@example.com")
+	bob := createTestUser(t, server, "bob@example.com")
+
+	tokenParams := map[string]any{"name": "bot", "scopes": []string{"chirps:read", "chirps:write"}}
+	created := struct {
+		PersonalAccessToken
+		Token string `json:"token"`
+	}{}
+	code := doRequest(t, server, "POST", "/api/tokens", alice.AccessToken, tokenParams, &created)
+	if code != http.StatusCreated || !strings.HasPrefix(created.Token, auth.PersonalAccessTokenPrefix) {
+		t.Fatalf("POST /api/tokens\ncode = %d\ntoken = %+v", code, created)
+	}
+
+	invalid := []struct {
+		name   string
+		params map[string]any
+	}{
+		{"Missing name", map[string]any{"scopes": []string{"chirps:read"}}},
+		{"Missing scopes", map[string]any{"name": "bot"}},
+		{"Unknown scope", map[string]any{"name": "bot", "scopes": []string{"admin"}}},
+		{"Expiry in the past", map[string]any{"name": "bot", "scopes": []string{"chirps:read"}, "expires_at": time.Now().Add(-time.Hour)}},
+	}
+	for _, test := range invalid {
+		t.Run(test.name, func(t *testing.T) {
+			if code := doRequest(t, server, "POST", "/api/tokens", alice.AccessToken, test.params, nil); code != http.StatusBadRequest {
+				t.Errorf("POST /api/tokens\ncode = %d\nwantCode = %d", code, http.StatusBadRequest)
+			}
+		})
+	}
+
+	readOnly := struct {
+		Token string `json:"token"`
+	}{}
+	doRequest(t, server, "POST", "/api/tokens", alice.AccessToken, map[string]any{"name": "reader", "scopes": []string{"chirps:read"}}, &readOnly)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		token    string
+		body     any
+		wantCode int
+	}{
+		{"Write with chirps:write", "POST", "/api/chirps", created.Token, map[string]string{"body": "hello"}, http.StatusCreated},
+		{"Write with chirps:read only", "POST", "/api/chirps", readOnly.Token, map[string]string{"body": "hello"}, http.StatusForbidden},
+		{"Read with chirps:read", "GET", "/api/chirps", readOnly.Token, nil, http.StatusOK},
+		{"Profile without profile:write", "PUT", "/api/users", created.Token, map[string]string{"email": "alice@example.com", "password": "password"}, http.StatusForbidden},
+		{"Tokens can't create tokens", "POST", "/api/tokens", created.Token, tokenParams, http.StatusForbidden},
+		{"Tokens can't list sessions", "GET", "/api/sessions", created.Token, nil, http.StatusForbidden},
+		{"Unknown token", "GET", "/api/chirps", auth.MakePersonalAccessToken(), nil, http.StatusUnauthorized},
+		{"Other user's token", "DELETE", "/api/tokens/" + created.Id.String(), bob.AccessToken, nil, http.StatusNotFound},
+		{"Delete own token", "DELETE", "/api/tokens/" + created.Id.String(), alice.AccessToken, nil, http.StatusNoContent},
+		{"Deleted token", "POST", "/api/chirps", created.Token, map[string]string{"body": "hello"}, http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code := doRequest(t, server, test.method, test.path, test.token, test.body, nil)
+			if code != test.wantCode {
+				t.Errorf("%s %s\ncode = %d\nwantCode = %d", test.method, test.path, code, test.wantCode)
+			}
+		})
+	}
+
+	tokens := []PersonalAccessToken{}
+	code = doRequest(t, server, "GET", "/api/tokens", alice.AccessToken, nil, &tokens)
+	if code != http.StatusOK || len(tokens) != 1 || tokens[0].Name != "reader" || tokens[0].LastUsedAt == nil {
+		t.Errorf("GET /api/tokens\ncode = %d\ntokens = %+v\nwant the used reader token", code, tokens)
+	}
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	server := newTestServer(t)
+	alice := createTestUser(t, server, "alice@example.com")
+	credentials := map[string]string{"email": "alice@example.com", "password": "password"}
+
+	enrollment := struct {
+		OtpauthURI    string   `json:"otpauth_uri"`
+		Secret        string   `json:"secret"`
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{}
+	code := doRequest(t, server, "POST", "/api/users/2fa/enroll", alice.AccessToken, nil, &enrollment)
+	if code != http.StatusOK || !strings.HasPrefix(enrollment.OtpauthURI, "otpauth://totp/") || len(enrollment.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("POST /api/users/2fa/enroll\ncode = %d\nenrollment = %+v", code, enrollment)
+	}
+
+	// Until the code is verified the password alone still logs in.
+	if login := (testLogin{}); doRequest(t, server, "POST", "/api/login", "", credentials, &login) != http.StatusOK || login.AccessToken == "" {
+		t.Errorf("POST /api/login before verifying\nwant the tokens")
+	}
+
+	if code := doRequest(t, server, "POST", "/api/users/2fa/verify", alice.AccessToken, map[string]string{"code": "000000"}, nil); code != http.StatusBadRequest {
+		t.Errorf("POST /api/users/2fa/verify with a wrong code\ncode = %d\nwantCode = %d", code, http.StatusBadRequest)
+	}
+	totp, _ := auth.TOTPCode(enrollment.Secret, time.Now())
+	if code := doRequest(t, server, "POST", "/api/users/2fa/verify", alice.AccessToken, map[string]string{"code": totp}, nil); code != http.StatusNoContent {
+		t.Fatalf("POST /api/users/2fa/verify\ncode = %d\nwantCode = %d", code, http.StatusNoContent)
+	}
+
+	challenge := func() string {
+		resp := struct {
+			testLogin
+			TwoFactorRequired bool   `json:"two_factor_required"`
+			ChallengeToken    string `json:"challenge_token"`
+		}{}
+		code := doRequest(t, server, "POST", "/api/login", "", credentials, &resp)
+		if code != http.StatusOK || !resp.TwoFactorRequired || resp.AccessToken != "" {
+			t.Fatalf("POST /api/login\ncode = %d\nresponse = %+v\nwant a challenge", code, resp)
+		}
+		return resp.ChallengeToken
+	}
+
+	nextTOTP, _ := auth.TOTPCode(enrollment.Secret, time.Now().Add(30*time.Second))
+
+	tests := []struct {
+		name     string
+		params   map[string]string
+		wantCode int
+	}{
+		{"Missing code", map[string]string{"challenge_token": challenge()}, http.StatusUnauthorized},
+		{"Invalid challenge", map[string]string{"challenge_token": alice.AccessToken, "code": nextTOTP}, http.StatusUnauthorized},
+		{"Code used to verify", map[string]string{"challenge_token": challenge(), "code": totp}, http.StatusUnauthorized},
+		{"Valid code", map[string]string{"challenge_token": challenge(), "code": nextTOTP}, http.StatusOK},
+		{"Replayed code", map[string]string{"challenge_token": challenge(), "code": nextTOTP}, http.StatusUnauthorized},
+		{"Recovery code", map[string]string{"challenge_token": challenge(), "recovery_code": enrollment.RecoveryCodes[0]}, http.StatusOK},
+		{"Used recovery code", map[string]string{"challenge_token": challenge(), "recovery_code": enrollment.RecoveryCodes[0]}, http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			login := testLogin{}
+			code := doRequest(t, server, "POST", "/api/login/2fa", "", test.params, &login)
+			if code != test.wantCode {
+				t.Errorf("POST /api/login/2fa\ncode = %d\nwantCode = %d", code, test.wantCode)
+			}
+			if code == http.StatusOK && (login.AccessToken == "" || login.RefreshToken == "") {
+				t.Errorf("POST /api/login/2fa\nresponse = %+v\nwant the tokens", login)
+			}
+		})
+	}
+
+	if code := doRequest(t, server, "POST", "/api/users/2fa/enroll", alice.AccessToken, nil, nil); code != http.StatusConflict {
+		t.Errorf("POST /api/users/2fa/enroll when enabled\ncode = %d\nwantCode = %d", code, http.StatusConflict)
+	}
+}
+
+func TestTwoFactorChallengeLimits(t *testing.T) {
+	server, apiCfg := newTestServerWithConfig(t)
+	alice := createTestUser(t, server, "alice@example.com")
+	credentials := map[string]string{"email": "alice@example.com", "password": "password"}
+
+	enrollment := struct {
+		Secret        string   `json:"secret"`
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{}
+	doRequest(t, server, "POST", "/api/users/2fa/enroll", alice.AccessToken, nil, &enrollment)
+	totp, _ := auth.TOTPCode(enrollment.Secret, time.Now())
+	if code := doRequest(t, server, "POST", "/api/users/2fa/verify", alice.AccessToken, map[string]string{"code": totp}, nil); code != http.StatusNoContent {
+		t.Fatalf("POST /api/users/2fa/verify\ncode = %d\nwantCode = %d", code, http.StatusNoContent)
+	}
+
+	challenge := func() string {
+		resp := struct {
+			ChallengeToken string `json:"challenge_token"`
+		}{}
+		doRequest(t, server, "POST", "/api/login", "", credentials, &resp)
+		return resp.ChallengeToken
+	}
+	try := func(challengeToken, recoveryCode string) int {
+		params := map[string]string{"challenge_token": challengeToken, "recovery_code": recoveryCode}
+		return doRequest(t, server, "POST", "/api/login/2fa", "", params, nil)
+	}
+
+	used := challenge()
+	if code := try(used, enrollment.RecoveryCodes[0]); code != http.StatusOK {
+		t.Fatalf("POST /api/login/2fa\ncode = %d\nwantCode = %d", code, http.StatusOK)
+	}
+	if code := try(used, enrollment.RecoveryCodes[1]); code != http.StatusUnauthorized {
+		t.Errorf("POST /api/login/2fa with a used challenge\ncode = %d\nwantCode = %d", code, http.StatusUnauthorized)
+	}
+
+	// Without delays, only the challenge's own limit stops the guessing.
+	apiCfg.accountThrottle = throttle.New(throttle.Policy{ResetAfter: time.Hour})
+	apiCfg.ipThrottle = throttle.New(throttle.Policy{ResetAfter: time.Hour})
+
+	exhausted := challenge()
+	for range twoFactorChallengeAttempts {
+		if code := try(exhausted, "wrong-code"); code != http.StatusUnauthorized {
+			t.Fatalf("POST /api/login/2fa with a wrong code\ncode = %d\nwantCode = %d", code, http.StatusUnauthorized)
+		}
+	}
+	if code := try(exhausted, enrollment.RecoveryCodes[1]); code != http.StatusUnauthorized {
+		t.Errorf("POST /api/login/2fa after too many wrong codes\ncode = %d\nwantCode = %d", code, http.StatusUnauthorized)
+	}
+
+	// With the login throttle, wrong codes slow down every challenge of
+	// the user.
+	apiCfg.accountThrottle = throttle.New(accountLoginPolicy)
+	for range accountLoginPolicy.FreeFailures + 1 {
+		try(challenge(), "wrong-code")
+	}
+	if code := try(challenge(), enrollment.RecoveryCodes[1]); code != http.StatusTooManyRequests {
+		t.Errorf("POST /api/login/2fa after wrong codes\ncode = %d\nwantCode = %d", code, http.StatusTooManyRequests)
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	server, apiCfg := newTestServerWithConfig(t)
+	apiCfg.requireVerifiedEmail = true
+	mailer := apiCfg.mailer.(*testMailer)
+
+	if code := doRequest(t, server, "POST", "/api/users", "", map[string]string{"email": "Alice <alice@example.com>", "password": "password"}, nil); code != http.StatusBadRequest {
+		t.Errorf("POST /api/users with an invalid email\ncode = %d\nwantCode = %d", code, http.StatusBadRequest)
+	}
+
+	alice := createTestUser(t, server, "alice@example.com")
+	if alice.EmailVerified {
+		t.Errorf("POST /api/users\nemailVerified = %v\nwant unverified", alice.EmailVerified)
+	}
+	link := mailer.lastVerificationLink(t, "alice@example.com")
+
+	chirp := map[string]string{"body": "Hello"}
+	if code := doRequest(t, server, "POST", "/api/chirps", alice.AccessToken, chirp, nil); code != http.StatusForbidden {
+		t.Errorf("POST /api/chirps before verifying\ncode = %d\nwantCode = %d", code, http.StatusForbidden)
+	}
+
+	if code := doRequest(t, server, "GET", "/api/users/verify?token=invalid", "", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("GET /api/users/verify with an invalid token\ncode = %d\nwantCode = %d", code, http.StatusBadRequest)
+	}
+
+	verified := User{}
+	if code := doRequest(t, server, "GET", link, "", nil, &verified); code != http.StatusOK || !verified.EmailVerified {
+		t.Fatalf("GET %s\ncode = %d\nuser = %+v", link, code, verified)
+	}
+
+	if code := doRequest(t, server, "POST", "/api/chirps", alice.AccessToken, chirp, nil); code != http.StatusCreated {
+		t.Errorf("POST /api/chirps after verifying\ncode = %d\nwantCode = %d", code, http.StatusCreated)
+	}
+	if code := doRequest(t, server, "POST", "/api/users/verify/resend", alice.AccessToken, nil, nil); code != http.StatusConflict {
+		t.Errorf("POST /api/users/verify/resend when verified\ncode = %d\nwantCode = %d", code, http.StatusConflict)
+	}
+
+	// A new address needs its own link, the old one doesn't verify it.
+	updated := User{}
+	doRequest(t, server, "PUT", "/api/users", alice.AccessToken, map[string]string{"email": "alice@example.org", "password": "password"}, &updated)
+	if updated.EmailVerified {
+		t.Errorf("PUT /api/users with a new email\nemailVerified = %v\nwant unverified", updated.EmailVerified)
+	}
+	if code := doRequest(t, server, "GET", link, "", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("GET /api/users/verify with the old link\ncode = %d\nwantCode = %d", code, http.StatusBadRequest)
+	}
+	if code := doRequest(t, server, "GET", mailer.lastVerificationLink(t, "alice@example.org"), "", nil, nil); code != http.StatusOK {
+		t.Errorf("GET /api/users/verify with the new link\ncode = %d\nwantCode = %d", code, http.StatusOK)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	server, apiCfg := newTestServerWithConfig(t)
+	mailer := apiCfg.mailer.(*testMailer)
+	alice := createTestUser(t, server, "alice@example.com")
+
+	forgot := func(email string) int {
+		code := doRequest(t, server, "POST", "/api/password/forgot", "", map[string]string{"email": email}, nil)
+		apiCfg.passwordResets.Wait()
+		return code
+	}
+
+	if code := forgot("nobody@example.com"); code != http.StatusAccepted {
+		t.Errorf("POST /api/password/forgot for an unknown email\ncode = %d\nwantCode = %d", code, http.StatusAccepted)
+	}
+	if sent := mailer.sentTo("nobody@example.com"); sent != 0 {
+		t.Errorf("POST /api/password/forgot for an unknown email\nsent = %d\nwantSent = 0", sent)
+	}
+
+	if code := forgot("alice@example.com"); code != http.StatusAccepted {
+		t.Fatalf("POST /api/password/forgot\ncode = %d\nwantCode = %d", code, http.StatusAccepted)
+	}
+	first := mailer.lastResetToken(t, "alice@example.com")
+	forgot("alice@example.com")
+	token := mailer.lastResetToken(t, "alice@example.com")
+
+	// Past the limit the response stays the same but no email goes out.
+	sent := mailer.sentTo("alice@example.com")
+	for range passwordResetLimit {
+		if code := forgot("alice@example.com"); code != http.StatusAccepted {
+			t.Errorf("POST /api/password/forgot over the limit\ncode = %d\nwantCode = %d", code, http.StatusAccepted)
+		}
+	}
+	if got := mailer.sentTo("alice@example.com") - sent; got != passwordResetLimit-2 {
+		t.Errorf("POST /api/password/forgot over the limit\nsent = %d\nwantSent = %d", got, passwordResetLimit-2)
+	}
+
+	reset := func(token, password string) int {
+		return doRequest(t, server, "POST", "/api/password/reset", "", map[string]string{"token": token, "password": password}, nil)
+	}
+
+	if code := reset("invalid", "new-password"); code != http.StatusBadRequest {
+		t.Errorf("POST /api/password/reset with an invalid token\ncode = %d\nwantCode = %d", code, http.StatusBadRequest)
+	}
+	if code := reset(token, "new-password"); code != http.StatusNoContent {
+		t.Fatalf("POST /api/password/reset\ncode = %d\nwantCode = %d", code, http.StatusNoContent)
+	}
+	if code := reset(token, "other-password"); code != http.StatusBadRequest {
+		t.Errorf("POST /api/password/reset with a used token\ncode = %d\nwantCode = %d", code, http.StatusBadRequest)
+	}
+	if code := reset(first, "other-password"); code != http.StatusBadRequest {
+		t.Errorf("POST /api/password/reset with an older token\ncode = %d\nwantCode = %d", code, http.StatusBadRequest)
+	}
+
+	if code := doRequest(t, server, "POST", "/api/refresh", alice.RefreshToken, nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("POST /api/refresh after the reset\ncode = %d\nwantCode = %d", code, http.StatusUnauthorized)
+	}
+	if code := doRequest(t, server, "GET", "/api/sessions", alice.AccessToken, nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("GET /api/sessions after the reset\ncode = %d\nwantCode = %d", code, http.StatusUnauthorized)
+	}
+
+	credentials := map[string]string{"email": "alice@example.com", "password": "password"}
+	if code := doRequest(t, server, "POST", "/api/login", "", credentials, nil); code != http.StatusUnauthorized {
+		t.Errorf("POST /api/login with the old password\ncode = %d\nwantCode = %d", code, http.StatusUnauthorized)
+	}
+	credentials["password"] = "new-password"
+	if code := doRequest(t, server, "POST", "/api/login", "", credentials, nil); code != http.StatusOK {
+		t.Errorf("POST /api/login with the new password\ncode = %d\nwantCode = %d", code, http.StatusOK)
+	}
+}
+
+func TestPasswordResetDropsWhenBusy(t *testing.T) {
+	server, apiCfg := newTestServerWithConfig(t)
+	mailer := apiCfg.mailer.(*testMailer)
+	createTestUser(t, server, "alice@example.com")
+
+	// Every slot is taken, as if that many resets were being sent.
+	verification := mailer.sentTo("alice@example.com")
+	for range cap(apiCfg.passwordResetSlots) {
+		apiCfg.passwordResetSlots <- struct{}{}
+	}
+
+	code := doRequest(t, server, "POST", "/api/password/forgot", "", map[string]string{"email": "alice@example.com"}, nil)
+	apiCfg.passwordResets.Wait()
+	if code != http.StatusAccepted {
+		t.Errorf("POST /api/password/forgot while busy\ncode = %d\nwantCode = %d", code, http.StatusAccepted)
+	}
+	if sent := mailer.sentTo("alice@example.com") - verification; sent != 0 {
+		t.Errorf("POST /api/password/forgot while busy\nsent = %d\nwantSent = 0", sent)
+	}
+
+	<-apiCfg.passwordResetSlots
+	doRequest(t, server, "POST", "/api/password/forgot", "", map[string]string{"email": "alice@example.com"}, nil)
+	apiCfg.passwordResets.Wait()
+	if sent := mailer.sentTo("alice@example.com") - verification; sent != 1 {
+		t.Errorf("POST /api/password/forgot with a free slot\nsent = %d\nwantSent = 1", sent)
+	}
+}
+func TestLoginThrottling(t *testing.T) {
+	server, apiCfg := newTestServerWithConfig(t)
+	apiCfg.accountThrottle = throttle.New(throttle.Policy{
+		FreeFailures:    3,
+		BaseDelay:       time.Hour,
+		MaxDelay:        time.Hour,
+		LockoutFailures: 3,
+		LockoutDuration: time.Hour,
+		ResetAfter:      time.Hour,
+	})
+	alice := createTestUser(t, server, "alice@example.com")
+	createTestUser(t, server, "admin@example.com")
+	apiCfg.db.SetUserAdmin(context.Background(), database.SetUserAdminParams{Email: "admin@example.com", IsAdmin: true})
+	admin := testLogin{}
+	doRequest(t, server, "POST", "/api/login", "", map[string]string{"email": "admin@example.com", "password": "password"}, &admin)
+
+	login := func(email, password string) (int, string) {
+		body := fmt.Sprintf(`{"email": %q, "password": %q}`, email, password)
+		res, err := server.Client().Post(server.URL+"/api/login", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("sending request: %v", err)
+		}
+		res.Body.Close()
+		return res.StatusCode, res.Header.Get("Retry-After")
+	}
+
+	// Known and unknown emails must be throttled the same way.
+	for _, email := range []string{"alice@example.com", "nobody@example.com"} {
+		for range 3 {
+			if code, _ := login(email, "wrong-password"); code != http.StatusUnauthorized {
+				t.Errorf("POST /api/login as %s with a wrong password\ncode = %d\nwantCode = %d", email, code, http.StatusUnauthorized)
+			}
+		}
+		code, retryAfter := login(email, "password")
+		if code != http.StatusTooManyRequests || retryAfter != "3600" {
+			t.Errorf("POST /api/login as %s when locked out\ncode = %d\nretryAfter = %s\nwantCode = %d\nwantRetryAfter = 3600", email, code, retryAfter, http.StatusTooManyRequests)
+		}
+	}
+
+	unlock := func(token, email string) int {
+		return doRequest(t, server, "POST", "/admin/users/unlock", token, map[string]string{"email": email}, nil)
+	}
+
+	if code := unlock(alice.AccessToken, "alice@example.com"); code != http.StatusForbidden {
+		t.Errorf("POST /admin/users/unlock as a user\ncode = %d\nwantCode = %d", code, http.StatusForbidden)
+	}
+	if code := unlock(admin.AccessToken, "nobody@example.com"); code != http.StatusNotFound {
+		t.Errorf("POST /admin/users/unlock for an unknown email\ncode = %d\nwantCode = %d", code, http.StatusNotFound)
+	}
+	if code := unlock(admin.AccessToken, "alice@example.com"); code != http.StatusNoContent {
+		t.Fatalf("POST /admin/users/unlock\ncode = %d\nwantCode = %d", code, http.StatusNoContent)
+	}
+	if code, _ := login("alice@example.com", "password"); code != http.StatusOK {
+		t.Errorf("POST /api/login after the unlock\ncode = %d\nwantCode = %d", code, http.StatusOK)
+	}
+
+	// Spreading the guesses over many emails still runs into the limit of
+	// the address.
+	apiCfg.ipThrottle = throttle.New(throttle.Policy{LockoutFailures: 2, LockoutDuration: time.Hour, ResetAfter: time.Hour})
+	login("bob@example.com", "wrong-password")
+	login("carol@example.com", "wrong-password")
+	if code, _ := login("admin@example.com", "password"); code != http.StatusTooManyRequests {
+		t.Errorf("POST /api/login from a locked out address\ncode = %d\nwantCode = %d", code, http.StatusTooManyRequests)
+	}
+
+	// Guesses sent in parallel get no more tries than ones sent one after
+	// another.
+	apiCfg.accountThrottle = throttle.New(accountLoginPolicy)
+	apiCfg.ipThrottle = throttle.New(ipLoginPolicy)
+	var wg sync.WaitGroup
+	codes := make(chan int, 10)
+	for range cap(codes) {
+		wg.Go(func() {
+			body := `{"email": "dave@example.com", "password": "wrong-password"}`
+			res, err := server.Client().Post(server.URL+"/api/login", "application/json", strings.NewReader(body))
+			if err != nil {
+				t.Errorf("sending request: %v", err)
+				return
+			}
+			res.Body.Close()
+			codes <- res.StatusCode
+		})
+	}
+	wg.Wait()
+	close(codes)
+
+	tried := 0
+	for code := range codes {
+		if code == http.StatusUnauthorized {
+			tried++
+		}
+	}
+	if tried != accountLoginPolicy.FreeFailures+1 {
+		t.Errorf("POST /api/login in parallel\ntried = %d\nwantTried = %d", tried, accountLoginPolicy.FreeFailures+1)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name               string
+		trustedProxyHeader string
+		header             map[string]string
+		wantIP             string
+	}{
+		{"Remote address", "", nil, "192.0.2.1"},
+		{"Untrusted header", "", map[string]string{"X-Forwarded-For": "203.0.113.7"}, "192.0.2.1"},
+		{"Trusted header", "X-Real-IP", map[string]string{"X-Real-IP": "203.0.113.7"}, "203.0.113.7"},
+		{"Hop added by the proxy", "X-Forwarded-For", map[string]string{"X-Forwarded-For": "198.51.100.9, 203.0.113.7"}, "203.0.113.7"},
+		{"Missing trusted header", "X-Forwarded-For", nil, "192.0.2.1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ac := &apiConfig{trustedProxyHeader: test.trustedProxyHeader}
+			r := httptest.NewRequest("POST", "/api/login", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			for key, value := range test.header {
+				r.Header.Set(key, value)
+			}
+
+			if ip := ac.clientIP(r); ip != test.wantIP {
+				t.Errorf("clientIP()\nip = %v\nwantIP = %v", ip, test.wantIP)
+			}
+		})
+	}
+}
+func TestPasswordPolicy(t *testing.T) {
+	server, apiCfg := newTestServerWithConfig(t)
+	breached, _ := auth.ReadBreachedPasswords(strings.NewReader("21BD12DC183F740EE76F27B78EB39C8AD972A757:12\n"))
+	apiCfg.passwordPolicy = auth.PasswordPolicy{MinLength: 8, MinClasses: 2, Breached: breached}
+
+	type response struct {
+		Error      string                   `json:"error"`
+		Violations []auth.PasswordViolation `json:"violations"`
+	}
+
+	tests := []struct {
+		name      string
+		password  string
+		wantCode  int
+		wantRules []string
+	}{
+		{"Empty password", "", http.StatusUnprocessableEntity, []string{auth.PasswordRuleMinLength, auth.PasswordRuleMinClasses}},
+		{"Contains the email", "alice-2024", http.StatusUnprocessableEntity, []string{auth.PasswordRuleEmail}},
+		{"Breached password", "P@ssw0rd", http.StatusUnprocessableEntity, []string{auth.PasswordRuleBreached}},
+		{"Strong password", "correct-horse-battery", http.StatusCreated, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := response{}
+			code := doRequest(t, server, "POST", "/api/users", "", map[string]string{"email": "alice@example.com", "password": test.password}, &resp)
+			if code != test.wantCode {
+				t.Fatalf("POST /api/users\ncode = %d\nwantCode = %d", code, test.wantCode)
+			}
+
+			var rules []string
+			for _, violation := range resp.Violations {
+				rules = append(rules, violation.Rule)
+				if violation.Message == "" {
+					t.Errorf("POST /api/users\nviolation = %+v\nwant a message", violation)
+				}
+			}
+			if !slices.Equal(rules, test.wantRules) {
+				t.Errorf("POST /api/users\nrules = %v\nwantRules = %v", rules, test.wantRules)
+			}
+		})
+	}
+
+	login := testLogin{}
+	doRequest(t, server, "POST", "/api/login", "", map[string]string{"email": "alice@example.com", "password": "correct-horse-battery"}, &login)
+	if code := doRequest(t, server, "PUT", "/api/users", login.AccessToken, map[string]string{"email": "alice@example.com", "password": "short"}, nil); code != http.StatusUnprocessableEntity {
+		t.Errorf("PUT /api/users with a weak password\ncode = %d\nwantCode = %d", code, http.StatusUnprocessableEntity)
+	}
+
+	// A rejected password must not use up the reset token.
+	mailer := apiCfg.mailer.(*testMailer)
+	doRequest(t, server, "POST", "/api/password/forgot", "", map[string]string{"email": "alice@example.com"}, nil)
+	apiCfg.passwordResets.Wait()
+	token := mailer.lastResetToken(t, "alice@example.com")
+	if code := doRequest(t, server, "POST", "/api/password/reset", "", map[string]string{"token": token, "password": "short"}, nil); code != http.StatusUnprocessableEntity {
+		t.Errorf("POST /api/password/reset with a weak password\ncode = %d\nwantCode = %d", code, http.StatusUnprocessableEntity)
+	}
+	if code := doRequest(t, server, "POST", "/api/password/reset", "", map[string]string{"token": token, "password": "Alice-Passphrase"}, nil); code != http.StatusUnprocessableEntity {
+		t.Errorf("POST /api/password/reset with the email in the password\ncode = %d\nwantCode = %d", code, http.StatusUnprocessableEntity)
+	}
+	if code := doRequest(t, server, "POST", "/api/password/reset", "", map[string]string{"token": token, "password": "Another-Passphrase"}, nil); code != http.StatusNoContent {
+		t.Errorf("POST /api/password/reset\ncode = %d\nwantCode = %d", code, http.StatusNoContent)
+	}
+}
+
+func TestPasswordRehash(t *testing.T) {
+	server, apiCfg := newTestServerWithConfig(t)
+	createTestUser(t, server, "alice@example.com")
+
+	before, _ := apiCfg.db.GetUserByEmail(context.Background(), "alice@example.com")
+	apiCfg.passwordParams = auth.PasswordParams{Memory: 2048, Iterations: 2, Parallelism: 1}
+
+	credentials := map[string]string{"email": "alice@example.com", "password": "password"}
+	for range 2 {
+		if code := doRequest(t, server, "POST", "/api/login", "", credentials, nil); code != http.StatusOK {
+			t.Fatalf("POST /api/login\ncode = %d\nwantCode = %d", code, http.StatusOK)
+		}
+	}
+
+	after, _ := apiCfg.db.GetUserByEmail(context.Background(), "alice@example.com")
+	if after.HashedPassword == before.HashedPassword {
+		t.Fatalf("POST /api/login with older parameters\nwant the hash replaced")
+	}
+	match, rehash, err := auth.CheckPasswordHash("password", after.HashedPassword, apiCfg.passwordParams)
+	if err != nil || !match || rehash {
+		t.Errorf("CheckPasswordHash() after the rehash\nmatch = %v\nrehash = %v\nerror = %v", match, rehash, err)
+	}
+}
+
+func TestHashPoolShedsLoad(t *testing.T) {
+	server, apiCfg := newTestServerWithConfig(t)
+	alice := createTestUser(t, server, "alice@example.com")
+
+	// A pool without workers or queue turns every hash away. Any failed
+	// login would now delay the next one.
+	apiCfg.hashPool = auth.NewHashPool(0, 0)
+	apiCfg.accountThrottle = throttle.New(throttle.Policy{BaseDelay: time.Hour, MaxDelay: time.Hour, ResetAfter: time.Hour})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   map[string]string
+	}{
+		{"Signup", "POST", "/api/users", "", map[string]string{"email": "bob@example.com", "password": "password"}},
+		{"Login", "POST", "/api/login", "", map[string]string{"email": "alice@example.com", "password": "password"}},
+		{"Login with an unknown email", "POST", "/api/login", "", map[string]string{"email": "nobody@example.com", "password": "password"}},
+		{"Password change", "PUT", "/api/users", alice.AccessToken, map[string]string{"email": "alice@example.com", "password": "new-password"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, _ := json.Marshal(test.body)
+			req, _ := http.NewRequest(test.method, server.URL+test.path, bytes.NewReader(body))
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			res, err := server.Client().Do(req)
+			if err != nil {
+				t.Fatalf("sending request: %v", err)
+			}
+			res.Body.Close()
+
+			if res.StatusCode != http.StatusServiceUnavailable || res.Header.Get("Retry-After") == "" {
+				t.Errorf("%s %s\ncode = %d\nretryAfter = %q\nwantCode = %d", test.method, test.path, res.StatusCode, res.Header.Get("Retry-After"), http.StatusServiceUnavailable)
+			}
+		})
+	}
+
+	// Shed logins must not count as failures.
+	apiCfg.hashPool = auth.NewHashPool(1, 1)
+	t.Cleanup(apiCfg.hashPool.Close)
+	if code := doRequest(t, server, "POST", "/api/login", "", map[string]string{"email": "alice@example.com", "password": "password"}, nil); code != http.StatusOK {
+		t.Errorf("POST /api/login once the pool has room\ncode = %d\nwantCode = %d", code, http.StatusOK)
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	server := newTestServer(t)
+	alice := createTestUser(t, server, "alice@example.com")
+	createTestUser(t, server, "bob@example.com")
+
+	credentials := map[string]string{"email": "bob@example.com", "password": "password"}
+	if code := doRequest(t, server, "POST", "/api/users", "", credentials, nil); code != http.StatusConflict {
+		t.Errorf("POST /api/users with a taken email\ncode = %d\nwantCode = %d", code, http.StatusConflict)
+	}
+
+	tests := []struct {
+		name     string
+		params   map[string]string
+		wantCode int
+	}{
+		{"Taken email", map[string]string{"email": "bob@example.com", "password": "new-password"}, http.StatusConflict},
+		// Clients written before PATCH existed don't send the current password.
+		{"Email and password", map[string]string{"email": "alice@example.org", "password": "new-password"}, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if code := doRequest(t, server, "PUT", "/api/users", alice.AccessToken, test.params, nil); code != test.wantCode {
+				t.Errorf("PUT /api/users\ncode = %d\nwantCode = %d", code, test.wantCode)
+			}
+		})
+	}
+
+	login := map[string]string{"email": "alice@example.org", "password": "new-password"}
+	if code := doRequest(t, server, "POST", "/api/login", "", login, nil); code != http.StatusOK {
+		t.Errorf("POST /api/login with the new email and password\ncode = %d\nwantCode = %d", code, http.StatusOK)
+	}
+}
+
+func TestPatchUser(t *testing.T) {
+	server, apiCfg := newTestServerWithConfig(t)
+	mailer := apiCfg.mailer.(*testMailer)
+	alice := createTestUser(t, server, "alice@example.com")
+	createTestUser(t, server, "bob@example.com")
+
+	second := testLogin{}
+	doRequest(t, server, "POST", "/api/login", "", map[string]string{"email": "alice@example.com", "password": "password"}, &second)
+
+	tests := []struct {
+		name      string
+		patch     map[string]any
+		wantCode  int
+		wantEmail string
+	}{
+		{"Empty patch", map[string]any{}, http.StatusOK, "alice@example.com"},
+		{"Missing current password", map[string]any{"email": "alice@example.org"}, http.StatusBadRequest, ""},
+		{"Wrong current password", map[string]any{"email": "alice@example.org", "current_password": "wrong"}, http.StatusForbidden, ""},
+		{"Removed email", map[string]any{"email": nil, "current_password": "password"}, http.StatusBadRequest, ""},
+		{"Invalid email", map[string]any{"email": "alice", "current_password": "password"}, http.StatusBadRequest, ""},
+		{"Taken email", map[string]any{"email": "bob@example.com", "current_password": "password"}, http.StatusConflict, ""},
+		{"Weak password", map[string]any{"password": "short", "current_password": "password"}, http.StatusUnprocessableEntity, ""},
+		{"Weak password with a handle", map[string]any{"handle": "alice", "password": "short", "current_password": "password"}, http.StatusUnprocessableEntity, ""},
+		{"Email only", map[string]any{"email": "alice@example.org", "current_password": "password"}, http.StatusOK, "alice@example.org"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			updated := User{}
+			code := doRequest(t, server, "PATCH", "/api/users", alice.AccessToken, test.patch, &updated)
+			if code != test.wantCode {
+				t.Errorf("PATCH /api/users\ncode = %d\nwantCode = %d", code, test.wantCode)
+			}
+			if test.wantEmail != "" && updated.Email != test.wantEmail {
+				t.Errorf("PATCH /api/users\nemail = %q\nwantEmail = %q", updated.Email, test.wantEmail)
+			}
+		})
+	}
+
+	// A rejected patch changes nothing, not even the parts that were valid.
+	if code := doRequest(t, server, "GET", "/api/users/alice", "", nil, nil); code != http.StatusNotFound {
+		t.Errorf("GET /api/users/alice after a rejected patch\ncode = %d\nwantCode = %d", code, http.StatusNotFound)
+	}
+
+	if sent := mailer.sentTo("alice@example.org"); sent != 1 {
+		t.Errorf("PATCH /api/users with a new email\nsent = %d\nwantSent = 1", sent)
+	}
+
+	// Changing only the email keeps the password and every session.
+	if code := doRequest(t, server, "POST", "/api/refresh", second.RefreshToken, nil, &second); code != http.StatusOK {
+		t.Errorf("POST /api/refresh after an email change\ncode = %d\nwantCode = %d", code, http.StatusOK)
+	}
+
+	patch := map[string]any{"password": "new-password", "current_password": "password"}
+	if code := doRequest(t, server, "PATCH", "/api/users", alice.AccessToken, patch, nil); code != http.StatusOK {
+		t.Fatalf("PATCH /api/users with a new password\ncode = %d\nwantCode = %d", code, http.StatusOK)
+	}
+
+	if code := doRequest(t, server, "POST", "/api/refresh", second.RefreshToken, nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("POST /api/refresh for another session after a password change\ncode = %d\nwantCode = %d", code, http.StatusUnauthorized)
+	}
+	if code := doRequest(t, server, "GET", "/api/sessions", second.AccessToken, nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("GET /api/sessions for another session after a password change\ncode = %d\nwantCode = %d", code, http.StatusUnauthorized)
+	}
+	if code := doRequest(t, server, "POST", "/api/refresh", alice.RefreshToken, nil, nil); code != http.StatusOK {
+		t.Errorf("POST /api/refresh for the current session after a password change\ncode = %d\nwantCode = %d", code, http.StatusOK)
+	}
+
+	credentials := map[string]string{"email": "alice@example.org", "password": "new-password"}
+	if code := doRequest(t, server, "POST", "/api/login", "", credentials, nil); code != http.StatusOK {
+		t.Errorf("POST /api/login with the new password\ncode = %d\nwantCode = %d", code, http.StatusOK)
+	}
+}
+
+func TestUserProfiles(t *testing.T) {
+	server := newTestServer(t)
+	alice := createTestUser(t, server, "alice@example.com")
+	bob := createTestUser(t, server, "bob@example.com")
+
+	profile := map[string]any{
+		"handle":       "Alice_1",
+		"display_name": "Alice Liddell",
+		"bio":          "Down the rabbit hole",
+		"avatar_url":   "https://example.com/alice.png",
+	}
+	updated := User{}
+	if code := doRequest(t, server, "PATCH", "/api/users", alice.AccessToken, profile, &updated); code != http.StatusOK || updated.Handle != "Alice_1" || updated.DisplayName != "Alice Liddell" {
+		t.Fatalf("PATCH /api/users with a profile\ncode = %d\nuser = %+v", code, updated)
+	}
+
+	patches := []struct {
+		name     string
+		patch    map[string]any
+		wantCode int
+	}{
+		{"Invalid handle", map[string]any{"handle": "a!"}, http.StatusBadRequest},
+		{"Reserved handle", map[string]any{"handle": "Verify"}, http.StatusConflict},
+		{"Taken handle in another case", map[string]any{"handle": "alice_1"}, http.StatusConflict},
+		{"Long bio", map[string]any{"bio": strings.Repeat("a", maxBioLength+1)}, http.StatusBadRequest},
+		{"Insecure avatar", map[string]any{"avatar_url": "http://example.com/bob.png"}, http.StatusBadRequest},
+		{"Display name only", map[string]any{"display_name": "Bob"}, http.StatusOK},
+	}
+
+	for _, test := range patches {
+		t.Run(test.name, func(t *testing.T) {
+			if code := doRequest(t, server, "PATCH", "/api/users", bob.AccessToken, test.patch, nil); code != test.wantCode {
+				t.Errorf("PATCH /api/users\ncode = %d\nwantCode = %d", code, test.wantCode)
+			}
+		})
+	}
+
+	lookups := []struct {
+		name       string
+		idOrHandle string
+		wantCode   int
+	}{
+		{"By handle", "alice_1", http.StatusOK},
+		{"By id", alice.ID.String(), http.StatusOK},
+		{"Unknown handle", "nobody", http.StatusNotFound},
+		{"Unknown id", uuid.NewString(), http.StatusNotFound},
+	}
+
+	for _, test := range lookups {
+		t.Run(test.name, func(t *testing.T) {
+			resp := map[string]any{}
+			code := doRequest(t, server, "GET", "/api/users/"+test.idOrHandle, "", nil, &resp)
+			if code != test.wantCode {
+				t.Errorf("GET /api/users/%s\ncode = %d\nwantCode = %d", test.idOrHandle, code, test.wantCode)
+			}
+			if code != http.StatusOK {
+				return
+			}
+			if resp["handle"] != "Alice_1" || resp["bio"] != "Down the rabbit hole" {
+				t.Errorf("GET /api/users/%s\nprofile = %v\nwant Alice's profile", test.idOrHandle, resp)
+			}
+			if _, ok := resp["email"]; ok {
+				t.Errorf("GET /api/users/%s\nprofile = %v\nwant no email", test.idOrHandle, resp)
+			}
+		})
+	}
+
+	handles := []struct {
+		handle        string
+		wantCode      int
+		wantAvailable bool
+	}{
+		{"ALICE_1", http.StatusOK, false},
+		{"verify", http.StatusOK, false},
+		{"bob", http.StatusOK, true},
+		{"b", http.StatusBadRequest, false},
+	}
+
+	for _, test := range handles {
+		t.Run("Handle "+test.handle, func(t *testing.T) {
+			resp := struct {
+				Available bool `json:"available"`
+			}{}
+			code := doRequest(t, server, "GET", "/api/users/handles/"+test.handle, "", nil, &resp)
+			if code != test.wantCode || resp.Available != test.wantAvailable {
+				t.Errorf("GET /api/users/handles/%s\ncode = %d\nwantCode = %d\navailable = %v\nwantAvailable = %v", test.handle, code, test.wantCode, resp.Available, test.wantAvailable)
+			}
+		})
+	}
+
+	created := Chirp{}
+	doRequest(t, server, "POST", "/api/chirps", alice.AccessToken, map[string]string{"body": "Hello"}, &created)
+	if created.Author.Handle != "Alice_1" || created.Author.AvatarUrl != "https://example.com/alice.png" {
+		t.Errorf("POST /api/chirps\nauthor = %+v\nwant Alice's profile", created.Author)
+	}
+	chirps := []Chirp{}
+	doRequest(t, server, "GET", "/api/chirps", "", nil, &chirps)
+	if len(chirps) != 1 || chirps[0].Author.Id != alice.ID || chirps[0].Author.DisplayName != "Alice Liddell" {
+		t.Errorf("GET /api/chirps\nchirps = %+v\nwant Alice as the author", chirps)
+	}
+
+	// Removing the handle frees it for someone else.
+	removed := User{}
+	doRequest(t, server, "PATCH", "/api/users", alice.AccessToken, map[string]any{"handle": nil}, &removed)
+	if removed.Handle != "" {
+		t.Errorf("PATCH /api/users removing the handle\nhandle = %q\nwant none", removed.Handle)
+	}
+	if code := doRequest(t, server, "PATCH", "/api/users", bob.AccessToken, map[string]any{"handle": "alice_1"}, nil); code != http.StatusOK {
+		t.Errorf("PATCH /api/users with a freed handle\ncode = %d\nwantCode = %d", code, http.StatusOK)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	server, apiCfg := newTestServerWithConfig(t)
+	before := createTestUser(t, server, "alice@example.com")
+
+	if _, err := apiCfg.keyring.Rotate(auth.AlgorithmEdDSA, 0, time.Hour); err != nil {
+		t.Fatalf("Rotate()\nerror = %v", err)
+	}
+	after := createTestUser(t, server, "bob@example.com")
+
+	for _, token := range []string{before.AccessToken, after.AccessToken} {
+		code := doRequest(t, server, "POST", "/api/chirps", token, map[string]string{"body": "hello"}, nil)
+		if code != http.StatusCreated {
+			t.Errorf("POST /api/chirps\ncode = %d\nwantCode = %d", code, http.StatusCreated)
+		}
+	}
+
+	jwks := auth.JWKSet{}
+	code := doRequest(t, server, "GET", "/.well-known/jwks.json", "", nil, &jwks)
+	if code != http.StatusOK || len(jwks.Keys) != 1 || jwks.Keys[0].KeyType != "OKP" {
+		t.Errorf("GET /.well-known/jwks.json\ncode = %d\nkeys = %+v\nwant the Ed25519 key only", code, jwks.Keys)
+	}
+}
+
+func TestVerificationLinkOutlivesRotation(t *testing.T) {
+	server, apiCfg := newTestServerWithConfig(t)
+	mailer := apiCfg.mailer.(*testMailer)
+	createTestUser(t, server, "alice@example.com")
+	link := mailer.lastVerificationLink(t, "alice@example.com")
+
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	if err := runKeys(path, "secret", []string{"rotate"}); err != nil {
+		t.Fatalf("runKeys()\nerror = %v", err)
+	}
+	if err := apiCfg.keyring.Reload(path); err != nil {
+		t.Fatalf("Reload()\nerror = %v", err)
+	}
+
+	// The retired key signed the link, so it must stay valid as long as
+	// the link does.
+	retired := apiCfg.keyring.Keys()[0]
+	if time.Until(retired.ExpiresAt) < emailVerificationExpiration {
+		t.Errorf("Keys()\nexpiresAt = %v\nwant at least %v from now", retired.ExpiresAt, emailVerificationExpiration)
+	}
+
+	verified := User{}
+	if code := doRequest(t, server, "GET", link, "", nil, &verified); code != http.StatusOK || !verified.EmailVerified {
+		t.Errorf("GET %s after a rotation\ncode = %d\nuser = %+v", link, code, verified)
+	}
+}
+
+func TestRunKeysTakesOverFromSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	userID := uuid.New()
+	legacyToken, _ := auth.MakeJWT(userID, "secret", time.Hour)
+
+	if err := runKeys(path, "secret", []string{"rotate", auth.AlgorithmRS256}); err != nil {
+		t.Fatalf("runKeys()\nerror = %v", err)
+	}
+
+	keyring, err := loadKeyring(path, "")
+	if err != nil {
+		t.Fatalf("loadKeyring()\nerror = %v", err)
+	}
+	if id, err := keyring.ValidateJWT(legacyToken); err != nil || id != userID {
+		t.Errorf("ValidateJWT() with the SECRET token\nuserId = %v\nerror = %v", id, err)
+	}
+
+	// The new key waits for every server to reload the keyring, and the
+	// SECRET key stays valid for the longest token lifetime after that.
+	keys := keyring.Keys()
+	if len(keys) != 2 {
+		t.Fatalf("Keys()\nlen = %d\nwantLen = 2", len(keys))
+	}
+	if wait := time.Until(keys[1].ActivatesAt); wait <= 0 || wait > keyringReloadInterval {
+		t.Errorf("Keys()\nactivatesIn = %v\nwantActivatesIn <= %v", wait, keyringReloadInterval)
+	}
+	if retired := keys[0].ExpiresAt.Sub(keys[1].ActivatesAt); retired != maxSignedTokenLifetime {
+		t.Errorf("Keys()\nretiredFor = %v\nwantRetiredFor = %v", retired, maxSignedTokenLifetime)
+	}
+
+	pendingToken, _ := keyring.MakeJWT(userID, uuid.NewString(), time.Hour)
+	if _, err := auth.ValidateJWT(pendingToken, "secret"); err != nil {
+		t.Errorf("ValidateJWT() of a token signed before the new key activates\nerror = %v", err)
+	}
+}
+
+func TestChirpPagination(t *testing.T) {
+	server := newTestServer(t)
+	alice := createTestUser(t, server, "alice@example.com")
+
+	for i := range 5 {
+		body := map[string]string{"body": fmt.Sprintf("chirp %d", i)}
+		doRequest(t, server, "POST", "/api/chirps", alice.AccessToken, body, nil)
+	}
+
+	bodies := []string{}
+	next := "/api/chirps?limit=2"
+	for next != "" {
+		res, err := server.Client().Get(server.URL + next)
+		if err != nil {
+			t.Fatalf("GET %s\nerror = %v", next, err)
+		}
+
+		page := []Chirp{}
+		json.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if len(page) > 2 {
+			t.Fatalf("GET %s\nlen = %d\nwantLen <= 2", next, len(page))
+		}
+		for _, chirp := range page {
+			bodies = append(bodies, chirp.Body)
+		}
+
+		next = ""
+		if link := res.Header.Get("Link"); link != "" {
+			next = strings.TrimPrefix(strings.Split(link, ">")[0], "<")
+		}
+	}
+
+	want := []string{"chirp 0", "chirp 1", "chirp 2", "chirp 3", "chirp 4"}
+	if !slices.Equal(bodies, want) {
+		t.Errorf("GET /api/chirps\nbodies = %v\nwantBodies = %v", bodies, want)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		wantCode int
+	}{
+		{"Invalid limit", "?limit=zero", http.StatusBadRequest},
+		{"Negative limit", "?limit=-1", http.StatusBadRequest},
+		{"Invalid cursor", "?cursor=not-a-cursor", http.StatusBadRequest},
+		{"Limit above maximum", "?limit=1000", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code := doRequest(t, server, "GET", "/api/chirps"+test.query, "", nil, nil)
+			if code != test.wantCode {
+				t.Errorf("GET /api/chirps%s\ncode = %d\nwantCode = %d", test.query, code, test.wantCode)
+			}
+		})
+	}
+}
+
+func TestChirpFilters(t *testing.T) {
+	server := newTestServer(t)
+	alice := createTestUser(t, server, "alice@example.com")
+	bob := createTestUser(t, server, "bob@example.com")
+
+	doRequest(t, server, "POST", "/api/chirps", alice.AccessToken, map[string]string{"body": "alice 1"}, nil)
+	doRequest(t, server, "POST", "/api/chirps", bob.AccessToken, map[string]string{"body": "bob 1"}, nil)
+	doRequest(t, server, "POST", "/api/chirps", alice.AccessToken, map[string]string{"body": "alice 2"}, nil)
+
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	tests := []struct {
+		name       string
+		query      string
+		wantCode   int
+		wantBodies []string
+	}{
+		{"Default order", "", http.StatusOK, []string{"alice 1", "bob 1", "alice 2"}},
+		{"Descending", "?sort=desc", http.StatusOK, []string{"alice 2", "bob 1", "alice 1"}},
+		{"By author", "?author_id=" + alice.ID.String(), http.StatusOK, []string{"alice 1", "alice 2"}},
+		{"By author descending", "?author_id=" + alice.ID.String() + "&sort=desc", http.StatusOK, []string{"alice 2", "alice 1"}},
+		{"Until before everything", "?until=2000-01-01T00:00:00Z", http.StatusOK, []string{}},
+		{"Since in the future", "?since=" + future, http.StatusOK, []string{}},
+		{"Invalid sort", "?sort=sideways", http.StatusBadRequest, nil},
+		{"Invalid author", "?author_id=alice", http.StatusBadRequest, nil},
+		{"Invalid since", "?since=yesterday", http.StatusBadRequest, nil},
+		{"Since after until", "?since=2001-01-01T00:00:00Z&until=2000-01-01T00:00:00Z", http.StatusBadRequest, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chirps := []Chirp{}
+			var out any
+			if test.wantCode == http.StatusOK {
+				out = &chirps
+			}
+
+			code := doRequest(t, server, "GET", "/api/chirps"+test.query, "", nil, out)
+			if code != test.wantCode {
+				t.Fatalf("GET /api/chirps%s\ncode = %d\nwantCode = %d", test.query, code, test.wantCode)
+			}
+			if test.wantBodies == nil {
+				return
+			}
+
+			bodies := []string{}
+			for _, chirp := range chirps {
+				bodies = append(bodies, chirp.Body)
+			}
+			if !slices.Equal(bodies, test.wantBodies) {
+				t.Errorf("GET /api/chirps%s\nbodies = %v\nwantBodies = %v", test.query, bodies, test.wantBodies)
+			}
+		})
+	}
+}
+
+func TestSearchChirps(t *testing.T) {
+	server := newTestServer(t)
+	alice := createTestUser(t, server, "alice@example.com")
+
+	doRequest(t, server, "POST", "/api/chirps", alice.AccessToken, map[string]string{"body": "Hello world"}, nil)
+	doRequest(t, server, "POST", "/api/chirps", alice.AccessToken, map[string]string{"body": "Goodbye moon"}, nil)
+
+	results := []ChirpSearchResult{}
+	code := doRequest(t, server, "GET", "/api/chirps/search?q=wor*", "", nil, &results)
+	if code != http.StatusOK {
+		t.Fatalf("GET /api/chirps/search\ncode = %d\nwantCode = %d", code, http.StatusOK)
+	}
+	if len(results) != 1 || results[0].Snippet != "Hello <mark>world</mark>" {
+		t.Errorf("GET /api/chirps/search\nresults = %v", results)
+	}
+
+	// A cursor only pages through the listing that made it.
+	listCursor := (cursor{Kind: cursorKindList, CreatedAt: time.Now(), Id: uuid.New()}).encode()
+	searchCursor := (cursor{Kind: cursorKindSearch, Rank: 0.5, CreatedAt: time.Now(), Id: uuid.New()}).encode()
+	if code := doRequest(t, server, "GET", "/api/chirps?cursor="+searchCursor, "", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("GET /api/chirps with a search cursor\ncode = %d\nwantCode = %d", code, http.StatusBadRequest)
+	}
+	if code := doRequest(t, server, "GET", "/api/chirps/search?q=hello&cursor="+searchCursor, "", nil, nil); code != http.StatusOK {
+		t.Errorf("GET /api/chirps/search with a search cursor\ncode = %d\nwantCode = %d", code, http.StatusOK)
+	}
+
+	for _, query := range []string{"", "?q=%22unclosed", "?q=hello&sort=desc", "?q=hello&author_id=alice", "?q=hello&cursor=" + listCursor} {
+		code := doRequest(t, server, "GET", "/api/chirps/search"+query, "", nil, nil)
+		if code != http.StatusBadRequest {
+			t.Errorf("GET /api/chirps/search%s\ncode = %d\nwantCode = %d", query, code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestSoftDeleteAndRestore(t *testing.T) {
+	server, apiCfg := newTestServerWithConfig(t)
+	alice := createTestUser(t, server, "alice@example.com")
+	bob := createTestUser(t, server, "bob@example.com")
+
+	chirp := Chirp{}
+	doRequest(t, server, "POST", "/api/chirps", alice.AccessToken, map[string]string{"body": "oops"}, &chirp)
+	path := "/api/chirps/" + chirp.Id.String()
+
+	if code := doRequest(t, server, "DELETE", path, alice.AccessToken, nil, nil); code != http.StatusNoContent {
+		t.Fatalf("DELETE %s\ncode = %d\nwantCode = %d", path, code, http.StatusNoContent)
+	}
+	if code := doRequest(t, server, "GET", path, "", nil, nil); code != http.StatusNotFound {
+		t.Errorf("GET %s\ncode = %d\nwantCode = %d", path, code, http.StatusNotFound)
+	}
+	if code := doRequest(t, server, "DELETE", path, alice.AccessToken, nil, nil); code != http.StatusNotFound {
+		t.Errorf("DELETE %s again\ncode = %d\nwantCode = %d", path, code, http.StatusNotFound)
+	}
+
+	if code := doRequest(t, server, "POST", path+"/restore", bob.AccessToken, nil, nil); code != http.StatusForbidden {
+		t.Errorf("POST %s/restore by another user\ncode = %d\nwantCode = %d", path, code, http.StatusForbidden)
+	}
+	if code := doRequest(t, server, "POST", path+"/restore", alice.AccessToken, nil, nil); code != http.StatusOK {
+		t.Fatalf("POST %s/restore\ncode = %d\nwantCode = %d", path, code, http.StatusOK)
+	}
+	if code := doRequest(t, server, "GET", path, "", nil, nil); code != http.StatusOK {
+		t.Errorf("GET %s after restore\ncode = %d\nwantCode = %d", path, code, http.StatusOK)
+	}
+	if code := doRequest(t, server, "POST", path+"/restore", alice.AccessToken, nil, nil); code != http.StatusConflict {
+		t.Errorf("POST %s/restore twice\ncode = %d\nwantCode = %d", path, code, http.StatusConflict)
+	}
+
+	doRequest(t, server, "DELETE", path, alice.AccessToken, nil, nil)
+	apiCfg.restoreWindow = time.Nanosecond
+	if code := doRequest(t, server, "POST", path+"/restore", alice.AccessToken, nil, nil); code != http.StatusGone {
+		t.Errorf("POST %s/restore after the window\ncode = %d\nwantCode = %d", path, code, http.StatusGone)
+	}
+}
+
+func TestEditChirp(t *testing.T) {
+	server := newTestServer(t)
+	alice := createTestUser(t, server, "alice@example.com")
+	bob := createTestUser(t, server, "bob@example.com")
+
+	chirp := Chirp{}
+	doRequest(t, server, "POST", "/api/chirps", alice.AccessToken, map[string]string{"body": "first"}, &chirp)
+	path := "/api/chirps/" + chirp.Id.String()
+	if chirp.Edited {
+		t.Errorf("POST /api/chirps\nedited = true\nwantEdited = false")
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		body     string
+		wantCode int
+	}{
+		{"Author edits", alice.AccessToken, "second kerfuffle", http.StatusOK},
+		{"Another user edits", bob.AccessToken, "hijacked", http.StatusForbidden},
+		{"Too long", alice.AccessToken, strings.Repeat("a", maxChirpLength+1), http.StatusBadRequest},
+		{"Author edits again", alice.AccessToken, "third", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code := doRequest(t, server, "PUT", path, test.token, map[string]string{"body": test.body}, nil)
+			if code != test.wantCode {
+				t.Errorf("PUT %s\ncode = %d\nwantCode = %d", path, code, test.wantCode)
+			}
+		})
+	}
+
+	edited := Chirp{}
+	doRequest(t, server, "GET", path, "", nil, &edited)
+	if edited.Body != "third" || !edited.Edited {
+		t.Errorf("GET %s\nchirp = %v\nwantBody = third", path, edited)
+	}
+
+	revisions := []ChirpRevision{}
+	doRequest(t, server, "GET", path+"/revisions", "", nil, &revisions)
+	bodies := []string{}
+	for _, revision := range revisions {
+		bodies = append(bodies, revision.Body)
+	}
+	if want := []string{"second ****", "first"}; !slices.Equal(bodies, want) {
+		t.Errorf("GET %s/revisions\nbodies = %v\nwantBodies = %v", path, bodies, want)
+	}
+}
+
+func TestDeleteChirpAuthorization(t *testing.T) {
+	server, apiCfg := newTestServerWithConfig(t)
+	alice := createTestUser(t, server, "alice@example.com")
+	bob := createTestUser(t, server, "bob@example.com")
+	admin := createTestUser(t, server, "admin@example.com")
+	apiCfg.db.SetUserAdmin(context.Background(), database.SetUserAdminParams{Email: "admin@example.com", IsAdmin: true})
+
+	// Bob has chirped too, so owning some chirp is not enough to delete
+	// someone else's.
+	doRequest(t, server, "POST", "/api/chirps", bob.AccessToken, map[string]string{"body": "bob's chirp"}, nil)
+
+	newChirp := func() string {
+		chirp := Chirp{}
+		doRequest(t, server, "POST", "/api/chirps", alice.AccessToken, map[string]string{"body": "alice's chirp"}, &chirp)
+		return chirp.Id.String()
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		chirpId  string
+		wantCode int
+	}{
+		{"Other user", bob.AccessToken, newChirp(), http.StatusForbidden},
+		{"Author", alice.AccessToken, newChirp(), http.StatusNoContent},
+		{"Admin", admin.AccessToken, newChirp(), http.StatusNoContent},
+		{"Missing token", "", newChirp(), http.StatusUnauthorized},
+		{"Unknown chirp", alice.AccessToken, uuid.New().String(), http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := "/api/chirps/" + test.chirpId
+			code := doRequest(t, server, "DELETE", path, test.token, nil, nil)
+			if code != test.wantCode {
+				t.Errorf("DELETE %s\ncode = %d\nwantCode = %d", path, code, test.wantCode)
+			}
+
+			if test.wantCode == http.StatusForbidden {
+				if code := doRequest(t, server, "GET", path, "", nil, nil); code != http.StatusOK {
+					t.Errorf("GET %s after forbidden delete\ncode = %d\nwantCode = %d", path, code, http.StatusOK)
+				}
+			}
+		})
+	}
+}
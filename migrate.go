@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/fernando8franco/http-server-golang/internal/migrate"
+	"github.com/fernando8franco/http-server-golang/internal/store"
+)
+
+//go:embed sql/schema/*.sql sql/sqlite/schema/*.sql
+var schemaFS embed.FS
+
+func newMigrator(db *sql.DB, backend string) (*migrate.Migrator, error) {
+	dir, dialect := "sql/schema", migrate.Postgres
+	if backend == store.BackendSQLite {
+		dir, dialect = "sql/sqlite/schema", migrate.SQLite
+	}
+
+	schema, err := fs.Sub(schemaFS, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return migrate.New(db, dialect, schema)
+}
+
+func runMigrate(db *sql.DB, backend string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s migrate up|down|status", os.Args[0])
+	}
+
+	migrator, err := newMigrator(db, backend)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			log.Printf("Applied migration %s", migration.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			log.Println("No pending migrations")
+		}
+	case "down":
+		migration, err := migrator.Down(ctx)
+		if err != nil {
+			return err
+		}
+		log.Printf("Rolled back migration %s", migration.Name)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		tw.Flush()
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+
+	return nil
+}
+
+func autoMigrate(db *sql.DB, backend string) error {
+	migrator, err := newMigrator(db, backend)
+	if err != nil {
+		return err
+	}
+
+	applied, err := migrator.Up(context.Background())
+	for _, migration := range applied {
+		log.Printf("Applied migration %s", migration.Name)
+	}
+
+	return err
+}
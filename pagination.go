@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+var ErrInvalidLimit = errors.New("invalid limit")
+
+// The listings a cursor can page through. Their keys differ, so a cursor of
+// one listing means nothing to the other.
+const (
+	cursorKindList   = "list"
+	cursorKindSearch = "search"
+)
+
+// cursor points at the last chirp of a page. It is handed to clients as an
+// opaque base64 string so the key can change without breaking them. Rank is
+// only used by search results, which are sorted by relevance first.
+type cursor struct {
+	Kind      string
+	Rank      float64
+	CreatedAt time.Time
+	Id        uuid.UUID
+}
+
+func (c cursor) encode() string {
+	key := strings.Join([]string{
+		c.Kind,
+		strconv.FormatFloat(c.Rank, 'g', -1, 64),
+		c.CreatedAt.UTC().Format(time.RFC3339Nano),
+		c.Id.String(),
+	}, "|")
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeCursor(s string) (cursor, error) {
+	key, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	parts := strings.Split(string(key), "|")
+	if len(parts) != 4 {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	kind := parts[0]
+	if kind != cursorKindList && kind != cursorKindSearch {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	rank, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[2])
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	id, err := uuid.Parse(parts[3])
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	return cursor{
+		Kind:      kind,
+		Rank:      rank,
+		CreatedAt: createdAt,
+		Id:        id,
+	}, nil
+}
+
+// parsePageSize reads the limit query parameter. Values above maxPageSize are
+// capped instead of rejected.
+func parsePageSize(query url.Values) (int, error) {
+	limitStr := query.Get("limit")
+	if limitStr == "" {
+		return defaultPageSize, nil
+	}
+
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit < 1 {
+		return 0, ErrInvalidLimit
+	}
+
+	return min(limit, maxPageSize), nil
+}
+
+// setNextLink adds a Link header pointing at the next page, keeping
+// every other query parameter of the current request.
+func setNextLink(w http.ResponseWriter, r *http.Request, next cursor) {
+	query := r.URL.Query()
+	query.Set("cursor", next.encode())
+
+	nextURL := url.URL{
+		Path:     r.URL.Path,
+		RawQuery: query.Encode(),
+	}
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextURL.String()))
+}
@@ -6,21 +6,89 @@ RETURNING *;
 -- name: DeleteChirp :exec
 DELETE FROM chirps;
 
--- name: GetAllChirps :many
+-- name: GetOneChirp :one
 SELECT * FROM chirps
-ORDER BY created_at ASC;
+WHERE id = $1
+AND deleted_at IS NULL;
 
--- name: GetOneChirp :one
+-- name: GetChirpIncludingDeleted :one
 SELECT * FROM chirps
 WHERE id = $1;
 
--- name: UserOwnsChirp :one
-SELECT EXISTS (
-    SELECT 1
-    FROM chirps
-    WHERE user_id = $1
-);
+-- name: SoftDeleteChirpById :execrows
+UPDATE chirps
+SET deleted_at = NOW(), updated_at = NOW()
+WHERE id = $1
+AND deleted_at IS NULL;
+
+-- name: RestoreChirp :one
+UPDATE chirps
+SET deleted_at = NULL, updated_at = NOW()
+WHERE id = sqlc.arg('id')
+AND deleted_at > NOW() - sqlc.arg('window_seconds')::float8 * INTERVAL '1 second'
+RETURNING *;
 
--- name: DeleteChirpById :exec
+-- name: PurgeDeletedChirps :execrows
 DELETE FROM chirps
-WHERE id = $1;
\ No newline at end of file
+WHERE deleted_at < NOW() - sqlc.arg('retention_seconds')::float8 * INTERVAL '1 second';
+
+-- name: ListChirps :many
+SELECT * FROM chirps
+WHERE deleted_at IS NULL
+AND (sqlc.narg('after_created_at')::timestamp IS NULL
+    OR (created_at, id) > (sqlc.narg('after_created_at')::timestamp, sqlc.narg('after_id')::uuid))
+AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id')::uuid)
+AND (sqlc.narg('since')::timestamp IS NULL OR created_at >= sqlc.narg('since')::timestamp)
+AND (sqlc.narg('until')::timestamp IS NULL OR created_at < sqlc.narg('until')::timestamp)
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('page_size');
+
+-- name: ListChirpsDesc :many
+SELECT * FROM chirps
+WHERE deleted_at IS NULL
+AND (sqlc.narg('after_created_at')::timestamp IS NULL
+    OR (created_at, id) < (sqlc.narg('after_created_at')::timestamp, sqlc.narg('after_id')::uuid))
+AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id')::uuid)
+AND (sqlc.narg('since')::timestamp IS NULL OR created_at >= sqlc.narg('since')::timestamp)
+AND (sqlc.narg('until')::timestamp IS NULL OR created_at < sqlc.narg('until')::timestamp)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_size');
+
+-- name: SearchChirps :many
+SELECT * FROM (
+    SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.edited_at,
+        ts_rank(to_tsvector('english', chirps.body), query)::float8 AS rank,
+        ts_headline('english', chirps.body, query, 'StartSel=' || chr(57344) || ', StopSel=' || chr(57345) || ', HighlightAll=true')::text AS snippet
+    FROM chirps, to_tsquery('english', sqlc.arg('query')) AS query
+    WHERE to_tsvector('english', chirps.body) @@ query
+    AND chirps.deleted_at IS NULL
+    AND (sqlc.narg('author_id')::uuid IS NULL OR chirps.user_id = sqlc.narg('author_id')::uuid)
+    AND (sqlc.narg('since')::timestamp IS NULL OR chirps.created_at >= sqlc.narg('since')::timestamp)
+    AND (sqlc.narg('until')::timestamp IS NULL OR chirps.created_at < sqlc.narg('until')::timestamp)
+) AS results
+WHERE sqlc.narg('after_rank')::float8 IS NULL
+OR (rank, created_at, id) < (sqlc.narg('after_rank')::float8, sqlc.narg('after_created_at')::timestamp, sqlc.narg('after_id')::uuid)
+ORDER BY rank DESC, created_at DESC, id DESC
+LIMIT sqlc.arg('page_size');
+
+-- name: UpdateChirpBody :one
+WITH locked AS (
+    SELECT chirps.id, chirps.body FROM chirps
+    WHERE chirps.id = $1
+    AND chirps.deleted_at IS NULL
+    FOR UPDATE
+), previous AS (
+    INSERT INTO chirp_revisions (id, chirp_id, body, created_at)
+    SELECT gen_random_uuid(), locked.id, locked.body, NOW() FROM locked
+    RETURNING chirp_id
+)
+UPDATE chirps
+SET body = $2, updated_at = NOW(), edited_at = NOW()
+FROM previous
+WHERE chirps.id = previous.chirp_id
+RETURNING chirps.*;
+
+-- name: ListChirpRevisions :many
+SELECT * FROM chirp_revisions
+WHERE chirp_id = $1
+ORDER BY created_at DESC, id DESC;
\ No newline at end of file
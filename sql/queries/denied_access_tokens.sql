@@ -0,0 +1,27 @@
+-- name: DenyFamilyAccessTokens :execrows
+INSERT INTO denied_access_tokens (jti, expires_at)
+SELECT access_token_jti, NOW() + sqlc.arg('expires_in_seconds')::float8 * INTERVAL '1 second' FROM refresh_tokens
+WHERE family_id = sqlc.arg('family_id')
+AND created_at > NOW() - sqlc.arg('issued_within_seconds')::float8 * INTERVAL '1 second'
+AND access_token_jti IS NOT NULL
+ON CONFLICT (jti) DO NOTHING;
+
+-- name: DenyUserAccessTokens :execrows
+INSERT INTO denied_access_tokens (jti, expires_at)
+SELECT access_token_jti, NOW() + sqlc.arg('expires_in_seconds')::float8 * INTERVAL '1 second' FROM refresh_tokens
+WHERE user_id = sqlc.arg('user_id')
+AND created_at > NOW() - sqlc.arg('issued_within_seconds')::float8 * INTERVAL '1 second'
+AND access_token_jti IS NOT NULL
+AND access_token_jti <> sqlc.arg('except_jti')::text
+ON CONFLICT (jti) DO NOTHING;
+
+-- name: IsAccessTokenDenied :one
+SELECT EXISTS (
+    SELECT 1 FROM denied_access_tokens
+    WHERE jti = $1
+    AND expires_at > NOW()
+);
+
+-- name: PurgeDeniedAccessTokens :execrows
+DELETE FROM denied_access_tokens
+WHERE expires_at <= NOW();
\ No newline at end of file
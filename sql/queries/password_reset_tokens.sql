@@ -0,0 +1,32 @@
+-- name: CreatePasswordResetToken :one
+INSERT INTO password_reset_tokens (token_hash, user_id, created_at, expires_at)
+SELECT
+    sqlc.arg('token_hash')::text,
+    sqlc.arg('user_id')::uuid,
+    NOW(),
+    NOW() + sqlc.arg('expires_in_seconds')::float8 * INTERVAL '1 second'
+WHERE (
+    SELECT COUNT(*) FROM password_reset_tokens
+    WHERE user_id = sqlc.arg('user_id')::uuid
+    AND created_at > NOW() - sqlc.arg('window_seconds')::float8 * INTERVAL '1 second'
+) < sqlc.arg('max_tokens')::integer
+RETURNING *;
+
+-- name: DeletePasswordResetTokens :exec
+DELETE FROM password_reset_tokens
+WHERE user_id = $1;
+
+-- name: GetPasswordResetTokenEmail :one
+SELECT users.email FROM password_reset_tokens
+JOIN users ON users.id = password_reset_tokens.user_id
+WHERE password_reset_tokens.token_hash = $1
+AND password_reset_tokens.used_at IS NULL
+AND password_reset_tokens.expires_at > NOW();
+
+-- name: UsePasswordResetToken :one
+UPDATE password_reset_tokens
+SET used_at = NOW()
+WHERE token_hash = $1
+AND used_at IS NULL
+AND expires_at > NOW()
+RETURNING user_id;
\ No newline at end of file
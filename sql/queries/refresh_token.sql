@@ -1,15 +1,69 @@
 -- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at)
-VALUES ($1, NOW(), NOW(), $2, NOW() + INTERVAL '60 days')
+INSERT INTO refresh_tokens (token_hash, created_at, updated_at, user_id, expires_at, family_id, user_agent, ip_address, session_started_at, access_token_jti)
+VALUES ($1, NOW(), NOW(), $2, NOW() + INTERVAL '60 days', $3, $4, $5, NOW(), $6)
 RETURNING *;
 
+-- name: GetRefreshToken :one
+SELECT * FROM refresh_tokens
+WHERE token_hash = $1;
+
 -- name: GetUserIdFromRefreshToken :one
 SELECT user_id FROM refresh_tokens
-WHERE token = $1
+WHERE token_hash = $1
 AND revoked_at IS NULL
 AND expires_at > NOW();
 
+-- name: ListSessions :many
+SELECT * FROM refresh_tokens
+WHERE user_id = $1
+AND revoked_at IS NULL
+AND expires_at > NOW()
+ORDER BY session_started_at DESC, family_id;
+
+-- name: RevokeAllSessions :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1
+AND revoked_at IS NULL;
+
+-- name: RevokeOtherSessions :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = sqlc.arg('user_id')
+AND revoked_at IS NULL
+AND family_id NOT IN (
+    SELECT family_id FROM refresh_tokens
+    WHERE access_token_jti = sqlc.arg('access_token_jti')::text
+);
+
+-- name: RevokeRefreshTokenFamily :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = $1
+AND revoked_at IS NULL;
+
+-- name: RevokeSession :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = $1
+AND user_id = $2
+AND revoked_at IS NULL;
+
+-- name: RotateRefreshToken :one
+WITH consumed AS (
+    UPDATE refresh_tokens
+    SET revoked_at = NOW(), updated_at = NOW()
+    WHERE token_hash = sqlc.arg('token_hash')
+    AND revoked_at IS NULL
+    AND expires_at > NOW()
+    RETURNING user_id, family_id, user_agent, ip_address, session_started_at
+)
+INSERT INTO refresh_tokens (token_hash, created_at, updated_at, user_id, expires_at, family_id, user_agent, ip_address, session_started_at, last_used_at, access_token_jti)
+SELECT sqlc.arg('new_token_hash'), NOW(), NOW(), user_id, NOW() + INTERVAL '60 days', family_id, user_agent, ip_address, session_started_at, NOW(), sqlc.narg('access_token_jti')
+FROM consumed
+RETURNING *;
+
 -- name: SetRevokedAt :exec
 UPDATE refresh_tokens
 SET revoked_at = NOW(), updated_at = NOW()
-WHERE token = $1;
\ No newline at end of file
+WHERE token_hash = $1;
\ No newline at end of file
@@ -0,0 +1,19 @@
+-- name: AttemptTwoFactorChallenge :execrows
+UPDATE two_factor_challenges
+SET attempts = attempts + 1
+WHERE id = sqlc.arg('id')
+AND user_id = sqlc.arg('user_id')
+AND attempts < sqlc.arg('max_attempts')::integer
+AND expires_at > NOW();
+
+-- name: CreateTwoFactorChallenge :exec
+INSERT INTO two_factor_challenges (id, user_id, expires_at)
+VALUES ($1, $2, NOW() + sqlc.arg('expires_in_seconds')::float8 * INTERVAL '1 second');
+
+-- name: DeleteTwoFactorChallenge :execrows
+DELETE FROM two_factor_challenges
+WHERE id = $1;
+
+-- name: PurgeTwoFactorChallenges :execrows
+DELETE FROM two_factor_challenges
+WHERE expires_at <= NOW();
\ No newline at end of file
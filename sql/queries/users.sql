@@ -10,9 +10,14 @@ DELETE FROM users;
 SELECT * FROM users
 WHERE email = $1;
 
+-- name: GetUserById :one
+SELECT * FROM users
+WHERE id = $1;
+
 -- name: UpdateUser :one
 UPDATE users
-SET updated_at = NOW(), email = $1, hashed_password = $2
+SET updated_at = NOW(), email = $1, hashed_password = $2,
+    email_verified_at = CASE WHEN email = $1 THEN email_verified_at ELSE NULL END
 WHERE id = $3
 RETURNING *;
 
@@ -20,4 +25,71 @@ RETURNING *;
 UPDATE users
 SET updated_at = NOW(), is_chirpy_red = TRUE
 WHERE id = $1
-RETURNING *;
\ No newline at end of file
+RETURNING *;
+
+-- name: SetUserAdmin :one
+UPDATE users
+SET updated_at = NOW(), is_admin = $2
+WHERE email = $1
+RETURNING *;
+
+-- name: SetUserSuspended :one
+UPDATE users
+SET updated_at = NOW(), suspended_at = CASE WHEN sqlc.arg('suspended')::boolean THEN NOW() ELSE NULL END
+WHERE email = sqlc.arg('email')
+RETURNING *;
+
+-- name: SetUserTOTPSecret :one
+UPDATE users
+SET updated_at = NOW(), totp_secret = $2, totp_enabled_at = NULL, totp_last_step = NULL
+WHERE id = $1
+RETURNING *;
+
+-- name: EnableUserTOTP :one
+UPDATE users
+SET updated_at = NOW(), totp_enabled_at = NOW()
+WHERE id = $1
+AND totp_secret IS NOT NULL
+RETURNING *;
+
+-- name: UseTOTPStep :execrows
+UPDATE users
+SET totp_last_step = sqlc.arg('step')::bigint
+WHERE id = sqlc.arg('id')
+AND (totp_last_step IS NULL OR totp_last_step < sqlc.arg('step')::bigint);
+
+-- name: VerifyUserEmail :one
+UPDATE users
+SET updated_at = NOW(), email_verified_at = COALESCE(email_verified_at, NOW())
+WHERE id = $1
+AND email = $2
+RETURNING *;
+
+-- name: UpdateUserPassword :exec
+UPDATE users
+SET updated_at = NOW(), hashed_password = $2
+WHERE id = $1;
+
+-- name: RehashUserPassword :exec
+UPDATE users
+SET hashed_password = sqlc.arg('hashed_password')
+WHERE id = sqlc.arg('id')
+AND hashed_password = sqlc.arg('old_hashed_password');
+
+-- name: GetUserByHandle :one
+SELECT * FROM users
+WHERE LOWER(handle) = LOWER($1);
+
+-- name: PatchUser :one
+UPDATE users
+SET updated_at = NOW(),
+    email = COALESCE(sqlc.narg('email'), email),
+    hashed_password = COALESCE(sqlc.narg('hashed_password'), hashed_password),
+    email_verified_at = CASE WHEN email = COALESCE(sqlc.narg('email'), email) THEN email_verified_at ELSE NULL END,
+    handle = sqlc.arg('handle'), display_name = sqlc.arg('display_name'), bio = sqlc.arg('bio'), avatar_url = sqlc.arg('avatar_url')
+WHERE id = sqlc.arg('id')
+RETURNING *;
+
+-- name: ListChirpAuthors :many
+SELECT id, handle, display_name, avatar_url FROM users
+WHERE id = ANY(sqlc.arg('ids')::uuid[]);
\ No newline at end of file
@@ -0,0 +1,24 @@
+-- +goose Up
+ALTER TABLE refresh_tokens
+ADD COLUMN user_agent TEXT NOT NULL DEFAULT '',
+ADD COLUMN ip_address TEXT NOT NULL DEFAULT '',
+ADD COLUMN session_started_at TIMESTAMP,
+ADD COLUMN last_used_at TIMESTAMP;
+
+UPDATE refresh_tokens
+SET session_started_at = created_at;
+
+ALTER TABLE refresh_tokens
+ALTER COLUMN session_started_at SET NOT NULL;
+
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id)
+WHERE revoked_at IS NULL;
+
+-- +goose Down
+DROP INDEX refresh_tokens_user_id_idx;
+
+ALTER TABLE refresh_tokens
+DROP COLUMN user_agent,
+DROP COLUMN ip_address,
+DROP COLUMN session_started_at,
+DROP COLUMN last_used_at;
\ No newline at end of file
@@ -0,0 +1,17 @@
+-- +goose Up
+ALTER TABLE refresh_tokens
+ADD COLUMN family_id TEXT NOT NULL DEFAULT '';
+
+-- Every existing token starts its own family. The tokens are 64 hex
+-- characters, so their prefix already has the shape of a UUID.
+UPDATE refresh_tokens
+SET family_id = substr(token, 1, 8) || '-' || substr(token, 9, 4) || '-' ||
+    substr(token, 13, 4) || '-' || substr(token, 17, 4) || '-' || substr(token, 21, 12);
+
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+
+-- +goose Down
+DROP INDEX refresh_tokens_family_id_idx;
+
+ALTER TABLE refresh_tokens
+DROP COLUMN family_id;
\ No newline at end of file
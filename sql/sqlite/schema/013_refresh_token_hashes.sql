@@ -0,0 +1,15 @@
+-- +goose Up
+-- sha256_hex is registered by the store package when it opens the database.
+ALTER TABLE refresh_tokens
+RENAME COLUMN token TO token_hash;
+
+UPDATE refresh_tokens
+SET token_hash = sha256_hex(token_hash);
+
+-- +goose Down
+-- The raw tokens can't be recovered from their digests, so every session has
+-- to log in again.
+DELETE FROM refresh_tokens;
+
+ALTER TABLE refresh_tokens
+RENAME COLUMN token_hash TO token;
\ No newline at end of file
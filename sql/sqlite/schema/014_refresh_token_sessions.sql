@@ -0,0 +1,35 @@
+-- +goose Up
+ALTER TABLE refresh_tokens
+ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE refresh_tokens
+ADD COLUMN ip_address TEXT NOT NULL DEFAULT '';
+
+-- SQLite can't add a NOT NULL column without a constant default, so this one
+-- stays nullable and the store always sets it.
+ALTER TABLE refresh_tokens
+ADD COLUMN session_started_at TIMESTAMP;
+
+ALTER TABLE refresh_tokens
+ADD COLUMN last_used_at TIMESTAMP;
+
+UPDATE refresh_tokens
+SET session_started_at = created_at;
+
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id)
+WHERE revoked_at IS NULL;
+
+-- +goose Down
+DROP INDEX refresh_tokens_user_id_idx;
+
+ALTER TABLE refresh_tokens
+DROP COLUMN last_used_at;
+
+ALTER TABLE refresh_tokens
+DROP COLUMN session_started_at;
+
+ALTER TABLE refresh_tokens
+DROP COLUMN ip_address;
+
+ALTER TABLE refresh_tokens
+DROP COLUMN user_agent;
\ No newline at end of file
@@ -0,0 +1,22 @@
+-- +goose Up
+CREATE TABLE denied_access_tokens (
+    jti TEXT PRIMARY KEY,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX denied_access_tokens_expires_at_idx ON denied_access_tokens (expires_at);
+
+ALTER TABLE refresh_tokens
+ADD COLUMN access_token_jti TEXT;
+
+ALTER TABLE users
+ADD COLUMN suspended_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE users
+DROP COLUMN suspended_at;
+
+ALTER TABLE refresh_tokens
+DROP COLUMN access_token_jti;
+
+DROP TABLE denied_access_tokens;
\ No newline at end of file
@@ -0,0 +1,35 @@
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN totp_secret TEXT;
+
+ALTER TABLE users
+ADD COLUMN totp_enabled_at TIMESTAMP;
+
+ALTER TABLE users
+ADD COLUMN totp_last_step INTEGER;
+
+CREATE TABLE recovery_codes (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    code_hash TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
+
+CREATE INDEX recovery_codes_user_id_idx ON recovery_codes (user_id);
+
+-- +goose Down
+DROP TABLE recovery_codes;
+
+ALTER TABLE users
+DROP COLUMN totp_last_step;
+
+ALTER TABLE users
+DROP COLUMN totp_enabled_at;
+
+ALTER TABLE users
+DROP COLUMN totp_secret;
\ No newline at end of file
@@ -0,0 +1,29 @@
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN handle TEXT;
+
+ALTER TABLE users
+ADD COLUMN display_name TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE users
+ADD COLUMN bio TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE users
+ADD COLUMN avatar_url TEXT NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX users_handle_idx ON users (LOWER(handle));
+
+-- +goose Down
+DROP INDEX users_handle_idx;
+
+ALTER TABLE users
+DROP COLUMN avatar_url;
+
+ALTER TABLE users
+DROP COLUMN bio;
+
+ALTER TABLE users
+DROP COLUMN display_name;
+
+ALTER TABLE users
+DROP COLUMN handle;
\ No newline at end of file
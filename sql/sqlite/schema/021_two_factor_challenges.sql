@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE two_factor_challenges (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP NOT NULL,
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE two_factor_challenges;
\ No newline at end of file
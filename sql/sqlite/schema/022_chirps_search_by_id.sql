@@ -0,0 +1,70 @@
+-- +goose Up
+-- chirps has no INTEGER PRIMARY KEY, so its rowids are implicit and VACUUM
+-- may renumber them. The index keeps its own copy of the body keyed on the
+-- chirp id instead of pointing at the rowids.
+DROP TRIGGER chirps_fts_update;
+DROP TRIGGER chirps_fts_delete;
+DROP TRIGGER chirps_fts_insert;
+DROP TABLE chirps_fts;
+
+CREATE VIRTUAL TABLE chirps_fts USING fts5 (
+    id UNINDEXED,
+    body,
+    tokenize = 'porter unicode61'
+);
+
+INSERT INTO chirps_fts (id, body)
+SELECT id, body FROM chirps;
+
+-- +goose StatementBegin
+CREATE TRIGGER chirps_fts_insert AFTER INSERT ON chirps BEGIN
+    INSERT INTO chirps_fts (id, body) VALUES (new.id, new.body);
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER chirps_fts_delete AFTER DELETE ON chirps BEGIN
+    DELETE FROM chirps_fts WHERE id = old.id;
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER chirps_fts_update AFTER UPDATE OF body ON chirps BEGIN
+    UPDATE chirps_fts SET body = new.body WHERE id = old.id;
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TRIGGER chirps_fts_update;
+DROP TRIGGER chirps_fts_delete;
+DROP TRIGGER chirps_fts_insert;
+DROP TABLE chirps_fts;
+
+CREATE VIRTUAL TABLE chirps_fts USING fts5 (
+    body,
+    content = 'chirps',
+    content_rowid = 'rowid',
+    tokenize = 'porter unicode61'
+);
+
+INSERT INTO chirps_fts (rowid, body)
+SELECT rowid, body FROM chirps;
+
+-- +goose StatementBegin
+CREATE TRIGGER chirps_fts_insert AFTER INSERT ON chirps BEGIN
+    INSERT INTO chirps_fts (rowid, body) VALUES (new.rowid, new.body);
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER chirps_fts_delete AFTER DELETE ON chirps BEGIN
+    INSERT INTO chirps_fts (chirps_fts, rowid, body) VALUES ('delete', old.rowid, old.body);
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER chirps_fts_update AFTER UPDATE OF body ON chirps BEGIN
+    INSERT INTO chirps_fts (chirps_fts, rowid, body) VALUES ('delete', old.rowid, old.body);
+    INSERT INTO chirps_fts (rowid, body) VALUES (new.rowid, new.body);
+END;
+-- +goose StatementEnd
\ No newline at end of file